@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"encoding/base64"
+	"fmt"
+	"ocf/internal/protocol"
+	"os"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/spf13/cobra"
+)
+
+var identityCmd = &cobra.Command{
+	Use:   "identity",
+	Short: "Node identity (libp2p key) management commands",
+}
+
+var identityShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the peer ID and key of this node",
+	Run: func(cmd *cobra.Command, args []string) {
+		keyPath := protocol.IdentityKeyPath()
+		priv, err := protocol.LoadIdentityKey(keyPath)
+		if err != nil {
+			fmt.Printf("Failed to load identity from %s: %v\n", keyPath, err)
+			fmt.Println("Run `ocf identity generate` to create one.")
+			return
+		}
+		printIdentity(keyPath, priv)
+	},
+}
+
+var identityGenerateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "Generate a new identity key",
+	Run: func(cmd *cobra.Command, args []string) {
+		keyPath := protocol.IdentityKeyPath()
+		force, _ := cmd.Flags().GetBool("force")
+		if _, err := os.Stat(keyPath); err == nil && !force {
+			fmt.Printf("An identity already exists at %s. Use `ocf identity rotate` or --force to replace it.\n", keyPath)
+			return
+		}
+
+		keyType, _ := cmd.Flags().GetString("type")
+		priv, err := protocol.GenerateIdentityKey(keyType)
+		if err != nil {
+			fmt.Printf("Failed to generate identity: %v\n", err)
+			return
+		}
+		if err := protocol.SaveIdentityKey(keyPath, priv); err != nil {
+			fmt.Printf("Failed to save identity: %v\n", err)
+			return
+		}
+		printIdentity(keyPath, priv)
+	},
+}
+
+var identityImportCmd = &cobra.Command{
+	Use:   "import <key-file>",
+	Short: "Import an identity key (raw protobuf or base64 encoded)",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		keyPath := protocol.IdentityKeyPath()
+		force, _ := cmd.Flags().GetBool("force")
+		if _, err := os.Stat(keyPath); err == nil && !force {
+			fmt.Printf("An identity already exists at %s. Use --force to replace it.\n", keyPath)
+			return
+		}
+
+		data, err := os.ReadFile(args[0])
+		if err != nil {
+			fmt.Printf("Failed to read key file: %v\n", err)
+			return
+		}
+		priv, err := protocol.ImportIdentityKey(keyPath, data)
+		if err != nil {
+			fmt.Printf("Failed to import identity: %v\n", err)
+			return
+		}
+		printIdentity(keyPath, priv)
+	},
+}
+
+var identityExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Print the base64 encoded identity key",
+	Run: func(cmd *cobra.Command, args []string) {
+		encoded, err := protocol.ExportIdentityKey(protocol.IdentityKeyPath())
+		if err != nil {
+			fmt.Printf("Failed to export identity: %v\n", err)
+			return
+		}
+
+		output, _ := cmd.Flags().GetString("output")
+		if output == "" {
+			fmt.Println(encoded)
+			return
+		}
+		if err := os.WriteFile(output, []byte(encoded), 0o600); err != nil {
+			fmt.Printf("Failed to write %s: %v\n", output, err)
+			return
+		}
+		fmt.Printf("Identity exported to %s\n", output)
+	},
+}
+
+var identityRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Replace the identity key, keeping a backup of the old one",
+	Run: func(cmd *cobra.Command, args []string) {
+		keyPath := protocol.IdentityKeyPath()
+		if old, err := protocol.LoadIdentityKey(keyPath); err == nil {
+			if id, err := protocol.PeerIDFromKey(old); err == nil {
+				fmt.Printf("Previous peer ID: %s\n", id)
+			}
+		}
+
+		keyType, _ := cmd.Flags().GetString("type")
+		priv, backup, err := protocol.RotateIdentityKey(keyPath, keyType)
+		if err != nil {
+			fmt.Printf("Failed to rotate identity: %v\n", err)
+			return
+		}
+		if backup != "" {
+			fmt.Printf("Previous key saved to %s\n", backup)
+		}
+		printIdentity(keyPath, priv)
+		fmt.Println("Restart the node for the new identity to take effect.")
+	},
+}
+
+func printIdentity(keyPath string, priv crypto.PrivKey) {
+	id, err := protocol.PeerIDFromKey(priv)
+	if err != nil {
+		fmt.Printf("Failed to derive peer ID: %v\n", err)
+		return
+	}
+	fmt.Printf("Peer ID:    %s\n", id)
+	fmt.Printf("Key type:   %s\n", protocol.KeyTypeName(priv))
+	fmt.Printf("Key path:   %s\n", keyPath)
+	if pub, err := crypto.MarshalPublicKey(priv.GetPublic()); err == nil {
+		fmt.Printf("Public key: %s\n", base64.StdEncoding.EncodeToString(pub))
+	}
+}
+
+func init() {
+	identityCmd.PersistentFlags().String("identity.key_path", "", "path to the node identity key (default is <home>/keys/id)")
+	identityGenerateCmd.Flags().String("type", protocol.KeyTypeEd25519, "key type (ed25519, rsa)")
+	identityGenerateCmd.Flags().Bool("force", false, "overwrite an existing identity")
+	identityImportCmd.Flags().Bool("force", false, "overwrite an existing identity")
+	identityExportCmd.Flags().String("output", "", "write the key to a file instead of stdout")
+	identityRotateCmd.Flags().String("type", protocol.KeyTypeEd25519, "key type (ed25519, rsa)")
+
+	identityCmd.AddCommand(identityShowCmd)
+	identityCmd.AddCommand(identityGenerateCmd)
+	identityCmd.AddCommand(identityImportCmd)
+	identityCmd.AddCommand(identityExportCmd)
+	identityCmd.AddCommand(identityRotateCmd)
+	rootcmd.AddCommand(identityCmd)
+}
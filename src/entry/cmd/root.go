@@ -8,13 +8,13 @@ import (
 	"strconv"
 	"strings"
 
-	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
 var cfgFile string
+var homePath string
 var rootcmd = &cobra.Command{
 	Use:   "ocfcore",
 	Short: "ocfcore",
@@ -32,25 +32,97 @@ var rootcmd = &cobra.Command{
 
 //nolint:gochecknoinits
 func init() {
-	rootcmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.config/ocf/cfg.yaml)")
+	rootcmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is <home>/cfg.yaml with --home, else $HOME/.config/ocf/cfg.yaml)")
+	rootcmd.PersistentFlags().StringVar(&homePath, "home", "", "root directory for all node state: database, keys, wallets and config (default is $"+common.HomeEnv+", else $HOME/.ocfcore)")
 
 	startCmd.Flags().String("wallet.account", "", "wallet account")
 	startCmd.Flags().String("account.wallet", "", "path to wallet key file")
 	startCmd.Flags().String("bootstrap.addr", "http://152.67.71.5:8092/v1/dnt/bootstraps", "bootstrap address")
 	startCmd.Flags().StringSlice("bootstrap.source", nil, "bootstrap source (HTTP URL, dnsaddr://host, or multiaddr). Repeatable")
 	startCmd.Flags().StringSlice("bootstrap.static", nil, "static bootstrap multiaddr (repeatable)")
-	startCmd.Flags().String("seed", "0", "Seed")
+	startCmd.Flags().String("seed", "0", "Seed for a deterministic identity (local/test mode only)")
+	startCmd.Flags().String("identity.key_path", "", "path to the node identity key (default is <home>/keys/id)")
+	startCmd.Flags().String("identity.key_type", "ed25519", "key type generated when no identity exists (ed25519, rsa)")
 	startCmd.Flags().String("mode", "node", "Mode (standalone, local, full)")
 	startCmd.Flags().String("tcpport", "43905", "TCP Port")
 	startCmd.Flags().String("udpport", "59820", "UDP Port")
 	startCmd.Flags().String("subprocess", "", "Subprocess to start")
 	startCmd.Flags().String("public-addr", "", "Public address if you have one (by setting this, you can be a bootstrap node)")
+	startCmd.Flags().StringSlice("p2p.listen_addrs", nil, "multiaddrs to listen on, replacing the defaults derived from tcpport/udpport (repeatable)")
+	startCmd.Flags().StringSlice("p2p.announce_addrs", nil, "multiaddrs to announce instead of the discovered ones (repeatable)")
+	startCmd.Flags().StringSlice("p2p.no_announce_addrs", nil, "multiaddrs or CIDRs that are never announced (repeatable)")
+	startCmd.Flags().StringSlice("p2p.disable_transports", nil, "transports to disable: tcp, ws, quic, webtransport (repeatable)")
+	startCmd.Flags().Bool("dht.service_discovery", true, "advertise local services in the DHT and fall back to DHT lookups when the node table has no providers")
+	startCmd.Flags().String("dht.lookup_timeout", "10s", "how long a DHT provider lookup may take")
+	startCmd.Flags().Bool("mdns.enabled", false, "discover and connect to peers on the local network via mDNS")
+	startCmd.Flags().String("mdns.service_name", "_ocf._udp", "mDNS service name; only nodes using the same name find each other")
+	startCmd.Flags().StringSlice("p2p.static_relays", nil, "relay multiaddrs to reserve a slot on when not publicly reachable (repeatable)")
+	startCmd.Flags().Int("routing.large_payload_bytes", 1<<20, "requests larger than this avoid providers only reachable through a relay")
+	startCmd.Flags().Bool("p2p.ipv6", true, "also listen on IPv6 interfaces")
+	startCmd.Flags().String("p2p.reachability", "public", "reachability to assume: public, private or auto (let AutoNAT decide)")
 	startCmd.Flags().String("service.name", "", "Service name")
 	startCmd.Flags().String("service.port", "", "Service port")
 	startCmd.Flags().String("solana.rpc", defaultConfig.Solana.RPC, "Solana RPC endpoint")
 	startCmd.Flags().String("solana.mint", defaultConfig.Solana.Mint, "SPL token mint to verify ownership")
 	startCmd.Flags().Bool("solana.skip_verification", defaultConfig.Solana.SkipVerification, "Skip Solana token ownership verification (use for testing only)")
-	startCmd.Flags().Bool("cleanslate", true, "Clean slate")
+	startCmd.Flags().Bool("cleanslate", true, "wipe the CRDT database on start and shutdown; set to false to keep state across restarts")
+	startCmd.Flags().String("crdt.data_dir", "", "directory holding the CRDT database (default is the home directory)")
+	startCmd.Flags().Bool("crdt.repair_on_start", false, "always walk and repair the persisted CRDT DAG on start, not only when it is dirty")
+	startCmd.Flags().String("crdt.repair_timeout", "10m", "how long the startup CRDT repair may take")
+	startCmd.Flags().String("heartbeat.interval", "20s", "how often to publish a signed liveness heartbeat")
+	startCmd.Flags().Bool("heartbeat.legacy_ping", true, "also publish the legacy ping understood by older nodes")
+	startCmd.Flags().String("crdt.lease_ttl", "30m", "how long our node table entry stays valid without renewal; it is renewed when a third is left")
+	startCmd.Flags().String("crdt.expired_tombstone_after", "1h", "how long an expired node table entry is kept before it is tombstoned")
+	startCmd.Flags().String("attestation.max_age", "168h", "how long a peer's owner attestation is accepted; our own is renewed when half of it has passed")
+	startCmd.Flags().Bool("crdt.legacy_peer_keys", true, "also write our peer record under the bare peer ID while nodes that only read it are in the node table")
+	startCmd.Flags().Int("crdt.min_peer_schema", 1, "ignore peer records written with an older schema version (1 is the unversioned format)")
+	startCmd.Flags().String("crdt.snapshot_file", "", "start a fresh CRDT store from this snapshot file (see GET /v1/dnt/snapshot)")
+	startCmd.Flags().Bool("crdt.snapshot_bootstrap", true, "start a fresh CRDT store from a snapshot served by a bootstrap peer")
+	startCmd.Flags().Bool("crdt.serve_snapshots", true, "serve CRDT snapshots to joining peers")
+	startCmd.Flags().String("crdt.broadcaster", "pubsub", "how CRDT heads reach other replicas: pubsub (gossipsub) or direct (streams to crdt.direct_peers)")
+	startCmd.Flags().StringSlice("crdt.direct_peers", nil, "peer IDs or /p2p/ multiaddrs the direct broadcaster exchanges heads with; required with crdt.broadcaster=direct and should be listed on both sides. Repeatable")
+	startCmd.Flags().Int("pubsub.d", 128, "gossipsub mesh degree")
+	startCmd.Flags().Int("pubsub.dlo", 16, "gossipsub mesh low watermark; more peers are grafted below it")
+	startCmd.Flags().Int("pubsub.dhi", 256, "gossipsub mesh high watermark; peers are pruned above it")
+	startCmd.Flags().Int("pubsub.dlazy", 0, "number of peers gossip is emitted to outside the mesh (0 keeps the gossipsub default)")
+	startCmd.Flags().String("pubsub.heartbeat_interval", "1s", "gossipsub heartbeat interval")
+	startCmd.Flags().Int("pubsub.max_message_size", 1<<20, "largest pubsub message accepted on any topic, in bytes")
+	startCmd.Flags().Int("pubsub.crdt_max_message_size", 64<<10, "largest CRDT broadcast accepted, in bytes; larger ones are rejected")
+	startCmd.Flags().Bool("pubsub.scoring", false, "score peers and stop gossiping with misbehaving ones")
+	startCmd.Flags().Float64("pubsub.reputation_weight", 10, "weight of the peer reputation in its gossipsub score")
+	startCmd.Flags().Float64("pubsub.gossip_threshold", -10, "score below which no gossip is exchanged with a peer")
+	startCmd.Flags().Float64("pubsub.publish_threshold", -50, "score below which our messages are not published to a peer")
+	startCmd.Flags().Float64("pubsub.graylist_threshold", -80, "score below which every message from a peer is ignored")
+	startCmd.Flags().Float64("pubsub.accept_px_threshold", 7, "score a peer needs for its peer exchange to be accepted")
+	startCmd.Flags().Float64("pubsub.opportunistic_graft_threshold", 5, "median mesh score below which better peers are grafted")
+	startCmd.Flags().Bool("crdt.checkpoint_enabled", true, "agree with peers on CRDT checkpoints below which history may be truncated")
+	startCmd.Flags().String("crdt.checkpoint_interval", "1h", "how often to propose a CRDT checkpoint")
+	startCmd.Flags().Int("crdt.checkpoint_depth", 1000, "checkpoints are proposed at multiples of this height, at least this far below the DAG heads")
+	startCmd.Flags().Int("crdt.checkpoint_quorum", 2, "number of nodes, including this one, that must propose a block before it becomes a checkpoint")
+	startCmd.Flags().Bool("crdt.prune_history", false, "remove DAG blocks below agreed checkpoints from the blockstore; fresh peers then need a snapshot to join")
+	startCmd.Flags().String("crdt.snapshot_timeout", "2m", "how long downloading or serving a CRDT snapshot may take")
+	startCmd.Flags().String("shutdown.drain_timeout", "2m", "how long shutdown waits for in-flight requests to finish")
+	startCmd.Flags().String("admin.token", "", "bearer token for the /v1/debug admin endpoints; they are disabled when empty")
+	startCmd.Flags().Bool("resources.enabled", true, "enforce libp2p resource limits")
+	startCmd.Flags().Int("resources.max_conns", 0, "maximum number of connections (0 keeps the scaled default)")
+	startCmd.Flags().Int("resources.max_streams", 0, "maximum number of streams (0 keeps the scaled default)")
+	startCmd.Flags().Int("resources.max_fds", 0, "maximum number of file descriptors (0 keeps the scaled default)")
+	startCmd.Flags().Int("resources.max_memory_mb", 0, "maximum memory reserved by libp2p in MiB (0 keeps the scaled default)")
+	startCmd.Flags().Int("resources.peer_max_conns", 0, "maximum connections per peer (0 keeps the scaled default)")
+	startCmd.Flags().Int("resources.peer_max_streams", 0, "maximum streams per peer (0 keeps the scaled default)")
+	startCmd.Flags().Int("resources.peer_max_memory_mb", 0, "maximum memory per peer in MiB (0 keeps the scaled default)")
+	startCmd.Flags().Int("resources.protocol_max_streams", 0, "maximum streams per protocol (0 keeps the scaled default)")
+	startCmd.Flags().Int("resources.protocol_max_memory_mb", 0, "maximum memory per protocol in MiB (0 keeps the scaled default)")
+	startCmd.Flags().Int("connmgr.low_water", 100, "connection count the connection manager trims down to")
+	startCmd.Flags().Int("connmgr.high_water", 400, "connection count above which the connection manager starts trimming")
+	startCmd.Flags().String("connmgr.grace_period", "1m", "how long new connections are exempt from trimming")
+	startCmd.Flags().StringSlice("connmgr.protected_peers", nil, "peer IDs that are never trimmed (repeatable)")
+	startCmd.Flags().StringSlice("access.allow_peers", nil, "only accept these peer IDs (repeatable)")
+	startCmd.Flags().StringSlice("access.deny_peers", nil, "reject these peer IDs (repeatable)")
+	startCmd.Flags().StringSlice("access.allow_owners", nil, "only route to providers with these verified owner wallets (repeatable)")
+	startCmd.Flags().StringSlice("access.deny_owners", nil, "reject providers owned by these wallets (repeatable)")
+	startCmd.Flags().StringSlice("access.allow_cidrs", nil, "only accept connections from these CIDRs (repeatable)")
+	startCmd.Flags().StringSlice("access.deny_cidrs", nil, "reject connections from these CIDRs (repeatable)")
 	rootcmd.AddCommand(initCmd)
 	rootcmd.AddCommand(startCmd)
 	rootcmd.AddCommand(versionCmd)
@@ -58,9 +130,12 @@ func init() {
 }
 
 func initConfig(cmd *cobra.Command) error {
-	var home string
 	var err error
 
+	// --home wins over $OCF_HOME; both relocate every path below.
+	common.SetHomePath(homePath)
+	configPath := common.GetConfigPath()
+
 	viper.SetDefault("crdt.tombstone_retention", "24h")
 	viper.SetDefault("crdt.tombstone_compaction_interval", "1h")
 	viper.SetDefault("crdt.tombstone_compaction_batch", 512)
@@ -71,13 +146,7 @@ func initConfig(cmd *cobra.Command) error {
 		// print out the config file
 		common.Logger.Info("Using config file: ", viper.ConfigFileUsed())
 	} else {
-		// Find home directory.
-		home, err = homedir.Dir()
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
-		viper.SetConfigFile(path.Join(home, ".config", "ocf", "cfg.yaml"))
+		viper.SetConfigFile(configPath)
 	}
 	if err = viper.ReadInConfig(); err != nil {
 		viper.SetDefault("path", defaultConfig.Path)
@@ -93,7 +162,6 @@ func initConfig(cmd *cobra.Command) error {
 		viper.SetDefault("solana.rpc", defaultConfig.Solana.RPC)
 		viper.SetDefault("solana.mint", defaultConfig.Solana.Mint)
 		viper.SetDefault("solana.skip_verification", defaultConfig.Solana.SkipVerification)
-		configPath := path.Join(home, ".config", "ocf", "cfg.yaml")
 		err = os.MkdirAll(path.Dir(configPath), os.ModePerm)
 		if err != nil {
 			common.Logger.Error("Could not create config directory", "error", err)
@@ -127,6 +195,15 @@ func initConfig(cmd *cobra.Command) error {
 				} else {
 					viper.Set(flag.Name, value)
 				}
+			case "float64":
+				value, err := strconv.ParseFloat(flag.Value.String(), 64)
+				if err != nil {
+					viper.Set(flag.Name, flag.Value)
+				} else {
+					viper.Set(flag.Name, value)
+				}
+			case "string":
+				viper.Set(flag.Name, flag.Value.String())
 			case "stringSlice", "stringArray":
 				if sliceValue, ok := flag.Value.(pflag.SliceValue); ok {
 					viper.Set(flag.Name, sliceValue.GetSlice())
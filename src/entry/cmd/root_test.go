@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"ocf/internal/common"
 	"os"
 	"path/filepath"
 	"testing"
@@ -172,12 +173,14 @@ func TestInitConfigFlagBinding(t *testing.T) {
 	cmd.Flags().String("test-string", "default", "test string flag")
 	cmd.Flags().Int("test-int", 42, "test int flag")
 	cmd.Flags().StringSlice("test-slice", []string{"a", "b"}, "test slice flag")
+	cmd.Flags().Float64("test-float", 1.5, "test float flag")
 
 	// Simulate flag changes
 	cmd.Flags().Set("test-bool", "false")
 	cmd.Flags().Set("test-string", "custom")
 	cmd.Flags().Set("test-int", "100")
 	cmd.Flags().Set("test-slice", "x,y,z")
+	cmd.Flags().Set("test-float", "-2.5")
 
 	err := initConfig(cmd)
 	require.NoError(t, err)
@@ -187,6 +190,7 @@ func TestInitConfigFlagBinding(t *testing.T) {
 	assert.Equal(t, "custom", viper.GetString("test-string"))
 	assert.Equal(t, 100, viper.GetInt("test-int"))
 	assert.Equal(t, []string{"x", "y", "z"}, viper.GetStringSlice("test-slice"))
+	assert.Equal(t, -2.5, viper.GetFloat64("test-float"))
 }
 
 func TestExecute(t *testing.T) {
@@ -273,4 +277,25 @@ func TestConfigFileVariable(t *testing.T) {
 
 	// Reset for other tests
 	cfgFile = ""
-}
\ No newline at end of file
+}
+
+func TestInitConfigHome(t *testing.T) {
+	viper.Reset()
+	t.Setenv("HOME", t.TempDir())
+	home := filepath.Join(t.TempDir(), "node1")
+
+	cfgFile = ""
+	homePath = home
+	defer func() {
+		homePath = ""
+		common.SetHomePath("")
+	}()
+
+	err := initConfig(&cobra.Command{})
+	require.NoError(t, err)
+
+	assert.Equal(t, filepath.Join(home, "cfg.yaml"), viper.ConfigFileUsed())
+	assert.FileExists(t, filepath.Join(home, "cfg.yaml"))
+	assert.Equal(t, home, common.GetHomePath())
+	assert.Equal(t, filepath.Join(home, "wallets"), common.GetWalletPath())
+}
@@ -2,8 +2,12 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"ocf/internal/wallet"
+	"os"
 	"time"
+
+	"github.com/mr-tron/base58"
 	"github.com/spf13/cobra"
 )
 
@@ -54,9 +58,10 @@ var walletListCmd = &cobra.Command{
 			return
 		}
 
+		defaultKey := wm.GetPublicKey()
 		for idx, account := range accounts {
 			prefix := " "
-			if idx == 0 {
+			if account.PublicKey == defaultKey {
 				prefix = "*"
 			}
 			fmt.Printf("%s [%d] %s (%s)\n", prefix, idx, account.PublicKey, account.Type)
@@ -88,9 +93,180 @@ var walletInfoCmd = &cobra.Command{
 	},
 }
 
+var walletImportCmd = &cobra.Command{
+	Use:   "import [keypair-file]",
+	Short: "Import a Solana keypair from a JSON keypair file or a base58 secret",
+	Long: "Import a Solana keypair. The file holds either the JSON byte array written by solana-keygen " +
+		"or a base58 secret key. Without a file, or with -, the key is read from stdin so that it " +
+		"never appears in the shell history or the process list.",
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var data []byte
+		var err error
+		if len(args) == 0 || args[0] == "-" {
+			data, err = io.ReadAll(cmd.InOrStdin())
+		} else {
+			data, err = os.ReadFile(args[0])
+		}
+		if err != nil {
+			fmt.Printf("Failed to read keypair: %v\n", err)
+			return
+		}
+
+		wm, err := wallet.NewWalletManager()
+		if err != nil {
+			fmt.Printf("Failed to initialize wallet manager: %v\n", err)
+			return
+		}
+
+		account, err := wm.ImportSolanaAccount(data)
+		if err != nil {
+			fmt.Printf("Failed to import Solana account: %v\n", err)
+			return
+		}
+
+		fmt.Printf("Imported Solana account %s\n", account.PublicKey)
+		fmt.Printf("Keypair stored at %s\n", account.FilePath)
+		if makeDefault, _ := cmd.Flags().GetBool("default"); makeDefault {
+			if err := wm.SetDefaultAccount(account.PublicKey); err != nil {
+				fmt.Printf("Failed to set default account: %v\n", err)
+				return
+			}
+			fmt.Println("This account is set as the default wallet.")
+		}
+	},
+}
+
+var walletExportCmd = &cobra.Command{
+	Use:   "export <pubkey>",
+	Short: "Print the secret key of a managed account",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		wm, err := wallet.NewWalletManager()
+		if err != nil {
+			fmt.Printf("Failed to initialize wallet manager: %v\n", err)
+			return
+		}
+
+		format, _ := cmd.Flags().GetString("format")
+		data, err := wm.ExportAccount(args[0], format)
+		if err != nil {
+			fmt.Printf("Failed to export account: %v\n", err)
+			return
+		}
+
+		output, _ := cmd.Flags().GetString("output")
+		if output == "" {
+			fmt.Println(string(data))
+			return
+		}
+		if err := os.WriteFile(output, data, 0o600); err != nil {
+			fmt.Printf("Failed to write %s: %v\n", output, err)
+			return
+		}
+		fmt.Printf("Exported %s to %s\n", args[0], output)
+	},
+}
+
+var walletUseCmd = &cobra.Command{
+	Use:   "use <pubkey>",
+	Short: "Set the default account",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		wm, err := wallet.NewWalletManager()
+		if err != nil {
+			fmt.Printf("Failed to initialize wallet manager: %v\n", err)
+			return
+		}
+
+		if err := wm.SetDefaultAccount(args[0]); err != nil {
+			fmt.Printf("Failed to set default account: %v\n", err)
+			return
+		}
+		fmt.Printf("Default account set to %s\n", args[0])
+	},
+}
+
+var walletRemoveCmd = &cobra.Command{
+	Use:   "remove <pubkey>",
+	Short: "Remove a managed account and its keypair",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		wm, err := wallet.NewWalletManager()
+		if err != nil {
+			fmt.Printf("Failed to initialize wallet manager: %v\n", err)
+			return
+		}
+
+		if err := wm.RemoveAccount(args[0]); err != nil {
+			fmt.Printf("Failed to remove account: %v\n", err)
+			return
+		}
+		fmt.Printf("Removed account %s\n", args[0])
+		if next := wm.GetPublicKey(); next != "" {
+			fmt.Printf("Default account is now %s\n", next)
+		}
+	},
+}
+
+var walletSignCmd = &cobra.Command{
+	Use:   "sign <message>",
+	Short: "Sign a message and print the base58 signature",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		wm, err := wallet.NewWalletManager()
+		if err != nil {
+			fmt.Printf("Failed to initialize wallet manager: %v\n", err)
+			return
+		}
+
+		account, _ := cmd.Flags().GetString("account")
+		signature, err := wm.Sign(account, []byte(args[0]))
+		if err != nil {
+			fmt.Printf("Failed to sign message: %v\n", err)
+			return
+		}
+		fmt.Println(base58.Encode(signature))
+	},
+}
+
+var walletVerifyCmd = &cobra.Command{
+	Use:   "verify <pubkey> <message> <signature>",
+	Short: "Verify a base58 signature produced by `ocf wallet sign`",
+	Args:  cobra.ExactArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		signature, err := base58.Decode(args[2])
+		if err != nil {
+			fmt.Printf("Invalid signature encoding: %v\n", err)
+			return
+		}
+		ok, err := wallet.Verify(args[0], []byte(args[1]), signature)
+		if err != nil {
+			fmt.Printf("Failed to verify signature: %v\n", err)
+			return
+		}
+		if !ok {
+			fmt.Printf("Signature is not valid for %s\n", args[0])
+			return
+		}
+		fmt.Println("Signature is valid")
+	},
+}
+
 func init() {
+	walletImportCmd.Flags().Bool("default", false, "set the imported account as default")
+	walletExportCmd.Flags().String("format", wallet.ExportFormatJSON, "output format (json, base58)")
+	walletExportCmd.Flags().String("output", "", "write the secret key to a file instead of stdout")
+	walletSignCmd.Flags().String("account", "", "public key of the signing account (default account if empty)")
+
 	walletCmd.AddCommand(walletCreateCmd)
 	walletCmd.AddCommand(walletListCmd)
 	walletCmd.AddCommand(walletInfoCmd)
+	walletCmd.AddCommand(walletImportCmd)
+	walletCmd.AddCommand(walletExportCmd)
+	walletCmd.AddCommand(walletUseCmd)
+	walletCmd.AddCommand(walletRemoveCmd)
+	walletCmd.AddCommand(walletSignCmd)
+	walletCmd.AddCommand(walletVerifyCmd)
 	rootcmd.AddCommand(walletCmd)
 }
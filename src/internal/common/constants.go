@@ -3,25 +3,94 @@ package common
 import (
 	"os"
 	"path"
+	"strings"
+	"sync"
 
 	"github.com/mitchellh/go-homedir"
 )
 
-func GetHomePath() string {
-	home, err := homedir.Dir()
+// HomeEnv names the environment variable that relocates all node state.
+const HomeEnv = "OCF_HOME"
+
+var (
+	homeLock     sync.RWMutex
+	homeOverride string
+)
+
+// SetHomePath makes dir the root for all node state (database, keys,
+// wallets and config), as set by --home. An empty dir falls back to
+// $OCF_HOME and then to the legacy locations.
+func SetHomePath(dir string) {
+	homeLock.Lock()
+	defer homeLock.Unlock()
+	homeOverride = strings.TrimSpace(dir)
+}
+
+// customHome returns the state root chosen by --home or $OCF_HOME, if any.
+func customHome() (string, bool) {
+	homeLock.RLock()
+	dir := homeOverride
+	homeLock.RUnlock()
+	if dir == "" {
+		dir = strings.TrimSpace(os.Getenv(HomeEnv))
+	}
+	if dir == "" {
+		return "", false
+	}
+	if expanded, err := homedir.Expand(dir); err == nil {
+		dir = expanded
+	}
+	return dir, true
+}
+
+func userHome() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home, err = homedir.Dir()
+	}
 	if err != nil {
 		Logger.Error("Could not get home directory", "error", err)
 		home = "."
 	}
-	ocfcorePath := path.Join(home, ".ocfcore")
-	if _, err := os.Stat(ocfcorePath); os.IsNotExist(err) {
-		err := os.MkdirAll(ocfcorePath, 0755)
+	return home
+}
+
+func ensureDir(dir string, perm os.FileMode) string {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		err := os.MkdirAll(dir, perm)
 		if err != nil {
-			Logger.Error("Could not create ocfcore directory", "error", err)
+			Logger.Error("Could not create directory "+dir, "error", err)
 			return "."
 		}
 	}
-	return ocfcorePath
+	return dir
+}
+
+// GetHomePath returns the directory holding the database and identity keys.
+// It is the --home/$OCF_HOME root if set, otherwise ~/.ocfcore.
+func GetHomePath() string {
+	if dir, ok := customHome(); ok {
+		return ensureDir(dir, 0755)
+	}
+	return ensureDir(path.Join(userHome(), ".ocfcore"), 0755)
+}
+
+// GetWalletPath returns the directory holding wallet accounts: "wallets"
+// under a custom home, otherwise ~/.ocf.
+func GetWalletPath() string {
+	if dir, ok := customHome(); ok {
+		return path.Join(dir, "wallets")
+	}
+	return path.Join(userHome(), ".ocf")
+}
+
+// GetConfigPath returns the default config file: cfg.yaml under a custom
+// home, otherwise ~/.config/ocf/cfg.yaml.
+func GetConfigPath() string {
+	if dir, ok := customHome(); ok {
+		return path.Join(dir, "cfg.yaml")
+	}
+	return path.Join(userHome(), ".config", "ocf", "cfg.yaml")
 }
 
 func GetDBPath(nodeid string) string {
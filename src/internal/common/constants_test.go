@@ -38,3 +38,39 @@ func TestGetDBPath(t *testing.T) {
 		t.Fatalf("unexpected db path: %s", db)
 	}
 }
+
+func TestCustomHomePaths(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	root := filepath.Join(t.TempDir(), "node1")
+	t.Setenv(HomeEnv, root)
+
+	if p := GetHomePath(); p != root {
+		t.Fatalf("expected %s from $%s, got %s", root, HomeEnv, p)
+	}
+	if p := GetWalletPath(); p != filepath.Join(root, "wallets") {
+		t.Fatalf("unexpected wallet path: %s", p)
+	}
+	if p := GetConfigPath(); p != filepath.Join(root, "cfg.yaml") {
+		t.Fatalf("unexpected config path: %s", p)
+	}
+
+	flagRoot := filepath.Join(t.TempDir(), "node2")
+	SetHomePath(flagRoot)
+	t.Cleanup(func() { SetHomePath("") })
+	if p := GetDBPath("n"); p != filepath.Join(flagRoot, "ocfcore.n.db") {
+		t.Fatalf("expected --home to take precedence, got %s", p)
+	}
+}
+
+func TestLegacyPaths(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv(HomeEnv, "")
+
+	if p := GetWalletPath(); p != filepath.Join(home, ".ocf") {
+		t.Fatalf("unexpected wallet path: %s", p)
+	}
+	if p := GetConfigPath(); p != filepath.Join(home, ".config", "ocf", "cfg.yaml") {
+		t.Fatalf("unexpected config path: %s", p)
+	}
+}
@@ -0,0 +1,380 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"ocf/internal/common"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/network"
+	libpeer "github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/spf13/viper"
+)
+
+const (
+	AccessAllow = "allow"
+	AccessDeny  = "deny"
+
+	AccessKindPeer  = "peer"
+	AccessKindOwner = "owner"
+	AccessKindCIDR  = "cidr"
+
+	accessListFile = "access_list.json"
+)
+
+// AccessList holds allow and deny entries for peer IDs, owner wallets and
+// network ranges. Deny entries always win. When an allow list of a given kind
+// is non-empty, only matching entries of that kind are accepted.
+type AccessList struct {
+	AllowPeers  []string `json:"allow_peers"`
+	DenyPeers   []string `json:"deny_peers"`
+	AllowOwners []string `json:"allow_owners"`
+	DenyOwners  []string `json:"deny_owners"`
+	AllowCIDRs  []string `json:"allow_cidrs"`
+	DenyCIDRs   []string `json:"deny_cidrs"`
+}
+
+type accessPolicy struct {
+	mu   sync.RWMutex
+	list AccessList
+	path string
+
+	allowPeers, denyPeers   map[string]struct{}
+	allowOwners, denyOwners map[string]struct{}
+	allowNets, denyNets     []*net.IPNet
+}
+
+var accessOnce sync.Once
+var access *accessPolicy
+
+func getAccessPolicy() *accessPolicy {
+	accessOnce.Do(func() {
+		access = &accessPolicy{path: path.Join(common.GetHomePath(), accessListFile)}
+		list := AccessList{
+			AllowPeers:  viper.GetStringSlice("access.allow_peers"),
+			DenyPeers:   viper.GetStringSlice("access.deny_peers"),
+			AllowOwners: viper.GetStringSlice("access.allow_owners"),
+			DenyOwners:  viper.GetStringSlice("access.deny_owners"),
+			AllowCIDRs:  viper.GetStringSlice("access.allow_cidrs"),
+			DenyCIDRs:   viper.GetStringSlice("access.deny_cidrs"),
+		}
+		// Entries added through the API are persisted separately and merged
+		// on top of the configuration file.
+		if data, err := os.ReadFile(access.path); err == nil {
+			var stored AccessList
+			if err := json.Unmarshal(data, &stored); err != nil {
+				common.Logger.Warnf("Ignoring malformed access list %s: %v", access.path, err)
+			} else {
+				list = mergeAccessLists(list, stored)
+			}
+		}
+		if err := access.set(list); err != nil {
+			common.Logger.Errorf("Invalid access list configuration: %v", err)
+		}
+	})
+	return access
+}
+
+func mergeAccessLists(a, b AccessList) AccessList {
+	return AccessList{
+		AllowPeers:  common.DeduplicateStrings(append(a.AllowPeers, b.AllowPeers...)),
+		DenyPeers:   common.DeduplicateStrings(append(a.DenyPeers, b.DenyPeers...)),
+		AllowOwners: common.DeduplicateStrings(append(a.AllowOwners, b.AllowOwners...)),
+		DenyOwners:  common.DeduplicateStrings(append(a.DenyOwners, b.DenyOwners...)),
+		AllowCIDRs:  common.DeduplicateStrings(append(a.AllowCIDRs, b.AllowCIDRs...)),
+		DenyCIDRs:   common.DeduplicateStrings(append(a.DenyCIDRs, b.DenyCIDRs...)),
+	}
+}
+
+func toSet(values []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(values))
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			set[v] = struct{}{}
+		}
+	}
+	return set
+}
+
+func parseCIDRs(values []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			if ip := net.ParseIP(v); ip != nil && ip.To4() != nil {
+				v += "/32"
+			} else {
+				v += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", v, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// set replaces the whole policy. It validates CIDRs before changing anything.
+func (ap *accessPolicy) set(list AccessList) error {
+	allowNets, err := parseCIDRs(list.AllowCIDRs)
+	if err != nil {
+		return err
+	}
+	denyNets, err := parseCIDRs(list.DenyCIDRs)
+	if err != nil {
+		return err
+	}
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+	ap.apply(list, allowNets, denyNets)
+	return nil
+}
+
+// apply installs an already validated list. The caller holds ap.mu.
+func (ap *accessPolicy) apply(list AccessList, allowNets, denyNets []*net.IPNet) {
+	ap.list = list
+	ap.allowPeers, ap.denyPeers = toSet(list.AllowPeers), toSet(list.DenyPeers)
+	ap.allowOwners, ap.denyOwners = toSet(list.AllowOwners), toSet(list.DenyOwners)
+	ap.allowNets, ap.denyNets = allowNets, denyNets
+}
+
+// update adds or removes a single entry. The read, the edit and the write to
+// disk happen under one lock so concurrent updates cannot lose each other's
+// changes or persist an older list last.
+func (ap *accessPolicy) update(action string, kind string, value string, remove bool) (AccessList, error) {
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+	list := ap.list
+
+	var target *[]string
+	switch action + "/" + kind {
+	case AccessAllow + "/" + AccessKindPeer:
+		target = &list.AllowPeers
+	case AccessDeny + "/" + AccessKindPeer:
+		target = &list.DenyPeers
+	case AccessAllow + "/" + AccessKindOwner:
+		target = &list.AllowOwners
+	case AccessDeny + "/" + AccessKindOwner:
+		target = &list.DenyOwners
+	case AccessAllow + "/" + AccessKindCIDR:
+		target = &list.AllowCIDRs
+	case AccessDeny + "/" + AccessKindCIDR:
+		target = &list.DenyCIDRs
+	default:
+		return AccessList{}, fmt.Errorf("unknown access list %s/%s", action, kind)
+	}
+
+	// Build a new slice: the old one is still referenced by ap.list and by
+	// lists returned to callers.
+	updated := make([]string, 0, len(*target)+1)
+	for _, v := range *target {
+		if v != value {
+			updated = append(updated, v)
+		}
+	}
+	if !remove {
+		updated = append(updated, value)
+	}
+	*target = updated
+
+	allowNets, err := parseCIDRs(list.AllowCIDRs)
+	if err != nil {
+		return AccessList{}, err
+	}
+	denyNets, err := parseCIDRs(list.DenyCIDRs)
+	if err != nil {
+		return AccessList{}, err
+	}
+	ap.apply(list, allowNets, denyNets)
+	if err := ap.save(); err != nil {
+		common.Logger.Warn("Could not persist access list: ", err)
+	}
+	return list, nil
+}
+
+// save writes the list to disk. The caller holds ap.mu.
+func (ap *accessPolicy) save() error {
+	data, err := json.MarshalIndent(ap.list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(ap.path, data, 0o600)
+}
+
+func matchSets(value string, allow, deny map[string]struct{}) bool {
+	if _, ok := deny[value]; ok {
+		return false
+	}
+	if len(allow) == 0 {
+		return true
+	}
+	_, ok := allow[value]
+	return ok
+}
+
+func (ap *accessPolicy) peerAllowed(peerID string) bool {
+	ap.mu.RLock()
+	defer ap.mu.RUnlock()
+	return matchSets(peerID, ap.allowPeers, ap.denyPeers)
+}
+
+// ownerAllowed checks an owner wallet. Deny entries match the claimed owner,
+// but allow entries only match owners that have been verified, since anyone
+// can claim an allowed wallet.
+func (ap *accessPolicy) ownerAllowed(owner string, verified bool) bool {
+	ap.mu.RLock()
+	defer ap.mu.RUnlock()
+	if _, ok := ap.denyOwners[owner]; ok && owner != "" {
+		return false
+	}
+	if len(ap.allowOwners) == 0 {
+		return true
+	}
+	if !verified {
+		return false
+	}
+	_, ok := ap.allowOwners[owner]
+	return ok
+}
+
+// hasOwnerRules reports whether any owner is allowed or denied explicitly.
+func (ap *accessPolicy) hasOwnerRules() bool {
+	ap.mu.RLock()
+	defer ap.mu.RUnlock()
+	return len(ap.allowOwners) > 0 || len(ap.denyOwners) > 0
+}
+
+func (ap *accessPolicy) ipAllowed(ip net.IP) bool {
+	ap.mu.RLock()
+	defer ap.mu.RUnlock()
+	for _, n := range ap.denyNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(ap.allowNets) == 0 {
+		return true
+	}
+	for _, n := range ap.allowNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ap *accessPolicy) addrAllowed(addr multiaddr.Multiaddr) bool {
+	if addr == nil {
+		return true
+	}
+	ip, err := manet.ToIP(addr)
+	if err != nil {
+		// Addresses without an IP component (relay circuits, DNS) are
+		// judged by the peer they lead to instead.
+		return true
+	}
+	return ap.ipAllowed(ip)
+}
+
+// PeerAllowed reports whether peerID passes the access policy.
+func PeerAllowed(peerID string) bool {
+	return getAccessPolicy().peerAllowed(peerID)
+}
+
+// PeerRecordAllowed reports whether a node table entry passes the access
+// policy, by peer ID and by owner.
+func PeerRecordAllowed(p Peer) bool {
+	ap := getAccessPolicy()
+	return ap.peerAllowed(p.ID) && ap.ownerAllowed(p.Owner, p.OwnerVerified)
+}
+
+// GetAccessList returns the current access policy.
+func GetAccessList() AccessList {
+	ap := getAccessPolicy()
+	ap.mu.RLock()
+	defer ap.mu.RUnlock()
+	return ap.list
+}
+
+// UpdateAccessList adds (or removes, when remove is true) a value from one of
+// the lists, persists the result and disconnects peers that are no longer
+// allowed.
+func UpdateAccessList(action string, kind string, value string, remove bool) (AccessList, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return AccessList{}, errors.New("value is empty")
+	}
+	if kind == AccessKindPeer {
+		if _, err := libpeer.Decode(value); err != nil {
+			return AccessList{}, fmt.Errorf("invalid peer ID: %w", err)
+		}
+	}
+	list, err := getAccessPolicy().update(action, kind, value, remove)
+	if err != nil {
+		return AccessList{}, err
+	}
+	go enforceAccessPolicy()
+	return list, nil
+}
+
+// enforceAccessPolicy drops connections and node table entries that the
+// current policy no longer allows.
+func enforceAccessPolicy() {
+	host, _ := GetP2PNode(nil)
+	ap := getAccessPolicy()
+	for _, conn := range host.Network().Conns() {
+		if !ap.peerAllowed(conn.RemotePeer().String()) || !ap.addrAllowed(conn.RemoteMultiaddr()) {
+			common.Logger.Infof("Closing connection to %s denied by access policy", conn.RemotePeer())
+			_ = conn.Close()
+		}
+	}
+	for id, p := range *GetAllPeers() {
+		if p.ID == "" {
+			p.ID = strings.TrimPrefix(id, "/")
+		}
+		if !PeerRecordAllowed(p) {
+			common.Logger.Infof("Removing peer %s denied by access policy", p.ID)
+			DeleteNodeTableHook(ds.NewKey(id))
+		}
+	}
+}
+
+// accessGater enforces the access policy on libp2p connections.
+type accessGater struct{}
+
+var _ connmgr.ConnectionGater = (*accessGater)(nil)
+
+func (accessGater) InterceptPeerDial(p libpeer.ID) bool {
+	return PeerAllowed(p.String())
+}
+
+func (accessGater) InterceptAddrDial(p libpeer.ID, addr multiaddr.Multiaddr) bool {
+	return PeerAllowed(p.String()) && getAccessPolicy().addrAllowed(addr)
+}
+
+func (accessGater) InterceptAccept(addrs network.ConnMultiaddrs) bool {
+	return getAccessPolicy().addrAllowed(addrs.RemoteMultiaddr())
+}
+
+func (accessGater) InterceptSecured(_ network.Direction, p libpeer.ID, _ network.ConnMultiaddrs) bool {
+	return PeerAllowed(p.String())
+}
+
+func (accessGater) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
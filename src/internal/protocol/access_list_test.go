@@ -0,0 +1,105 @@
+package protocol
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/multiformats/go-multiaddr"
+)
+
+func TestAccessPolicyPeers(t *testing.T) {
+	ap := &accessPolicy{}
+	if err := ap.set(AccessList{DenyPeers: []string{"bad"}}); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if ap.peerAllowed("bad") {
+		t.Fatalf("expected denied peer to be rejected")
+	}
+	if !ap.peerAllowed("other") {
+		t.Fatalf("expected peer to be allowed without an allow list")
+	}
+
+	if err := ap.set(AccessList{AllowPeers: []string{"good", "bad"}, DenyPeers: []string{"bad"}}); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if !ap.peerAllowed("good") {
+		t.Fatalf("expected allowed peer to pass")
+	}
+	if ap.peerAllowed("bad") {
+		t.Fatalf("expected deny to win over allow")
+	}
+	if ap.peerAllowed("other") {
+		t.Fatalf("expected unlisted peer to be rejected when an allow list is set")
+	}
+}
+
+func TestAccessPolicyOwners(t *testing.T) {
+	ap := &accessPolicy{}
+	if err := ap.set(AccessList{AllowOwners: []string{"alice"}, DenyOwners: []string{"mallory"}}); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if ap.ownerAllowed("alice", false) {
+		t.Fatalf("expected unverified owner claim to be rejected by the allow list")
+	}
+	if !ap.ownerAllowed("alice", true) {
+		t.Fatalf("expected verified allowed owner to pass")
+	}
+	if ap.ownerAllowed("mallory", false) {
+		t.Fatalf("expected denied owner to be rejected")
+	}
+}
+
+func TestAccessPolicyCIDRs(t *testing.T) {
+	ap := &accessPolicy{}
+	if err := ap.set(AccessList{DenyCIDRs: []string{"not-a-cidr"}}); err == nil {
+		t.Fatalf("expected invalid CIDR to be rejected")
+	}
+	if err := ap.set(AccessList{AllowCIDRs: []string{"10.0.0.0/8"}, DenyCIDRs: []string{"10.1.2.3"}}); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if !ap.ipAllowed(net.ParseIP("10.9.9.9")) {
+		t.Fatalf("expected address in allowed range to pass")
+	}
+	if ap.ipAllowed(net.ParseIP("10.1.2.3")) {
+		t.Fatalf("expected denied address to be rejected")
+	}
+	if ap.ipAllowed(net.ParseIP("192.168.1.1")) {
+		t.Fatalf("expected address outside allowed range to be rejected")
+	}
+
+	addr, _ := multiaddr.NewMultiaddr("/ip4/192.168.1.1/tcp/43905")
+	if ap.addrAllowed(addr) {
+		t.Fatalf("expected multiaddr outside allowed range to be rejected")
+	}
+	dns, _ := multiaddr.NewMultiaddr("/dns4/example.com/tcp/43905")
+	if !ap.addrAllowed(dns) {
+		t.Fatalf("expected non-IP multiaddr to be judged by peer instead")
+	}
+}
+
+func TestAccessPolicyConcurrentUpdates(t *testing.T) {
+	ap := &accessPolicy{path: filepath.Join(t.TempDir(), accessListFile)}
+	const n = 50
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := ap.update(AccessDeny, AccessKindOwner, fmt.Sprintf("owner-%d", i), false); err != nil {
+				t.Errorf("update failed: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if got := len(ap.list.DenyOwners); got != n {
+		t.Fatalf("expected %d denied owners, got %d", n, got)
+	}
+	for i := 0; i < n; i++ {
+		if ap.ownerAllowed(fmt.Sprintf("owner-%d", i), true) {
+			t.Fatalf("expected owner-%d to be denied", i)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package protocol
+
+import (
+	"fmt"
+	"net"
+	"ocf/internal/common"
+	"strings"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	libp2pquic "github.com/libp2p/go-libp2p/p2p/transport/quic"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+	"github.com/libp2p/go-libp2p/p2p/transport/websocket"
+	libp2pwebtransport "github.com/libp2p/go-libp2p/p2p/transport/webtransport"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/spf13/viper"
+)
+
+const (
+	TransportTCP          = "tcp"
+	TransportWebSocket    = "ws"
+	TransportQUIC         = "quic"
+	TransportWebTransport = "webtransport"
+)
+
+// transportNames lists the transports a node can run, in the order they are
+// registered with libp2p.
+var transportNames = []string{TransportTCP, TransportWebSocket, TransportQUIC, TransportWebTransport}
+
+// enabledTransports returns the transports that are not listed in
+// p2p.disable_transports.
+func enabledTransports() (map[string]bool, error) {
+	enabled := make(map[string]bool, len(transportNames))
+	for _, name := range transportNames {
+		enabled[name] = true
+	}
+	for _, name := range viper.GetStringSlice("p2p.disable_transports") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if _, ok := enabled[name]; !ok {
+			return nil, fmt.Errorf("unknown transport %q in p2p.disable_transports", name)
+		}
+		enabled[name] = false
+	}
+	// WebTransport runs on top of QUIC.
+	if !enabled[TransportQUIC] {
+		enabled[TransportWebTransport] = false
+	}
+	for _, name := range transportNames {
+		if enabled[name] {
+			return enabled, nil
+		}
+	}
+	return nil, fmt.Errorf("all transports are disabled")
+}
+
+func transportOptions(enabled map[string]bool) []libp2p.Option {
+	var opts []libp2p.Option
+	if enabled[TransportTCP] {
+		opts = append(opts, libp2p.Transport(tcp.NewTCPTransport))
+	}
+	if enabled[TransportWebSocket] {
+		opts = append(opts, libp2p.Transport(websocket.New))
+	}
+	if enabled[TransportQUIC] {
+		opts = append(opts, libp2p.Transport(libp2pquic.NewTransport))
+	}
+	if enabled[TransportWebTransport] {
+		opts = append(opts, libp2p.Transport(libp2pwebtransport.New))
+	}
+	return opts
+}
+
+// transportOf returns which of our transports a listen address belongs to.
+func transportOf(addr multiaddr.Multiaddr) string {
+	has := func(code int) bool {
+		_, err := addr.ValueForProtocol(code)
+		return err == nil
+	}
+	switch {
+	case has(multiaddr.P_WEBTRANSPORT):
+		return TransportWebTransport
+	case has(multiaddr.P_QUIC_V1), has(multiaddr.P_QUIC):
+		return TransportQUIC
+	case has(multiaddr.P_WS), has(multiaddr.P_WSS):
+		return TransportWebSocket
+	case has(multiaddr.P_TCP):
+		return TransportTCP
+	}
+	return ""
+}
+
+// listenAddrs returns the multiaddrs to listen on. p2p.listen_addrs replaces
+// the defaults entirely; otherwise every enabled transport listens on all
+// IPv4 (and, unless p2p.ipv6 is false, IPv6) interfaces using tcpport and
+// udpport.
+func listenAddrs(enabled map[string]bool) ([]string, error) {
+	if configured := viper.GetStringSlice("p2p.listen_addrs"); len(configured) > 0 {
+		var addrs []string
+		for _, s := range configured {
+			addr, err := multiaddr.NewMultiaddr(strings.TrimSpace(s))
+			if err != nil {
+				return nil, fmt.Errorf("invalid listen address %q: %w", s, err)
+			}
+			if t := transportOf(addr); t != "" && !enabled[t] {
+				common.Logger.Warnf("Not listening on %s: transport %s is disabled", addr, t)
+				continue
+			}
+			addrs = append(addrs, addr.String())
+		}
+		return addrs, nil
+	}
+
+	tcpPort := viper.GetString("tcpport")
+	udpPort := viper.GetString("udpport")
+	hosts := []string{"/ip4/0.0.0.0"}
+	if !viper.IsSet("p2p.ipv6") || viper.GetBool("p2p.ipv6") {
+		hosts = append(hosts, "/ip6/::")
+	}
+	var addrs []string
+	for _, h := range hosts {
+		if enabled[TransportTCP] {
+			addrs = append(addrs, h+"/tcp/"+tcpPort)
+		}
+		if enabled[TransportWebSocket] {
+			addrs = append(addrs, h+"/tcp/"+tcpPort+"/ws")
+		}
+		if enabled[TransportQUIC] {
+			addrs = append(addrs, h+"/udp/"+udpPort+"/quic-v1")
+		}
+		if enabled[TransportWebTransport] {
+			addrs = append(addrs, h+"/udp/"+udpPort+"/quic-v1/webtransport")
+		}
+	}
+	return addrs, nil
+}
+
+// addrFilter describes which addresses must not be announced. Entries of
+// p2p.no_announce_addrs are either multiaddrs, which are matched exactly, or
+// CIDRs, which match every address whose IP falls in the range.
+type addrFilter struct {
+	addrs map[string]struct{}
+	nets  []*net.IPNet
+}
+
+func newAddrFilter(entries []string) (*addrFilter, error) {
+	f := &addrFilter{addrs: map[string]struct{}{}}
+	for _, e := range entries {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		if strings.HasPrefix(e, "/") {
+			addr, err := multiaddr.NewMultiaddr(e)
+			if err != nil {
+				return nil, fmt.Errorf("invalid no-announce address %q: %w", e, err)
+			}
+			f.addrs[addr.String()] = struct{}{}
+			continue
+		}
+		_, n, err := net.ParseCIDR(e)
+		if err != nil {
+			return nil, fmt.Errorf("invalid no-announce CIDR %q: %w", e, err)
+		}
+		f.nets = append(f.nets, n)
+	}
+	return f, nil
+}
+
+func (f *addrFilter) blocked(addr multiaddr.Multiaddr) bool {
+	if _, ok := f.addrs[addr.String()]; ok {
+		return true
+	}
+	if len(f.nets) == 0 {
+		return false
+	}
+	ip, err := manet.ToIP(addr)
+	if err != nil {
+		return false
+	}
+	for _, n := range f.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// publicAddrAnnouncements turns the legacy public-addr setting into
+// multiaddrs for each enabled transport.
+func publicAddrAnnouncements(enabled map[string]bool) []string {
+	public := strings.TrimSpace(viper.GetString("public-addr"))
+	if public == "" {
+		return nil
+	}
+	prefix := "/ip4/" + public
+	if ip := net.ParseIP(public); ip != nil && ip.To4() == nil {
+		prefix = "/ip6/" + public
+	} else if ip == nil {
+		prefix = "/dns/" + public
+	}
+	tcpPort := viper.GetString("tcpport")
+	udpPort := viper.GetString("udpport")
+	var addrs []string
+	if enabled[TransportTCP] {
+		addrs = append(addrs, prefix+"/tcp/"+tcpPort)
+	}
+	if enabled[TransportWebSocket] {
+		addrs = append(addrs, prefix+"/tcp/"+tcpPort+"/ws")
+	}
+	if enabled[TransportQUIC] {
+		addrs = append(addrs, prefix+"/udp/"+udpPort+"/quic-v1")
+	}
+	return addrs
+}
+
+func parseMultiaddrs(values []string) ([]multiaddr.Multiaddr, error) {
+	var addrs []multiaddr.Multiaddr
+	for _, s := range values {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		addr, err := multiaddr.NewMultiaddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q: %w", s, err)
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
+
+// newAddrsFactory decides what the host announces. p2p.announce_addrs, when
+// set, replaces the addresses libp2p discovered; otherwise the discovered
+// addresses are announced together with those derived from public-addr. In
+// both cases p2p.no_announce_addrs is applied last.
+func newAddrsFactory(enabled map[string]bool) (func([]multiaddr.Multiaddr) []multiaddr.Multiaddr, error) {
+	announce, err := parseMultiaddrs(viper.GetStringSlice("p2p.announce_addrs"))
+	if err != nil {
+		return nil, err
+	}
+	extra, err := parseMultiaddrs(publicAddrAnnouncements(enabled))
+	if err != nil {
+		return nil, err
+	}
+	filter, err := newAddrFilter(viper.GetStringSlice("p2p.no_announce_addrs"))
+	if err != nil {
+		return nil, err
+	}
+	return func(discovered []multiaddr.Multiaddr) []multiaddr.Multiaddr {
+		candidates := announce
+		if len(candidates) == 0 {
+			candidates = append(append([]multiaddr.Multiaddr{}, discovered...), extra...)
+		}
+		seen := make(map[string]struct{}, len(candidates))
+		out := make([]multiaddr.Multiaddr, 0, len(candidates))
+		for _, addr := range candidates {
+			key := addr.String()
+			if _, ok := seen[key]; ok || filter.blocked(addr) {
+				continue
+			}
+			seen[key] = struct{}{}
+			out = append(out, addr)
+		}
+		return out
+	}, nil
+}
+
+// reachabilityOption maps p2p.reachability to a libp2p option. "public" (the
+// default) and "private" skip AutoNAT detection; "auto" lets AutoNAT decide.
+func reachabilityOption() (libp2p.Option, error) {
+	switch strings.ToLower(viper.GetString("p2p.reachability")) {
+	case "", "public":
+		return libp2p.ForceReachabilityPublic(), nil
+	case "private":
+		return libp2p.ForceReachabilityPrivate(), nil
+	case "auto":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown p2p.reachability %q (expected public, private or auto)", viper.GetString("p2p.reachability"))
+	}
+}
+
+// AnnouncedAddrs returns the addresses this node announces to the network.
+func AnnouncedAddrs() []string {
+	host, _ := GetP2PNode(nil)
+	return hostAddrs(host)
+}
+
+func hostAddrs(h host.Host) []string {
+	addrs := make([]string, 0, len(h.Addrs()))
+	for _, addr := range h.Addrs() {
+		addrs = append(addrs, addr.String())
+	}
+	return addrs
+}
+
+// bootstrapAddrs turns the announced addresses of a peer into dialable
+// bootstrap multiaddrs. Loopback addresses are never useful to others, and
+// outside local mode neither are private ones.
+func bootstrapAddrs(peerID string, addrs []string, mode string) []string {
+	var out []string
+	for _, s := range addrs {
+		addr, err := multiaddr.NewMultiaddr(s)
+		if err != nil {
+			continue
+		}
+		if manet.IsIPLoopback(addr) || manet.IsIPUnspecified(addr) {
+			continue
+		}
+		if mode != "local" && manet.IsPrivateAddr(addr) {
+			continue
+		}
+		out = append(out, addr.String()+"/p2p/"+peerID)
+	}
+	return out
+}
+
+// setSelfAddresses fills in the addresses and reachability of our own node
+// table entry.
+func setSelfAddresses(h host.Host, p *Peer) {
+	p.Reachability = Reachability()
+	if public := viper.GetString("public-addr"); public != "" {
+		p.PublicAddress = public
+	}
+	p.Addrs = hostAddrs(h)
+}
@@ -0,0 +1,124 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/multiformats/go-multiaddr"
+	"github.com/spf13/viper"
+)
+
+func TestEnabledTransports(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	enabled, err := enabledTransports()
+	if err != nil {
+		t.Fatalf("enabledTransports failed: %v", err)
+	}
+	for _, name := range transportNames {
+		if !enabled[name] {
+			t.Fatalf("expected %s to be enabled by default", name)
+		}
+	}
+
+	viper.Set("p2p.disable_transports", []string{"quic"})
+	enabled, err = enabledTransports()
+	if err != nil {
+		t.Fatalf("enabledTransports failed: %v", err)
+	}
+	if enabled[TransportQUIC] || enabled[TransportWebTransport] {
+		t.Fatalf("expected disabling quic to disable webtransport too: %v", enabled)
+	}
+
+	viper.Set("p2p.disable_transports", []string{"carrier-pigeon"})
+	if _, err := enabledTransports(); err == nil {
+		t.Fatalf("expected unknown transport to be rejected")
+	}
+
+	viper.Set("p2p.disable_transports", transportNames)
+	if _, err := enabledTransports(); err == nil {
+		t.Fatalf("expected disabling every transport to be rejected")
+	}
+}
+
+func TestListenAddrs(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	viper.Set("tcpport", "4001")
+	viper.Set("udpport", "4002")
+	viper.Set("p2p.ipv6", false)
+
+	addrs, err := listenAddrs(map[string]bool{TransportTCP: true, TransportQUIC: true})
+	if err != nil {
+		t.Fatalf("listenAddrs failed: %v", err)
+	}
+	want := []string{"/ip4/0.0.0.0/tcp/4001", "/ip4/0.0.0.0/udp/4002/quic-v1"}
+	if len(addrs) != len(want) || addrs[0] != want[0] || addrs[1] != want[1] {
+		t.Fatalf("expected %v, got %v", want, addrs)
+	}
+
+	viper.Set("p2p.ipv6", true)
+	addrs, _ = listenAddrs(map[string]bool{TransportTCP: true})
+	if len(addrs) != 2 || addrs[1] != "/ip6/::/tcp/4001" {
+		t.Fatalf("expected an IPv6 listener, got %v", addrs)
+	}
+
+	viper.Set("p2p.listen_addrs", []string{"/ip4/127.0.0.1/tcp/5000", "/ip4/127.0.0.1/tcp/5000/ws"})
+	addrs, err = listenAddrs(map[string]bool{TransportTCP: true})
+	if err != nil {
+		t.Fatalf("listenAddrs failed: %v", err)
+	}
+	if len(addrs) != 1 || addrs[0] != "/ip4/127.0.0.1/tcp/5000" {
+		t.Fatalf("expected only the enabled configured listener, got %v", addrs)
+	}
+}
+
+func TestAddrsFactory(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	viper.Set("tcpport", "4001")
+	viper.Set("public-addr", "203.0.113.7")
+	viper.Set("p2p.no_announce_addrs", []string{"10.0.0.0/8"})
+
+	factory, err := newAddrsFactory(map[string]bool{TransportTCP: true})
+	if err != nil {
+		t.Fatalf("newAddrsFactory failed: %v", err)
+	}
+	discovered := []multiaddr.Multiaddr{
+		multiaddr.StringCast("/ip4/10.1.2.3/tcp/4001"),
+		multiaddr.StringCast("/ip4/198.51.100.1/tcp/4001"),
+	}
+	got := factory(discovered)
+	want := []string{"/ip4/198.51.100.1/tcp/4001", "/ip4/203.0.113.7/tcp/4001"}
+	if len(got) != len(want) || got[0].String() != want[0] || got[1].String() != want[1] {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+
+	viper.Set("p2p.announce_addrs", []string{"/dns4/node.example.com/tcp/443/wss"})
+	factory, err = newAddrsFactory(map[string]bool{TransportTCP: true})
+	if err != nil {
+		t.Fatalf("newAddrsFactory failed: %v", err)
+	}
+	got = factory(discovered)
+	if len(got) != 1 || got[0].String() != "/dns4/node.example.com/tcp/443/wss" {
+		t.Fatalf("expected announce_addrs to replace discovered addresses, got %v", got)
+	}
+}
+
+func TestBootstrapAddrs(t *testing.T) {
+	id := "12D3KooWJWoaqZhDaoEFshF7Rh1bpY9ohihFhzcW6d69Lr2NASuq"
+	addrs := []string{
+		"/ip4/127.0.0.1/tcp/4001",
+		"/ip4/192.168.1.5/tcp/4001",
+		"/ip4/203.0.113.7/tcp/4001",
+		"/ip6/2001:db8::1/udp/4002/quic-v1",
+	}
+	got := bootstrapAddrs(id, addrs, "node")
+	if len(got) != 2 || got[0] != "/ip4/203.0.113.7/tcp/4001/p2p/"+id {
+		t.Fatalf("expected only public addresses, got %v", got)
+	}
+	got = bootstrapAddrs(id, addrs, "local")
+	if len(got) != 3 {
+		t.Fatalf("expected private addresses in local mode, got %v", got)
+	}
+}
@@ -0,0 +1,172 @@
+package protocol
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"ocf/internal/common"
+	"ocf/internal/wallet"
+	"strconv"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	libpeer "github.com/libp2p/go-libp2p/core/peer"
+	"github.com/mr-tron/base58"
+)
+
+const (
+	attestationDomain = "ocf-owner-attestation/v1"
+	// defaultAttestationMaxAge bounds how long an attestation is accepted,
+	// so that a leaked one stops working. Owners sign a new one halfway.
+	defaultAttestationMaxAge = 7 * 24 * time.Hour
+)
+
+// OwnerAttestation binds a libp2p peer ID to a wallet. The wallet key signs
+// the peer ID and the peer key signs the wallet address, so neither side can
+// be claimed without holding both private keys.
+type OwnerAttestation struct {
+	PeerID          string `json:"peer_id"`
+	Owner           string `json:"owner"`
+	IssuedAt        int64  `json:"issued_at"`
+	WalletSignature string `json:"wallet_signature"` // base58
+	PeerSignature   string `json:"peer_signature"`   // base64
+}
+
+func attestationPayload(peerID string, owner string, issuedAt int64) []byte {
+	return []byte(attestationDomain + "|" + peerID + "|" + owner + "|" + strconv.FormatInt(issuedAt, 10))
+}
+
+// NewOwnerAttestation signs the binding between the host identity and the
+// given owner using the wallet account that holds the owner key.
+func NewOwnerAttestation(h host.Host, wm *wallet.WalletManager, owner string) (*OwnerAttestation, error) {
+	if owner == "" {
+		return nil, errors.New("owner is empty")
+	}
+	priv := h.Peerstore().PrivKey(h.ID())
+	if priv == nil {
+		return nil, errors.New("host private key not available")
+	}
+	return signOwnerAttestation(h.ID(), priv, wm, owner, time.Now().Unix())
+}
+
+func signOwnerAttestation(pid libpeer.ID, priv crypto.PrivKey, wm *wallet.WalletManager, owner string, issuedAt int64) (*OwnerAttestation, error) {
+	payload := attestationPayload(pid.String(), owner, issuedAt)
+	walletSig, err := wm.Sign(owner, payload)
+	if err != nil {
+		return nil, fmt.Errorf("wallet signature failed: %w", err)
+	}
+	peerSig, err := priv.Sign(payload)
+	if err != nil {
+		return nil, fmt.Errorf("peer signature failed: %w", err)
+	}
+	return &OwnerAttestation{
+		PeerID:          pid.String(),
+		Owner:           owner,
+		IssuedAt:        issuedAt,
+		WalletSignature: base58.Encode(walletSig),
+		PeerSignature:   base64.StdEncoding.EncodeToString(peerSig),
+	}, nil
+}
+
+// VerifyOwnerAttestation checks that att binds peerID to owner and that both
+// signatures are valid. pubKey is the peer's public key; when nil it is
+// extracted from the peer ID, which works for ed25519 identities.
+func VerifyOwnerAttestation(att *OwnerAttestation, peerID string, owner string, pubKey crypto.PubKey) error {
+	if att == nil {
+		return errors.New("no attestation")
+	}
+	if att.PeerID != peerID {
+		return fmt.Errorf("attestation is for peer %s, not %s", att.PeerID, peerID)
+	}
+	if att.Owner != owner {
+		return fmt.Errorf("attestation is for owner %s, not %s", att.Owner, owner)
+	}
+	payload := attestationPayload(att.PeerID, att.Owner, att.IssuedAt)
+
+	walletSig, err := base58.Decode(att.WalletSignature)
+	if err != nil {
+		return fmt.Errorf("invalid wallet signature encoding: %w", err)
+	}
+	ok, err := wallet.Verify(owner, payload, walletSig)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("wallet signature is invalid")
+	}
+
+	if pubKey == nil {
+		pid, err := libpeer.Decode(peerID)
+		if err != nil {
+			return fmt.Errorf("invalid peer ID: %w", err)
+		}
+		pubKey, err = pid.ExtractPublicKey()
+		if err != nil {
+			return fmt.Errorf("peer public key unknown: %w", err)
+		}
+	}
+	peerSig, err := base64.StdEncoding.DecodeString(att.PeerSignature)
+	if err != nil {
+		return fmt.Errorf("invalid peer signature encoding: %w", err)
+	}
+	ok, err = pubKey.Verify(payload, peerSig)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("peer signature is invalid")
+	}
+	return nil
+}
+
+func attestationMaxAge() time.Duration {
+	return readDurationSetting("attestation.max_age", defaultAttestationMaxAge)
+}
+
+// checkAttestationAge rejects attestations issued more than
+// attestation.max_age before now, or in the future.
+func checkAttestationAge(att *OwnerAttestation, now time.Time) error {
+	issued := time.Unix(att.IssuedAt, 0)
+	if issued.After(now.Add(leaseClockSkew)) {
+		return fmt.Errorf("attestation issued in the future (%s)", issued.UTC().Format(time.RFC3339))
+	}
+	if maxAge := attestationMaxAge(); now.Sub(issued) > maxAge {
+		return fmt.Errorf("attestation issued at %s is older than %s", issued.UTC().Format(time.RFC3339), maxAge)
+	}
+	return nil
+}
+
+// verifyPeerOwner sets OwnerVerified on a peer record received from the
+// network under the key of peerID. Values claimed by the remote are never
+// trusted as-is. Anyone can write a record under that key, so a rejected
+// attestation is not held against the peer.
+func verifyPeerOwner(h host.Host, peerID string, p *Peer) {
+	p.OwnerVerified = false
+	if p.Owner == "" || p.OwnerAttestation == nil {
+		return
+	}
+	var pubKey crypto.PubKey
+	if pid, err := libpeer.Decode(peerID); err == nil {
+		pubKey = h.Peerstore().PubKey(pid)
+	}
+	if err := checkAttestationAge(p.OwnerAttestation, time.Now()); err != nil {
+		common.Logger.Debugf("Owner attestation for peer %s rejected: %v", peerID, err)
+		return
+	}
+	if err := VerifyOwnerAttestation(p.OwnerAttestation, peerID, p.Owner, pubKey); err != nil {
+		common.Logger.Warnf("Owner attestation for peer %s rejected: %v", peerID, err)
+		return
+	}
+	RecordAttestation(peerID, p.OwnerAttestation.IssuedAt)
+	p.OwnerVerified = true
+}
+
+// VerifiedOwner returns the owner of the peer only if it has been proven by
+// an attestation. Accounting and token-gating must use this instead of Owner.
+func (p Peer) VerifiedOwner() string {
+	if p.OwnerVerified {
+		return p.Owner
+	}
+	return ""
+}
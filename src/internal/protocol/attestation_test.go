@@ -0,0 +1,237 @@
+package protocol
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"ocf/internal/wallet"
+
+	libpeer "github.com/libp2p/go-libp2p/core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/spf13/viper"
+)
+
+func newAttestationWallet(t *testing.T) (*wallet.WalletManager, string) {
+	t.Helper()
+	old := os.Getenv("HOME")
+	t.Cleanup(func() { _ = os.Setenv("HOME", old) })
+	_ = os.Setenv("HOME", t.TempDir())
+
+	wm, err := wallet.NewWalletManager()
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	account, err := wm.AddSolanaAccount()
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	return wm, account.PublicKey
+}
+
+func TestOwnerAttestationRoundTrip(t *testing.T) {
+	wm, owner := newAttestationWallet(t)
+	priv, err := GenerateIdentityKey(KeyTypeEd25519)
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	pid, _ := libpeer.IDFromPrivateKey(priv)
+
+	att, err := signOwnerAttestation(pid, priv, wm, owner, 1700000000)
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	if err := VerifyOwnerAttestation(att, pid.String(), owner, nil); err != nil {
+		t.Fatalf("expected attestation to verify: %v", err)
+	}
+
+	// A different peer cannot reuse the attestation.
+	otherPriv, _ := GenerateIdentityKey(KeyTypeEd25519)
+	otherID, _ := libpeer.IDFromPrivateKey(otherPriv)
+	if err := VerifyOwnerAttestation(att, otherID.String(), owner, nil); err == nil {
+		t.Fatalf("expected attestation to be rejected for another peer")
+	}
+
+	// Claiming the attestation for another owner must fail.
+	if err := VerifyOwnerAttestation(att, pid.String(), "someone-else", nil); err == nil {
+		t.Fatalf("expected attestation to be rejected for another owner")
+	}
+
+	// Tampering with the timestamp invalidates both signatures.
+	tampered := *att
+	tampered.IssuedAt++
+	if err := VerifyOwnerAttestation(&tampered, pid.String(), owner, nil); err == nil {
+		t.Fatalf("expected tampered attestation to be rejected")
+	}
+
+	// A peer signature from a key that does not own the ID is rejected.
+	forged, _ := signOwnerAttestation(pid, otherPriv, wm, owner, 1700000000)
+	if err := VerifyOwnerAttestation(forged, pid.String(), owner, nil); err == nil {
+		t.Fatalf("expected forged peer signature to be rejected")
+	}
+}
+
+func TestVerifiedOwner(t *testing.T) {
+	p := Peer{Owner: "wallet", OwnerVerified: false}
+	if p.VerifiedOwner() != "" {
+		t.Fatalf("unverified owner must not be trusted")
+	}
+	p.OwnerVerified = true
+	if p.VerifiedOwner() != "wallet" {
+		t.Fatalf("expected verified owner to be returned")
+	}
+}
+
+func TestVerifyPeerOwnerCountsAttestationOnce(t *testing.T) {
+	wm, owner := newAttestationWallet(t)
+	priv, err := GenerateIdentityKey(KeyTypeEd25519)
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	pid, _ := libpeer.IDFromPrivateKey(priv)
+	mn := mocknet.New()
+	t.Cleanup(func() { mn.Close() })
+	h, err := mn.GenPeer()
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	signatures := func() (int64, int64) {
+		for _, r := range GetReputations() {
+			if r.PeerID == pid.String() {
+				return r.ValidSignatures, r.InvalidSignatures
+			}
+		}
+		return 0, 0
+	}
+
+	issuedAt := time.Now().Add(-time.Hour).Unix()
+	att, err := signOwnerAttestation(pid, priv, wm, owner, issuedAt)
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		p := Peer{Owner: owner, OwnerAttestation: att}
+		verifyPeerOwner(h, pid.String(), &p)
+		if !p.OwnerVerified {
+			t.Fatalf("expected attestation to verify")
+		}
+	}
+	// Anyone can write a broken attestation under the peer's key.
+	tampered := *att
+	tampered.IssuedAt++
+	p := Peer{Owner: owner, OwnerAttestation: &tampered}
+	verifyPeerOwner(h, pid.String(), &p)
+	if p.OwnerVerified {
+		t.Fatalf("expected tampered attestation to be rejected")
+	}
+	if valid, invalid := signatures(); valid != 1 || invalid != 0 {
+		t.Fatalf("expected one valid and no invalid signature, got %d and %d", valid, invalid)
+	}
+
+	renewed, err := signOwnerAttestation(pid, priv, wm, owner, issuedAt+100)
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	p = Peer{Owner: owner, OwnerAttestation: renewed}
+	verifyPeerOwner(h, pid.String(), &p)
+	if valid, _ := signatures(); valid != 2 {
+		t.Fatalf("expected a renewed attestation to count, got %d", valid)
+	}
+}
+
+func TestVerifyPeerOwnerRejectsStaleAttestation(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	wm, owner := newAttestationWallet(t)
+	priv, err := GenerateIdentityKey(KeyTypeEd25519)
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	pid, _ := libpeer.IDFromPrivateKey(priv)
+	mn := mocknet.New()
+	t.Cleanup(func() { mn.Close() })
+	h, err := mn.GenPeer()
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	verified := func(issuedAt time.Time) bool {
+		att, err := signOwnerAttestation(pid, priv, wm, owner, issuedAt.Unix())
+		if err != nil {
+			t.Fatalf("unexpected: %v", err)
+		}
+		p := Peer{Owner: owner, OwnerAttestation: att}
+		verifyPeerOwner(h, pid.String(), &p)
+		return p.OwnerVerified
+	}
+
+	if !verified(time.Now().Add(-time.Hour)) {
+		t.Fatalf("expected a recent attestation to verify")
+	}
+	if verified(time.Now().Add(-defaultAttestationMaxAge - time.Hour)) {
+		t.Fatalf("expected an attestation older than the maximum age to be rejected")
+	}
+	if verified(time.Now().Add(time.Hour)) {
+		t.Fatalf("expected an attestation from the future to be rejected")
+	}
+	viper.Set("attestation.max_age", "30m")
+	if verified(time.Now().Add(-time.Hour)) {
+		t.Fatalf("expected attestation.max_age to be honoured")
+	}
+}
+
+func TestOwnerVerifiedIsNotReplicated(t *testing.T) {
+	data, err := json.Marshal(Peer{ID: "peer", Owner: "wallet", OwnerVerified: true})
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	var p Peer
+	if err := json.Unmarshal([]byte(strings.Replace(string(data), "{", `{"owner_verified":true,`, 1)), &p); err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	if strings.Contains(string(data), "owner_verified") || p.OwnerVerified {
+		t.Fatalf("expected OwnerVerified to stay off the wire, got %s", data)
+	}
+}
+
+func TestRegistrarRenewsAttestation(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	wm, owner := newAttestationWallet(t)
+	mn := mocknet.New()
+	t.Cleanup(func() { mn.Close() })
+	h, err := mn.GenPeer()
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	n := newNode(NodeConfig{})
+	n.host = h
+	r := n.registrar
+	priv := h.Peerstore().PrivKey(h.ID())
+	recent := time.Now().Add(-time.Hour).Unix()
+	att, err := signOwnerAttestation(h.ID(), priv, wm, owner, recent)
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	r.self = Peer{ID: h.ID().String(), Owner: owner, OwnerAttestation: att}
+	r.wallet = wm
+
+	r.renewAttestation()
+	if r.self.OwnerAttestation.IssuedAt != recent {
+		t.Fatalf("expected a recent attestation to be kept")
+	}
+	old := time.Now().Add(-defaultAttestationMaxAge / 2).Add(-time.Minute).Unix()
+	r.self.OwnerAttestation, err = signOwnerAttestation(h.ID(), priv, wm, owner, old)
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	r.renewAttestation()
+	renewed := r.self.OwnerAttestation
+	if renewed.IssuedAt <= old {
+		t.Fatalf("expected the attestation to be renewed")
+	}
+	if err := VerifyOwnerAttestation(renewed, h.ID().String(), owner, priv.GetPublic()); err != nil {
+		t.Fatalf("expected the renewed attestation to verify: %v", err)
+	}
+}
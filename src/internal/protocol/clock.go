@@ -2,7 +2,6 @@ package protocol
 
 import (
 	"context"
-	"encoding/json"
 	"math/rand"
 	"ocf/internal/common"
 	"ocf/internal/common/process"
@@ -26,47 +25,9 @@ func StartTicker() {
 	})
 	common.ReportError(err, "Error while creating verification ticker")
 	err = gocron.Every(30).Second().Do(func() {
-		host, _ := GetP2PNode(nil)
-		peers := host.Peerstore().Peers()
-		// updateMyself()
-		var reconnected = 0
-		var disconnected = 0
-		for _, peer_id := range peers {
-			// check if peer is still connected
-			p, error := GetPeerFromTable(peer_id.String())
-			if error == nil {
-				if host.Network().Connectedness(peer_id) == network.Connected {
-					p.Connected = true
-				} else if peer_id != host.ID() && host.Network().Connectedness(peer_id) != network.Connected {
-					// try to dial the peer, if cannot dial, then mark it as disconnected
-					ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-					defer cancel()
-					addrInfo := libpeer.AddrInfo{ID: peer_id, Addrs: host.Peerstore().Addrs(peer_id)}
-					if len(addrInfo.Addrs) == 0 {
-						p.Connected = false
-						disconnected++
-					} else if err := host.Connect(ctx, addrInfo); err != nil {
-						common.Logger.With("err", err).Warnf("Failed to dial peer %s; marking disconnected", peer_id)
-						p.Connected = false
-						disconnected++
-					} else {
-						// Successfully reconnected
-						common.Logger.Infof("Reconnected to peer %s", peer_id)
-						p.Connected = true
-						reconnected++
-					}
-				}
-				// update last seen timestamp
-				p.LastSeen = time.Now().Unix()
-				value, err := json.Marshal(p)
-				if err == nil {
-					UpdateNodeTableHook(ds.NewKey(peer_id.String()), value)
-				} else {
-					common.Logger.Error("Error while marshalling peer: ", peer_id.String(), err)
-				}
-			}
-		}
-		if !process.HealthCheck() {
+		reconnected, disconnected := DefaultNode().verifyConnections()
+		// subprocesses are stopped on purpose while draining
+		if !IsDraining() && !process.HealthCheck() {
 			common.Logger.Error("Health check failed")
 			// exit myself
 			os.Exit(1)
@@ -77,7 +38,7 @@ func StartTicker() {
 
 	// Add resource monitoring every 2 minutes
 	err = gocron.Every(2).Minutes().Do(func() {
-		GetResourceManagerStats()
+		LogResourceManagerStats()
 
 		// Also log current connection count for easy monitoring
 		connectedPeers := ConnectedPeers()
@@ -94,35 +55,96 @@ func StartTicker() {
 		}
 
 		// Cleanup: remove peers that have been disconnected for a long time
-		// Define staleness threshold
-		staleAfter := 10 * time.Minute
-		table := *GetAllPeers()
-		now := time.Now().Unix()
-		for id, p := range table {
-			if !p.Connected && p.LastSeen > 0 {
-				if time.Unix(p.LastSeen, 0).Add(staleAfter).Before(time.Now()) {
-					common.Logger.Warnf("Removing stale peer %s (last seen %v)", id, time.Unix(p.LastSeen, 0))
-					DeleteNodeTableHook(ds.NewKey(id))
+		DefaultNode().cleanupStalePeers()
+	})
+	common.ReportError(err, "Error while creating resource monitoring and clean-up ticker")
+
+	err = gocron.Every(1).Minute().Do(SaveReputations)
+	common.ReportError(err, "Error while creating reputation persistence ticker")
+
+	err = gocron.Every(1).Minute().Do(ProbeLatencies)
+	common.ReportError(err, "Error while creating latency probe ticker")
+
+	err = gocron.Every(1).Minute().Do(RenewLeaseIfDue)
+	common.ReportError(err, "Error while creating lease renewal ticker")
+
+	err = gocron.Every(5).Minutes().Do(ExpireLeases)
+	common.ReportError(err, "Error while creating lease expiry ticker")
+
+	err = gocron.Every(1).Hour().Do(AdvertiseLocalServices, false)
+	common.ReportError(err, "Error while creating DHT reprovide ticker")
+	<-gocron.Start()
+}
+
+// verifyConnections dials the peers of the node table we are not connected
+// to, and marks those that cannot be reached as disconnected. Entries are
+// stored as structs, so that locally computed fields like OwnerVerified and
+// the lease are kept. It returns how many peers were reconnected and how
+// many were marked disconnected.
+func (n *Node) verifyConnections() (int, int) {
+	host := n.host
+	peers := host.Peerstore().Peers()
+	var reconnected = 0
+	var disconnected = 0
+	for _, peer_id := range peers {
+		// check if peer is still connected
+		p, error := n.table.get(peer_id.String())
+		if error == nil {
+			if host.Network().Connectedness(peer_id) == network.Connected {
+				p.Connected = true
+			} else if peer_id != host.ID() && host.Network().Connectedness(peer_id) != network.Connected {
+				// try to dial the peer, if cannot dial, then mark it as disconnected
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				addrInfo := libpeer.AddrInfo{ID: peer_id, Addrs: host.Peerstore().Addrs(peer_id)}
+				if len(addrInfo.Addrs) == 0 {
+					p.Connected = false
+					disconnected++
+				} else if err := host.Connect(ctx, addrInfo); err != nil {
+					common.Logger.With("err", err).Warnf("Failed to dial peer %s; marking disconnected", peer_id)
+					p.Connected = false
+					disconnected++
+				} else {
+					// Successfully reconnected
+					common.Logger.Infof("Reconnected to peer %s", peer_id)
+					p.Connected = true
+					reconnected++
 				}
 			}
-			// Also mark peers with very old LastSeen as disconnected
-			if p.Connected && p.LastSeen > 0 && time.Unix(p.LastSeen, 0).Add(2*time.Minute).Before(time.Now()) {
-				p.Connected = false
-				value, err := json.Marshal(p)
-				if err == nil {
-					UpdateNodeTableHook(ds.NewKey(id), value)
-				}
+			if peer_id != host.ID() {
+				RecordAvailability(peer_id.String(), p.Connected)
 			}
-			// If LastSeen is zero, initialize it now
-			if p.LastSeen == 0 {
-				p.LastSeen = now
-				value, err := json.Marshal(p)
-				if err == nil {
-					UpdateNodeTableHook(ds.NewKey(id), value)
-				}
+			// update last seen timestamp
+			p.LastSeen = time.Now().Unix()
+			n.table.put(ds.NewKey(peer_id.String()), p)
+		}
+	}
+	return reconnected, disconnected
+}
+
+// cleanupStalePeers removes peers that have been disconnected for a long
+// time, and marks peers that have not been seen lately as disconnected.
+func (n *Node) cleanupStalePeers() {
+	// Define staleness threshold
+	staleAfter := 10 * time.Minute
+	table := *n.table.all()
+	now := time.Now().Unix()
+	for id, p := range table {
+		if !p.Connected && p.LastSeen > 0 {
+			if time.Unix(p.LastSeen, 0).Add(staleAfter).Before(time.Now()) {
+				common.Logger.Warnf("Removing stale peer %s (last seen %v)", id, time.Unix(p.LastSeen, 0))
+				n.table.remove(ds.NewKey(id))
 			}
 		}
-	})
-	common.ReportError(err, "Error while creating resource monitoring and clean-up ticker")
-	<-gocron.Start()
+		// Also mark peers with very old LastSeen as disconnected
+		if p.Connected && p.LastSeen > 0 && time.Unix(p.LastSeen, 0).Add(2*time.Minute).Before(time.Now()) {
+			p.Connected = false
+			n.table.put(ds.NewKey(id), p)
+		}
+		// If LastSeen is zero, initialize it now
+		if p.LastSeen == 0 {
+			p.LastSeen = now
+			n.table.put(ds.NewKey(id), p)
+		}
+	}
 }
@@ -0,0 +1,51 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+)
+
+func TestVerificationKeepsLocalFields(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	mn, err := mocknet.FullMeshConnected(2)
+	if err != nil {
+		t.Fatalf("mocknet failed: %v", err)
+	}
+	defer mn.Close()
+	hosts := mn.Hosts()
+	n := newNode(NodeConfig{})
+	n.host = hosts[0]
+	remote := hosts[1].ID().String()
+	n.host.Peerstore().AddAddrs(hosts[1].ID(), hosts[1].Addrs(), time.Hour)
+	lease := time.Now().Add(time.Hour).Unix()
+	n.table.put(ds.NewKey(remote), Peer{ID: remote, Owner: "wallet", OwnerVerified: true, LeaseExpires: lease})
+
+	check := func(step string) Peer {
+		t.Helper()
+		p, err := n.table.get(remote)
+		if err != nil {
+			t.Fatalf("%s dropped the peer: %v", step, err)
+		}
+		if p.VerifiedOwner() != "wallet" || p.LeaseExpires != lease {
+			t.Fatalf("%s lost the verified owner or the lease: %+v", step, p)
+		}
+		return p
+	}
+
+	n.verifyConnections()
+	if p := check("verification"); !p.Connected {
+		t.Fatalf("expected the connected peer to be marked connected")
+	}
+
+	// A peer not seen for a while is marked disconnected.
+	p := check("verification")
+	p.LastSeen = time.Now().Add(-5 * time.Minute).Unix()
+	n.table.peers["/"+remote] = p
+	n.cleanupStalePeers()
+	if p := check("cleanup"); p.Connected {
+		t.Fatalf("expected the silent peer to be marked disconnected")
+	}
+}
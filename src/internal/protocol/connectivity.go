@@ -0,0 +1,239 @@
+package protocol
+
+import (
+	"context"
+	"ocf/internal/common"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/spf13/viper"
+)
+
+const (
+	ReachabilityUnknown = "unknown"
+	ReachabilityPublic  = "public"
+	ReachabilityPrivate = "private"
+)
+
+// ConnectionInfo describes one open connection and whether it goes through
+// a relay.
+type ConnectionInfo struct {
+	PeerID     string `json:"peer_id"`
+	RemoteAddr string `json:"remote_addr"`
+	Direction  string `json:"direction"`
+	Relayed    bool   `json:"relayed"`
+	Opened     int64  `json:"opened"`
+	Streams    int    `json:"streams"`
+}
+
+// ConnectivityStatus summarizes how this node can be reached.
+type ConnectivityStatus struct {
+	Reachability      string           `json:"reachability"`
+	RelayReservations []string         `json:"relay_reservations"`
+	RelayAddrs        []string         `json:"relay_addrs"`
+	DirectPeers       int              `json:"direct_peers"`
+	RelayedPeers      int              `json:"relayed_peers"`
+	Connections       []ConnectionInfo `json:"connections"`
+}
+
+type connectivityState struct {
+	mu           sync.RWMutex
+	reachability network.Reachability
+}
+
+var connectivity = &connectivityState{}
+
+func reachabilityName(r network.Reachability) string {
+	switch r {
+	case network.ReachabilityPublic:
+		return ReachabilityPublic
+	case network.ReachabilityPrivate:
+		return ReachabilityPrivate
+	default:
+		return ReachabilityUnknown
+	}
+}
+
+// Reachability returns whether AutoNAT considers this node publicly reachable.
+func Reachability() string {
+	connectivity.mu.RLock()
+	defer connectivity.mu.RUnlock()
+	return reachabilityName(connectivity.reachability)
+}
+
+func isRelayAddr(addr multiaddr.Multiaddr) bool {
+	if addr == nil {
+		return false
+	}
+	_, err := addr.ValueForProtocol(multiaddr.P_CIRCUIT)
+	return err == nil
+}
+
+// isRelayedConn reports whether c runs over a circuit relay.
+func isRelayedConn(c network.Conn) bool {
+	return c.Stat().Limited || isRelayAddr(c.RemoteMultiaddr())
+}
+
+// relayReservations returns the relays that currently hold a reservation for
+// us, as seen from our relay addresses, and those addresses.
+func relayReservations(h host.Host) ([]string, []string) {
+	relays := map[string]struct{}{}
+	var addrs []string
+	for _, addr := range h.Addrs() {
+		if !isRelayAddr(addr) {
+			continue
+		}
+		addrs = append(addrs, addr.String())
+		relayPart, _ := multiaddr.SplitFunc(addr, func(c multiaddr.Component) bool {
+			return c.Protocol().Code == multiaddr.P_CIRCUIT
+		})
+		if relayPart == nil {
+			continue
+		}
+		if id, err := relayPart.ValueForProtocol(multiaddr.P_P2P); err == nil {
+			relays[id] = struct{}{}
+		}
+	}
+	out := make([]string, 0, len(relays))
+	for id := range relays {
+		out = append(out, id)
+	}
+	sort.Strings(out)
+	return out, addrs
+}
+
+// GetConnectivityStatus returns reachability, relay reservations and the
+// direct or relayed state of every open connection.
+func GetConnectivityStatus() ConnectivityStatus {
+	h, _ := GetP2PNode(nil)
+	status := ConnectivityStatus{Reachability: Reachability()}
+	status.RelayReservations, status.RelayAddrs = relayReservations(h)
+
+	direct := map[peer.ID]bool{}
+	for _, c := range h.Network().Conns() {
+		relayed := isRelayedConn(c)
+		stat := c.Stat()
+		status.Connections = append(status.Connections, ConnectionInfo{
+			PeerID:     c.RemotePeer().String(),
+			RemoteAddr: c.RemoteMultiaddr().String(),
+			Direction:  strings.ToLower(stat.Direction.String()),
+			Relayed:    relayed,
+			Opened:     stat.Opened.Unix(),
+			Streams:    stat.NumStreams,
+		})
+		if !relayed {
+			direct[c.RemotePeer()] = true
+		} else if _, ok := direct[c.RemotePeer()]; !ok {
+			direct[c.RemotePeer()] = false
+		}
+	}
+	for _, d := range direct {
+		if d {
+			status.DirectPeers++
+		} else {
+			status.RelayedPeers++
+		}
+	}
+	sort.Slice(status.Connections, func(i, j int) bool {
+		return status.Connections[i].PeerID < status.Connections[j].PeerID
+	})
+	return status
+}
+
+// HasDirectConnection reports whether at least one connection to peerID does
+// not go through a relay.
+func HasDirectConnection(peerID string) bool {
+	h, _ := GetP2PNode(nil)
+	return hasDirectConnection(h, peerID)
+}
+
+// HasDirectConnection reports whether the node is connected to peerID
+// without a relay.
+func (n *Node) HasDirectConnection(peerID string) bool {
+	return hasDirectConnection(n.host, peerID)
+}
+
+func hasDirectConnection(h host.Host, peerID string) bool {
+	pid, err := peer.Decode(peerID)
+	if err != nil {
+		return false
+	}
+	for _, c := range h.Network().ConnsToPeer(pid) {
+		if !isRelayedConn(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// staticRelayOption enables AutoRelay with the relays listed in
+// p2p.static_relays. Without it the node never reserves a relay slot.
+func staticRelayOption() (libp2p.Option, error) {
+	addrs, err := parseMultiaddrs(viper.GetStringSlice("p2p.static_relays"))
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, nil
+	}
+	infos, err := peer.AddrInfosFromP2pAddrs(addrs...)
+	if err != nil {
+		return nil, err
+	}
+	return libp2p.EnableAutoRelayWithStaticRelays(infos), nil
+}
+
+// watchConnectivity follows reachability and address changes and
+// re-announces our node table entry when they change.
+func watchConnectivity(ctx context.Context, h host.Host) {
+	sub, err := h.EventBus().Subscribe([]interface{}{
+		new(event.EvtLocalReachabilityChanged),
+		new(event.EvtLocalAddressesUpdated),
+	})
+	if err != nil {
+		common.ReportError(err, "Error while subscribing to connectivity events")
+		return
+	}
+	defer sub.Close()
+
+	// Coalesce bursts of address updates into a single announcement.
+	const settle = 5 * time.Second
+	var pending <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok := <-sub.Out():
+			if !ok {
+				return
+			}
+			switch evt := e.(type) {
+			case event.EvtLocalReachabilityChanged:
+				connectivity.mu.Lock()
+				connectivity.reachability = evt.Reachability
+				connectivity.mu.Unlock()
+				common.Logger.Infof("Reachability changed to %s", reachabilityName(evt.Reachability))
+			case event.EvtLocalAddressesUpdated:
+				if !evt.Diffs {
+					continue
+				}
+			}
+			if pending == nil {
+				pending = time.After(settle)
+			}
+		case <-pending:
+			pending = nil
+			if std.registrar.Self().ID != "" {
+				ReannounceLocalServices()
+			}
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/multiformats/go-multiaddr"
+)
+
+func TestIsRelayAddr(t *testing.T) {
+	relay := "/ip4/203.0.113.7/tcp/4001/p2p/12D3KooWJWoaqZhDaoEFshF7Rh1bpY9ohihFhzcW6d69Lr2NASuq/p2p-circuit"
+	if !isRelayAddr(multiaddr.StringCast(relay)) {
+		t.Fatalf("expected circuit address to be relayed")
+	}
+	if isRelayAddr(multiaddr.StringCast("/ip4/203.0.113.7/tcp/4001")) {
+		t.Fatalf("expected plain address to be direct")
+	}
+	if isRelayAddr(nil) {
+		t.Fatalf("expected nil address to be direct")
+	}
+}
+
+func TestReachabilityName(t *testing.T) {
+	cases := map[network.Reachability]string{
+		network.ReachabilityPublic:  ReachabilityPublic,
+		network.ReachabilityPrivate: ReachabilityPrivate,
+		network.ReachabilityUnknown: ReachabilityUnknown,
+	}
+	for r, want := range cases {
+		if got := reachabilityName(r); got != want {
+			t.Fatalf("expected %s, got %s", want, got)
+		}
+	}
+}
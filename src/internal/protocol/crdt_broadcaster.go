@@ -0,0 +1,75 @@
+package protocol
+
+import (
+	"context"
+	"fmt"
+	"ocf/internal/common"
+	"strings"
+
+	crdt "ocf/internal/protocol/go-ds-crdt"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/host"
+	libpeer "github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	libp2pprotocol "github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/spf13/viper"
+)
+
+// DirectBroadcastProtocol carries CRDT heads between the peers listed in
+// crdt.direct_peers when crdt.broadcaster is "direct".
+const DirectBroadcastProtocol = libp2pprotocol.ID("/ocf/crdt-broadcast/1.0.0")
+
+// newBroadcaster returns the CRDT broadcaster selected by crdt.broadcaster:
+// gossipsub on the CRDT topic (the default), or direct streams to the peers
+// in crdt.direct_peers.
+func newBroadcaster(ctx context.Context, psub *pubsub.PubSub, h host.Host) (crdt.Broadcaster, error) {
+	switch kind := strings.ToLower(viper.GetString("crdt.broadcaster")); kind {
+	case "", "pubsub":
+		return crdt.NewPubSubBroadcaster(ctx, psub, pubsubTopic)
+	case "direct":
+		peers, err := directPeers(h)
+		if err != nil {
+			return nil, err
+		}
+		common.Logger.Infof("Broadcasting CRDT heads directly to %d peer(s)", len(peers))
+		return crdt.NewDirectBroadcaster(ctx, h, DirectBroadcastProtocol, peers)
+	default:
+		return nil, fmt.Errorf("unknown crdt.broadcaster %q (want pubsub or direct)", kind)
+	}
+}
+
+// directPeers reads crdt.direct_peers, which takes peer IDs or multiaddrs
+// ending in /p2p/<id>. Addresses are added to the peerstore so the peers
+// can be dialed. The list must be set explicitly: heads only flow between
+// peers that list each other, which the bootstraps generally do not.
+func directPeers(h host.Host) ([]libpeer.ID, error) {
+	entries := viper.GetStringSlice("crdt.direct_peers")
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("crdt.broadcaster is direct but crdt.direct_peers is empty")
+	}
+	peers := make([]libpeer.ID, 0, len(entries))
+	for _, e := range entries {
+		e = strings.TrimSpace(e)
+		if strings.HasPrefix(e, "/") {
+			addr, err := multiaddr.NewMultiaddr(e)
+			if err != nil {
+				return nil, fmt.Errorf("crdt.direct_peers: %w", err)
+			}
+			info, err := libpeer.AddrInfoFromP2pAddr(addr)
+			if err != nil {
+				return nil, fmt.Errorf("crdt.direct_peers: %w", err)
+			}
+			h.Peerstore().AddAddrs(info.ID, info.Addrs, peerstore.PermanentAddrTTL)
+			peers = append(peers, info.ID)
+			continue
+		}
+		id, err := libpeer.Decode(e)
+		if err != nil {
+			return nil, fmt.Errorf("crdt.direct_peers: %w", err)
+		}
+		peers = append(peers, id)
+	}
+	return peers, nil
+}
@@ -0,0 +1,148 @@
+package protocol
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	crdt "ocf/internal/protocol/go-ds-crdt"
+
+	mdutils "github.com/ipfs/boxo/ipld/merkledag/test"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	ipld "github.com/ipfs/go-ipld-format"
+	libpeer "github.com/libp2p/go-libp2p/core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/spf13/viper"
+)
+
+func newReplica(t *testing.T, dag ipld.DAGService, bc crdt.Broadcaster) *crdt.Datastore {
+	t.Helper()
+	store, err := crdt.New(dssync.MutexWrap(ds.NewMapDatastore()), ds.NewKey("test"), dag, bc, crdt.DefaultOptions())
+	if err != nil {
+		t.Fatalf("crdt.New failed: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func waitForValue(t *testing.T, store *crdt.Datastore, key, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if v, err := store.Get(context.Background(), ds.NewKey(key)); err == nil && string(v) == want {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("replica never saw %s=%s", key, want)
+}
+
+func TestMemoryBroadcasterReplicas(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hub := crdt.NewMemoryHub()
+	// Replicas share the DAG service, so only heads need to travel.
+	dag := mdutils.Mock()
+	bcs := []*crdt.MemoryBroadcaster{hub.Join(ctx), hub.Join(ctx), hub.Join(ctx)}
+	replicas := make([]*crdt.Datastore, len(bcs))
+	for i, bc := range bcs {
+		replicas[i] = newReplica(t, dag, bc)
+	}
+
+	if err := replicas[0].Put(ctx, ds.NewKey("/a"), []byte("1")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	for _, r := range replicas[1:] {
+		waitForValue(t, r, "/a", "1")
+	}
+
+	bcs[2].SetPartitioned(true)
+	if err := replicas[1].Put(ctx, ds.NewKey("/b"), []byte("2")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	waitForValue(t, replicas[0], "/b", "2")
+	if ok, _ := replicas[2].Has(ctx, ds.NewKey("/b")); ok {
+		t.Fatalf("partitioned replica should not have received /b")
+	}
+
+	// Once healed, the next head links back to what was missed.
+	bcs[2].SetPartitioned(false)
+	if err := replicas[0].Put(ctx, ds.NewKey("/c"), []byte("3")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	waitForValue(t, replicas[2], "/c", "3")
+	waitForValue(t, replicas[2], "/b", "2")
+}
+
+func TestDirectBroadcaster(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mn, err := mocknet.FullMeshConnected(3)
+	if err != nil {
+		t.Fatalf("mocknet failed: %v", err)
+	}
+	defer mn.Close()
+	hosts := mn.Hosts()
+	a, b, outsider := hosts[0], hosts[1], hosts[2]
+
+	bcA, _ := crdt.NewDirectBroadcaster(ctx, a, DirectBroadcastProtocol, []libpeer.ID{a.ID(), b.ID()})
+	bcB, _ := crdt.NewDirectBroadcaster(ctx, b, DirectBroadcastProtocol, []libpeer.ID{a.ID()})
+	bcOut, _ := crdt.NewDirectBroadcaster(ctx, outsider, DirectBroadcastProtocol, []libpeer.ID{b.ID()})
+
+	if got := bcA.Peers(); len(got) != 1 || got[0] != b.ID() {
+		t.Fatalf("expected self to be left out of the peer set, got %v", got)
+	}
+	// b does not list the outsider, so it resets the stream.
+	if err := bcOut.Broadcast(ctx, []byte("intruder")); err == nil {
+		t.Fatalf("expected a broadcast to a peer that does not accept us to fail")
+	}
+	if err := bcA.Broadcast(ctx, []byte("heads")); err != nil {
+		t.Fatalf("Broadcast failed: %v", err)
+	}
+	nextCtx, nextCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer nextCancel()
+	data, err := bcB.Next(nextCtx)
+	if err != nil || string(data) != "heads" {
+		t.Fatalf("expected the payload from a, got %q (%v)", data, err)
+	}
+
+	// Replicas over direct streams converge like over gossipsub.
+	dag := mdutils.Mock()
+	storeA := newReplica(t, dag, bcA)
+	storeB := newReplica(t, dag, bcB)
+	if err := storeA.Put(ctx, ds.NewKey("/k"), []byte("v")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	waitForValue(t, storeB, "/k", "v")
+}
+
+func TestDirectPeersConfig(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	mn, err := mocknet.FullMeshConnected(3)
+	if err != nil {
+		t.Fatalf("mocknet failed: %v", err)
+	}
+	defer mn.Close()
+	hosts := mn.Hosts()
+	h, p1, p2 := hosts[0], hosts[1], hosts[2]
+
+	if peers, err := directPeers(h); err == nil {
+		t.Fatalf("expected an empty crdt.direct_peers to be rejected, got %v", peers)
+	}
+
+	viper.Set("crdt.direct_peers", []string{p1.ID().String(), "/ip4/10.0.0.2/tcp/4001/p2p/" + p2.ID().String()})
+	peers, err := directPeers(h)
+	if err != nil || len(peers) != 2 || peers[0] != p1.ID() || peers[1] != p2.ID() {
+		t.Fatalf("unexpected peers %v (%v)", peers, err)
+	}
+	if addrs := h.Peerstore().Addrs(p2.ID()); len(addrs) == 0 {
+		t.Fatalf("expected the multiaddr to be added to the peerstore")
+	}
+
+	viper.Set("crdt.direct_peers", []string{"not-a-peer"})
+	if _, err := directPeers(h); err == nil {
+		t.Fatalf("expected an invalid entry to be rejected")
+	}
+}
@@ -0,0 +1,258 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"ocf/internal/common"
+	"sort"
+	"sync"
+	"time"
+
+	crdt "ocf/internal/protocol/go-ds-crdt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/spf13/viper"
+)
+
+const (
+	checkpointTopic             = "ocf-crdt-checkpoint"
+	defaultCheckpointInterval   = time.Hour
+	defaultCheckpointDepth      = 1000
+	defaultCheckpointQuorum     = 2
+	maxCheckpointProposalLength = 256
+)
+
+// checkpointProposal is what nodes publish on checkpointTopic: the block they
+// would checkpoint at an aligned height.
+type checkpointProposal struct {
+	Cid    string `json:"cid"`
+	Height uint64 `json:"height"`
+}
+
+// checkpointer agrees with other nodes on CRDT checkpoints. Every interval
+// each node proposes the block at the last multiple of depth that is at
+// least depth below its max height. Nodes sharing the same history propose
+// the same block; once quorum distinct nodes (including us) proposed a block
+// that we have processed, it becomes our checkpoint and, with
+// crdt.prune_history, the DAG below it is removed from the blockstore.
+type checkpointer struct {
+	store   *crdt.Datastore
+	self    peer.ID
+	depth   uint64
+	quorum  int
+	prune   bool
+	publish func([]byte) error
+
+	mu    sync.Mutex
+	votes map[cid.Cid]map[peer.ID]struct{}
+	// heights of the proposals in votes
+	heights map[cid.Cid]uint64
+	latest  uint64
+}
+
+func newCheckpointer(store *crdt.Datastore, self peer.ID) *checkpointer {
+	depth := viper.GetInt("crdt.checkpoint_depth")
+	if depth <= 0 {
+		depth = defaultCheckpointDepth
+	}
+	quorum := viper.GetInt("crdt.checkpoint_quorum")
+	if quorum <= 0 {
+		quorum = defaultCheckpointQuorum
+	}
+	c := &checkpointer{
+		store:   store,
+		self:    self,
+		depth:   uint64(depth),
+		quorum:  quorum,
+		prune:   viper.GetBool("crdt.prune_history"),
+		votes:   make(map[cid.Cid]map[peer.ID]struct{}),
+		heights: make(map[cid.Cid]uint64),
+	}
+	if cps, err := store.Checkpoints(context.Background()); err == nil && len(cps) > 0 {
+		c.latest = cps[0].Height
+	}
+	return c
+}
+
+// targetHeight returns the height to checkpoint at given the max height, or
+// 0 if the DAG is not deep enough yet.
+func (c *checkpointer) targetHeight(maxHeight uint64) uint64 {
+	if maxHeight <= c.depth {
+		return 0
+	}
+	return (maxHeight - c.depth) / c.depth * c.depth
+}
+
+// vote records that from proposed block at height.
+func (c *checkpointer) vote(block cid.Cid, height uint64, from peer.ID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if height <= c.latest {
+		return
+	}
+	voters, ok := c.votes[block]
+	if !ok {
+		voters = make(map[peer.ID]struct{})
+		c.votes[block] = voters
+		c.heights[block] = height
+	}
+	voters[from] = struct{}{}
+}
+
+// adopt turns the highest proposal that reached quorum and that we have
+// processed into a checkpoint. It reports whether a checkpoint was added.
+func (c *checkpointer) adopt(ctx context.Context) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var ready []cid.Cid
+	for block, voters := range c.votes {
+		if len(voters) >= c.quorum {
+			ready = append(ready, block)
+		}
+	}
+	sort.Slice(ready, func(i, j int) bool { return c.heights[ready[i]] > c.heights[ready[j]] })
+	var best cid.Cid
+	var bestHeight uint64
+	for _, block := range ready {
+		h := c.heights[block]
+		if err := c.store.AddCheckpoint(ctx, block, h); err != nil {
+			common.Logger.Debugf("Cannot checkpoint %s yet: %v", block, err)
+			continue
+		}
+		best, bestHeight = block, h
+		break
+	}
+	if !best.Defined() {
+		return false
+	}
+	c.latest = bestHeight
+	for block, h := range c.heights {
+		if h <= bestHeight {
+			delete(c.votes, block)
+			delete(c.heights, block)
+		}
+	}
+	common.Logger.Infof("Agreed on CRDT checkpoint %s at height %d", best, bestHeight)
+	return true
+}
+
+// handle processes a proposal received from another node.
+func (c *checkpointer) handle(ctx context.Context, data []byte, from peer.ID) {
+	if len(data) > maxCheckpointProposalLength {
+		return
+	}
+	var p checkpointProposal
+	if err := json.Unmarshal(data, &p); err != nil {
+		return
+	}
+	block, err := cid.Decode(p.Cid)
+	if err != nil || p.Height == 0 || p.Height%c.depth != 0 {
+		return
+	}
+	c.vote(block, p.Height, from)
+	if c.adopt(ctx) {
+		c.pruneHistory(ctx)
+	}
+}
+
+// propose publishes our candidate for the current target height.
+func (c *checkpointer) propose(ctx context.Context) {
+	height := c.targetHeight(c.store.InternalStats(ctx).MaxHeight)
+	c.mu.Lock()
+	latest := c.latest
+	c.mu.Unlock()
+	if height == 0 || height <= latest {
+		return
+	}
+	candidate, err := c.store.CheckpointCandidate(ctx, height)
+	if err != nil {
+		common.Logger.Debugf("No checkpoint candidate at height %d: %v", height, err)
+		return
+	}
+	if candidate.Height != height {
+		return
+	}
+	c.vote(candidate.Cid, candidate.Height, c.self)
+	data, err := json.Marshal(checkpointProposal{Cid: candidate.Cid.String(), Height: candidate.Height})
+	if err != nil {
+		return
+	}
+	if c.publish != nil {
+		if err := c.publish(data); err != nil {
+			common.Logger.Warn("Error while publishing checkpoint proposal: ", err)
+		}
+	}
+	if c.adopt(ctx) {
+		c.pruneHistory(ctx)
+	}
+}
+
+func (c *checkpointer) pruneHistory(ctx context.Context) {
+	if !c.prune {
+		return
+	}
+	removed, err := c.store.PruneBelowCheckpoints(ctx)
+	if err != nil {
+		common.Logger.Warnf("Pruning CRDT history failed: %v", err)
+		return
+	}
+	if removed > 0 {
+		common.Logger.Infof("Pruned %d CRDT blocks below the latest checkpoint", removed)
+	}
+}
+
+// startCheckpointing joins the checkpoint topic and proposes checkpoints every
+// crdt.checkpoint_interval unless crdt.checkpoint_enabled is false. It runs
+// until the node is closed.
+func (n *Node) startCheckpointing() {
+	n.checkpointOnce.Do(func() {
+		psub, store, self := n.psub, n.store, n.host.ID()
+		if viper.IsSet("crdt.checkpoint_enabled") && !viper.GetBool("crdt.checkpoint_enabled") {
+			common.Logger.Info("CRDT checkpointing disabled")
+			return
+		}
+		interval := readDurationSetting("crdt.checkpoint_interval", defaultCheckpointInterval)
+		topic, err := psub.Join(checkpointTopic)
+		if err != nil {
+			common.ReportError(err, "Error while joining checkpoint topic")
+			return
+		}
+		sub, err := topic.Subscribe()
+		if err != nil {
+			common.ReportError(err, "Error while subscribing to checkpoint topic")
+			return
+		}
+		ctx := n.ctx
+		c := newCheckpointer(store, self)
+		c.publish = func(data []byte) error { return topic.Publish(ctx, data) }
+
+		n.background.Add(2)
+		go func() {
+			defer n.background.Done()
+			for {
+				msg, err := sub.Next(ctx)
+				if err != nil {
+					return
+				}
+				if msg.ReceivedFrom == self {
+					continue
+				}
+				c.handle(ctx, msg.Data, msg.GetFrom())
+			}
+		}()
+		go func() {
+			defer n.background.Done()
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					c.propose(ctx)
+				}
+			}
+		}()
+	})
+}
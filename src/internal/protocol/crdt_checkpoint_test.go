@@ -0,0 +1,137 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	crdt "ocf/internal/protocol/go-ds-crdt"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	mdutils "github.com/ipfs/boxo/ipld/merkledag/test"
+	"github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	ipld "github.com/ipfs/go-ipld-format"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/spf13/viper"
+)
+
+// localDAG is a DAG service that can tell which blocks it stores, like
+// ipfs-lite's Peer.
+type localDAG struct {
+	ipld.DAGService
+	has func(ctx context.Context, c cid.Cid) (bool, error)
+}
+
+func (d *localDAG) HasBlock(ctx context.Context, c cid.Cid) (bool, error) {
+	return d.has(ctx, c)
+}
+
+func newCheckpointTestStore(t *testing.T, puts int) (*crdt.Datastore, *localDAG) {
+	t.Helper()
+	bserv := mdutils.Bserv()
+	dag := &localDAG{
+		DAGService: merkledag.NewDAGService(bserv),
+		has:        bserv.Blockstore().Has,
+	}
+	store, err := crdt.New(dssync.MutexWrap(ds.NewMapDatastore()), ds.NewKey("test"), dag, nil, crdt.DefaultOptions())
+	if err != nil {
+		t.Fatalf("crdt.New failed: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	ctx := context.Background()
+	for i := 0; i < puts; i++ {
+		if err := store.Put(ctx, ds.NewKey("/peer"), []byte(fmt.Sprintf("heartbeat %d", i))); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	return store, dag
+}
+
+func TestCheckpointTargetHeight(t *testing.T) {
+	c := &checkpointer{depth: 100}
+	for maxHeight, want := range map[uint64]uint64{50: 0, 100: 0, 199: 0, 200: 100, 350: 200} {
+		if got := c.targetHeight(maxHeight); got != want {
+			t.Fatalf("targetHeight(%d) = %d, want %d", maxHeight, got, want)
+		}
+	}
+}
+
+func TestCheckpointAndPrune(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	viper.Set("crdt.checkpoint_depth", 3)
+	viper.Set("crdt.checkpoint_quorum", 1)
+	viper.Set("crdt.prune_history", true)
+	ctx := context.Background()
+	store, dag := newCheckpointTestStore(t, 10)
+
+	var published []byte
+	c := newCheckpointer(store, peer.ID("self"))
+	c.publish = func(data []byte) error { published = data; return nil }
+	c.propose(ctx)
+
+	var p checkpointProposal
+	if err := json.Unmarshal(published, &p); err != nil || p.Height != 6 {
+		t.Fatalf("expected a proposal at height 6, got %s (%v)", published, err)
+	}
+	cps, err := store.Checkpoints(ctx)
+	if err != nil || len(cps) != 1 || cps[0].Height != 6 || cps[0].Cid.String() != p.Cid {
+		t.Fatalf("expected checkpoint %s at height 6, got %v (%v)", p.Cid, cps, err)
+	}
+	if has, _ := dag.HasBlock(ctx, cps[0].Cid); !has {
+		t.Fatalf("expected the checkpoint block to be kept")
+	}
+
+	// Blocks 1-5 are gone; pruning again finds nothing.
+	if _, err := store.CheckpointCandidate(ctx, 3); err == nil {
+		t.Fatalf("expected blocks below the checkpoint to be pruned")
+	}
+	if removed, err := store.PruneBelowCheckpoints(ctx); err != nil || removed != 0 {
+		t.Fatalf("expected nothing left to prune, got %d (%v)", removed, err)
+	}
+	if err := store.Repair(ctx); err != nil {
+		t.Fatalf("Repair failed after pruning: %v", err)
+	}
+	if store.IsDirty(ctx) {
+		t.Fatalf("expected store to be clean after repair")
+	}
+	if v, err := store.Get(ctx, ds.NewKey("/peer")); err != nil || string(v) != "heartbeat 9" {
+		t.Fatalf("expected state to survive pruning, got %q (%v)", v, err)
+	}
+}
+
+func TestCheckpointQuorum(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	viper.Set("crdt.checkpoint_depth", 3)
+	ctx := context.Background()
+	store, _ := newCheckpointTestStore(t, 10)
+
+	c := newCheckpointer(store, peer.ID("self"))
+	candidate, err := store.CheckpointCandidate(ctx, 6)
+	if err != nil {
+		t.Fatalf("CheckpointCandidate failed: %v", err)
+	}
+	proposal, _ := json.Marshal(checkpointProposal{Cid: candidate.Cid.String(), Height: 6})
+
+	c.handle(ctx, proposal, peer.ID("other"))
+	if cps, _ := store.Checkpoints(ctx); len(cps) != 0 {
+		t.Fatalf("expected no checkpoint with a single vote, got %v", cps)
+	}
+	// Misaligned and oversized proposals are ignored.
+	bad, _ := json.Marshal(checkpointProposal{Cid: candidate.Cid.String(), Height: 5})
+	c.handle(ctx, bad, peer.ID("third"))
+	c.handle(ctx, make([]byte, maxCheckpointProposalLength+1), peer.ID("third"))
+	if cps, _ := store.Checkpoints(ctx); len(cps) != 0 {
+		t.Fatalf("expected invalid proposals to be ignored, got %v", cps)
+	}
+
+	c.handle(ctx, proposal, peer.ID("third"))
+	cps, _ := store.Checkpoints(ctx)
+	if len(cps) != 1 || cps[0].Cid != candidate.Cid {
+		t.Fatalf("expected checkpoint after quorum, got %v", cps)
+	}
+}
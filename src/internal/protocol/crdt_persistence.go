@@ -0,0 +1,120 @@
+package protocol
+
+import (
+	"context"
+	"ocf/internal/common"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	crdt "ocf/internal/protocol/go-ds-crdt"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/libp2p/go-libp2p/core/host"
+	libpeer "github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/spf13/viper"
+)
+
+const defaultRepairTimeout = 10 * time.Minute
+
+// CRDTDBPath returns where the CRDT database of nodeID is stored. It lives
+// under crdt.data_dir when set, otherwise under the home directory.
+func CRDTDBPath(nodeID string) string {
+	if dir := strings.TrimSpace(viper.GetString("crdt.data_dir")); dir != "" {
+		return path.Join(dir, "ocfcore."+nodeID+".db")
+	}
+	return common.GetDBPath(nodeID)
+}
+
+// PersistentCRDT reports whether CRDT state is kept across restarts, i.e.
+// whether cleanslate is disabled.
+func PersistentCRDT() bool {
+	return viper.IsSet("cleanslate") && !viper.GetBool("cleanslate")
+}
+
+// hasPersistedState reports whether a database from a previous run exists.
+func hasPersistedState(dbPath string) bool {
+	entries, err := os.ReadDir(dbPath)
+	return err == nil && len(entries) > 0
+}
+
+// restorePersistedState runs once a persisted store has been opened. It
+// checks the DAG and loads the peers known from the previous run.
+//
+// Stale self-entries: our own record from a previous run is never trusted.
+// It is skipped here and overwritten by InitializeMyself before we announce
+// anything, and on a clean shutdown it is deleted so peers stop routing to
+// us while we are down.
+func restorePersistedState(ctx context.Context, store *crdt.Datastore, h host.Host, table *nodeTable) {
+	selfID := h.ID().String()
+	checkCRDTIntegrity(ctx, store)
+
+	records, err := peerRecords(ctx, store)
+	if err != nil {
+		common.Logger.Warn("Could not read persisted node table: ", err)
+		return
+	}
+	restored := 0
+	for id, value := range records {
+		if id == selfID {
+			common.Logger.Info("Ignoring self entry from a previous run; it will be replaced")
+			continue
+		}
+		peer, err := decodePeerRecord(value)
+		if err != nil {
+			continue
+		}
+		verifyPeerOwner(h, id, &peer)
+		peer.ID = id
+		if !PeerRecordAllowed(peer) || leaseExpired(peer, time.Now()) {
+			continue
+		}
+		// Until the verification ticker reaches them, peers from the last
+		// run are assumed to be gone. Their announced addresses let it dial.
+		peer.Connected = false
+		if pid, err := libpeer.Decode(id); err == nil {
+			if addrs, err := parseMultiaddrs(peer.Addrs); err == nil && len(addrs) > 0 {
+				h.Peerstore().AddAddrs(pid, addrs, peerstore.RecentlyConnectedAddrTTL)
+			}
+		}
+		table.put(ds.NewKey(id), peer)
+		restored++
+	}
+	common.Logger.Infof("Restored %d peer(s) from persisted CRDT state", restored)
+}
+
+// checkCRDTIntegrity repairs the DAG if the previous run left it dirty (e.g.
+// it crashed mid-sync) or if crdt.repair_on_start forces it.
+func checkCRDTIntegrity(ctx context.Context, store *crdt.Datastore) {
+	dirty := store.IsDirty(ctx)
+	if !dirty && !viper.GetBool("crdt.repair_on_start") {
+		common.Logger.Info("Persisted CRDT state is clean")
+		return
+	}
+	timeout := readDurationSetting("crdt.repair_timeout", defaultRepairTimeout)
+	common.Logger.Infof("Repairing persisted CRDT DAG (dirty=%t, timeout %s)", dirty, timeout)
+	repairCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	started := time.Now()
+	if err := store.Repair(repairCtx); err != nil {
+		common.Logger.Warnf("CRDT repair did not complete: %v; it will be retried in the background", err)
+		return
+	}
+	common.Logger.Infof("CRDT repair finished in %s", time.Since(started).Round(time.Millisecond))
+}
+
+// RepairCRDT walks store's DAG from the current heads and reprocesses
+// anything left unprocessed, within crdt.repair_timeout.
+func RepairCRDT(ctx context.Context, store *crdt.Datastore) error {
+	repairCtx, cancel := context.WithTimeout(ctx, readDurationSetting("crdt.repair_timeout", defaultRepairTimeout))
+	defer cancel()
+	started := time.Now()
+	common.Logger.Info("Repairing CRDT DAG on request")
+	if err := store.Repair(repairCtx); err != nil {
+		return err
+	}
+	common.Logger.Infof("CRDT repair finished in %s", time.Since(started).Round(time.Millisecond))
+	return nil
+}
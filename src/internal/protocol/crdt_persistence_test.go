@@ -0,0 +1,56 @@
+package protocol
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestCRDTDBPath(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	t.Setenv("HOME", t.TempDir())
+
+	if p := CRDTDBPath("node"); !strings.HasSuffix(p, path.Join(".ocfcore", "ocfcore.node.db")) {
+		t.Fatalf("expected default path under the home directory, got %s", p)
+	}
+	dir := t.TempDir()
+	viper.Set("crdt.data_dir", dir)
+	if p := CRDTDBPath("node"); p != path.Join(dir, "ocfcore.node.db") {
+		t.Fatalf("expected path under crdt.data_dir, got %s", p)
+	}
+}
+
+func TestPersistentCRDT(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	if PersistentCRDT() {
+		t.Fatalf("expected clean slate by default")
+	}
+	viper.Set("cleanslate", false)
+	if !PersistentCRDT() {
+		t.Fatalf("expected persistence with cleanslate disabled")
+	}
+}
+
+func TestHasPersistedState(t *testing.T) {
+	dir := path.Join(t.TempDir(), "db")
+	if hasPersistedState(dir) {
+		t.Fatalf("expected missing directory to have no state")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if hasPersistedState(dir) {
+		t.Fatalf("expected empty directory to have no state")
+	}
+	if err := os.WriteFile(path.Join(dir, "MANIFEST"), []byte("x"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if !hasPersistedState(dir) {
+		t.Fatalf("expected populated directory to have state")
+	}
+}
@@ -0,0 +1,128 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"ocf/internal/common"
+	"os"
+	"strings"
+	"time"
+
+	crdt "ocf/internal/protocol/go-ds-crdt"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	libp2pprotocol "github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/spf13/viper"
+)
+
+// SnapshotProtocol serves CRDT snapshots to nodes joining the network.
+const SnapshotProtocol = libp2pprotocol.ID("/ocf/crdt-snapshot/1.0.0")
+
+const defaultSnapshotTimeout = 2 * time.Minute
+
+func snapshotServingEnabled() bool {
+	return !viper.IsSet("crdt.serve_snapshots") || viper.GetBool("crdt.serve_snapshots")
+}
+
+// registerSnapshotHandler lets peers download a snapshot of our CRDT state.
+func registerSnapshotHandler(h host.Host, store *crdt.Datastore) {
+	if !snapshotServingEnabled() {
+		return
+	}
+	h.SetStreamHandler(SnapshotProtocol, func(s network.Stream) {
+		defer s.Close()
+		remote := s.Conn().RemotePeer()
+		if !PeerAllowed(remote.String()) {
+			_ = s.Reset()
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), readDurationSetting("crdt.snapshot_timeout", defaultSnapshotTimeout))
+		defer cancel()
+		info, err := store.ExportSnapshot(ctx, s)
+		if err != nil {
+			common.Logger.With("peer", remote).Warnf("Could not serve CRDT snapshot: %v", err)
+			_ = s.Reset()
+			return
+		}
+		common.Logger.With("peer", remote).Infof("Served CRDT snapshot (%d records, height %d)", info.Records, info.MaxHeight)
+	})
+}
+
+// ExportSnapshotFile writes a snapshot of the CRDT store to path.
+func ExportSnapshotFile(ctx context.Context, store *crdt.Datastore, path string) (crdt.SnapshotInfo, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return crdt.SnapshotInfo{}, err
+	}
+	info, err := store.ExportSnapshot(ctx, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return info, err
+}
+
+// fetchSnapshot opens a snapshot stream to the first of peers that serves
+// one.
+func fetchSnapshot(ctx context.Context, h host.Host, peers []peer.AddrInfo) (io.ReadCloser, error) {
+	var errs []error
+	for _, info := range peers {
+		if info.ID == h.ID() {
+			continue
+		}
+		if err := h.Connect(ctx, info); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", info.ID, err))
+			continue
+		}
+		s, err := h.NewStream(ctx, info.ID, SnapshotProtocol)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", info.ID, err))
+			continue
+		}
+		_ = s.CloseWrite()
+		common.Logger.Infof("Downloading CRDT snapshot from %s", info.ID)
+		return s, nil
+	}
+	if len(errs) == 0 {
+		return nil, errors.New("no peer to fetch a snapshot from")
+	}
+	return nil, errors.Join(errs...)
+}
+
+// snapshotSource returns where a fresh store takes its initial state from:
+// crdt.snapshot_file if set, otherwise the bootstrap peers unless
+// crdt.snapshot_bootstrap is disabled.
+func snapshotSource(h host.Host, bootstraps []peer.AddrInfo) func(ctx context.Context) (io.ReadCloser, error) {
+	if file := strings.TrimSpace(viper.GetString("crdt.snapshot_file")); file != "" {
+		return func(ctx context.Context) (io.ReadCloser, error) {
+			common.Logger.Infof("Importing CRDT snapshot from %s", file)
+			return os.Open(file)
+		}
+	}
+	if viper.IsSet("crdt.snapshot_bootstrap") && !viper.GetBool("crdt.snapshot_bootstrap") {
+		return nil
+	}
+	return func(ctx context.Context) (io.ReadCloser, error) {
+		timeout := readDurationSetting("crdt.snapshot_timeout", defaultSnapshotTimeout)
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		rc, err := fetchSnapshot(ctx, h, bootstraps)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		return &cancelOnClose{ReadCloser: rc, cancel: cancel}, nil
+	}
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
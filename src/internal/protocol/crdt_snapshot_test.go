@@ -0,0 +1,131 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"testing"
+
+	crdt "ocf/internal/protocol/go-ds-crdt"
+
+	mdutils "github.com/ipfs/boxo/ipld/merkledag/test"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/spf13/viper"
+)
+
+func newTestCRDT(t *testing.T, opts *crdt.Options) *crdt.Datastore {
+	t.Helper()
+	if opts == nil {
+		opts = crdt.DefaultOptions()
+	}
+	store, err := crdt.New(dssync.MutexWrap(ds.NewMapDatastore()), ds.NewKey("test"), mdutils.Mock(), nil, opts)
+	if err != nil {
+		t.Fatalf("crdt.New failed: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	return store
+}
+
+func TestSnapshotExportImport(t *testing.T) {
+	ctx := context.Background()
+	src := newTestCRDT(t, nil)
+	for _, k := range []string{"/a", "/b", "/c"} {
+		if err := src.Put(ctx, ds.NewKey(k), []byte("value"+k)); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	if err := src.Delete(ctx, ds.NewKey("/b")); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	info, err := src.ExportSnapshot(ctx, &buf)
+	if err != nil {
+		t.Fatalf("ExportSnapshot failed: %v", err)
+	}
+	if len(info.Heads) != 1 || info.Records == 0 {
+		t.Fatalf("unexpected snapshot info: %+v", info)
+	}
+
+	var mu sync.Mutex
+	hooked := map[string]string{}
+	opts := crdt.DefaultOptions()
+	opts.PutHook = func(k ds.Key, v []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		hooked[k.String()] = string(v)
+	}
+	opts.SnapshotSource = func(context.Context) (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	}
+	dst := newTestCRDT(t, opts)
+
+	if got, err := dst.Get(ctx, ds.NewKey("/a")); err != nil || string(got) != "value/a" {
+		t.Fatalf("expected /a to be imported, got %q, %v", got, err)
+	}
+	if ok, _ := dst.Has(ctx, ds.NewKey("/b")); ok {
+		t.Fatalf("expected deleted key to stay deleted")
+	}
+	if hooked["/c"] != "value/c" || len(hooked) != 2 {
+		t.Fatalf("expected put hook for live keys, got %v", hooked)
+	}
+	want := src.InternalStats(ctx)
+	got := dst.InternalStats(ctx)
+	if len(got.Heads) != 1 || got.Heads[0] != want.Heads[0] || got.MaxHeight != want.MaxHeight {
+		t.Fatalf("expected heads %v/%d, got %v/%d", want.Heads, want.MaxHeight, got.Heads, got.MaxHeight)
+	}
+	// The DAG below the snapshot is not available, so repair must stop at
+	// the checkpoint.
+	if err := dst.Repair(ctx); err != nil {
+		t.Fatalf("Repair failed: %v", err)
+	}
+
+	if _, err := dst.ImportSnapshot(ctx, bytes.NewReader(buf.Bytes())); err != crdt.ErrSnapshotNotEmpty {
+		t.Fatalf("expected ErrSnapshotNotEmpty, got %v", err)
+	}
+}
+
+func TestSnapshotOverLibp2p(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	ctx := context.Background()
+
+	mn := mocknet.New()
+	defer mn.Close()
+	server, err := mn.GenPeer()
+	if err != nil {
+		t.Fatalf("GenPeer failed: %v", err)
+	}
+	client, err := mn.GenPeer()
+	if err != nil {
+		t.Fatalf("GenPeer failed: %v", err)
+	}
+	if err := mn.LinkAll(); err != nil {
+		t.Fatalf("LinkAll failed: %v", err)
+	}
+
+	src := newTestCRDT(t, nil)
+	if err := src.Put(ctx, ds.NewKey("/peer"), []byte("record")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	registerSnapshotHandler(server, src)
+
+	opts := crdt.DefaultOptions()
+	opts.SnapshotSource = snapshotSource(client, []peer.AddrInfo{{ID: server.ID(), Addrs: server.Addrs()}})
+	if opts.SnapshotSource == nil {
+		t.Fatalf("expected bootstrap snapshots to be enabled by default")
+	}
+	dst := newTestCRDT(t, opts)
+	if got, err := dst.Get(ctx, ds.NewKey("/peer")); err != nil || string(got) != "record" {
+		t.Fatalf("expected record from snapshot, got %q, %v", got, err)
+	}
+
+	viper.Set("crdt.snapshot_bootstrap", false)
+	if snapshotSource(client, nil) != nil {
+		t.Fatalf("expected no snapshot source when disabled")
+	}
+}
@@ -0,0 +1,231 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"ocf/internal/common"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/multiformats/go-multihash"
+	"github.com/spf13/viper"
+)
+
+const (
+	serviceCIDPrefix = "ocf/service/"
+	// dhtReprovideInterval is how often an unchanged set of services is
+	// advertised again. Provider records expire after 48h on the DHT.
+	dhtReprovideInterval      = time.Hour
+	defaultDHTLookupTimeout   = 10 * time.Second
+	defaultDHTLookupCount     = 20
+	dhtProviderConnectTimeout = 5 * time.Second
+)
+
+// serviceCID returns the content ID under which providers of a service are
+// advertised in the DHT. With an identity group (e.g. "model=llama3") the CID
+// is specific to that group, so that routers can look up a model directly.
+func serviceCID(serviceName string, identityGroup string) (cid.Cid, error) {
+	key := serviceCIDPrefix + serviceName
+	if identityGroup != "" {
+		key += "/" + identityGroup
+	}
+	hash, err := multihash.Sum([]byte(key), multihash.SHA2_256, -1)
+	if err != nil {
+		return cid.Undef, err
+	}
+	return cid.NewCidV1(cid.Raw, hash), nil
+}
+
+// serviceCIDs lists every CID a set of services is advertised under.
+func serviceCIDs(services []Service) ([]cid.Cid, []string) {
+	var cids []cid.Cid
+	var names []string
+	seen := make(map[string]struct{})
+	add := func(name, group string) {
+		label := name
+		if group != "" {
+			label += "/" + group
+		}
+		if _, ok := seen[label]; ok {
+			return
+		}
+		c, err := serviceCID(name, group)
+		if err != nil {
+			common.Logger.Warnf("Cannot derive DHT key for %s: %v", label, err)
+			return
+		}
+		seen[label] = struct{}{}
+		cids = append(cids, c)
+		names = append(names, label)
+	}
+	for _, svc := range services {
+		add(svc.Name, "")
+		for _, group := range svc.IdentityGroup {
+			add(svc.Name, group)
+		}
+	}
+	return cids, names
+}
+
+func dhtDiscoveryEnabled() bool {
+	return !viper.IsSet("dht.service_discovery") || viper.GetBool("dht.service_discovery")
+}
+
+// AdvertiseLocalServices announces the services of the default node in the
+// DHT.
+func AdvertiseLocalServices(force bool) {
+	std.AdvertiseServices(force)
+}
+
+// AdvertiseServices announces the services (and their models) of this node
+// in the DHT. An unchanged set is only re-announced once per
+// dhtReprovideInterval unless force is set.
+func (n *Node) AdvertiseServices(force bool) {
+	if !dhtDiscoveryEnabled() || n.dht == nil || n.IsDraining() {
+		return
+	}
+	cids, names := serviceCIDs(n.registrar.Services())
+	if len(cids) == 0 {
+		return
+	}
+	sorted := append([]string{}, names...)
+	sort.Strings(sorted)
+	key := strings.Join(sorted, ",")
+
+	n.advertiseLock.Lock()
+	defer n.advertiseLock.Unlock()
+	if !force && key == n.advertisedKey && time.Since(n.advertisedTime) < dhtReprovideInterval {
+		return
+	}
+
+	parent := n.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(parent, time.Minute)
+	defer cancel()
+	provided := 0
+	for i, c := range cids {
+		if err := n.dht.Provide(ctx, c, true); err != nil {
+			common.Logger.Debugf("Failed to advertise %s in the DHT: %v", names[i], err)
+			continue
+		}
+		provided++
+	}
+	if provided == 0 {
+		common.Logger.Warn("Could not advertise any local service in the DHT")
+		return
+	}
+	n.advertisedKey = key
+	n.advertisedTime = time.Now()
+	common.Logger.Infof("Advertised %d/%d service keys in the DHT", provided, len(cids))
+}
+
+// advertise runs AdvertiseServices in the background. Close cancels it and
+// waits for it to return.
+func (n *Node) advertise(force bool) {
+	n.background.Add(1)
+	go func() {
+		defer n.background.Done()
+		n.AdvertiseServices(force)
+	}()
+}
+
+// FindProvidersInDHT looks up providers of a service in the DHT of the
+// default node.
+func FindProvidersInDHT(ctx context.Context, serviceName string, identityGroups []string) ([]Peer, error) {
+	return DefaultNode().FindProvidersInDHT(ctx, serviceName, identityGroups)
+}
+
+// FindProvidersInDHT looks up providers of a service in the DHT. It is the
+// fallback for when the node table has no candidates, e.g. while the CRDT is
+// still syncing. Identity groups are tried first, then the service itself.
+// Returned peers are connected and carry a synthesized service entry, so
+// they can be filtered like node table entries.
+func (n *Node) FindProvidersInDHT(ctx context.Context, serviceName string, identityGroups []string) ([]Peer, error) {
+	if !dhtDiscoveryEnabled() {
+		return nil, errors.New("DHT service discovery is disabled")
+	}
+	if n.dht == nil {
+		return nil, errors.New("DHT not initialized")
+	}
+	timeout := readDurationSetting("dht.lookup_timeout", defaultDHTLookupTimeout)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	host := n.host
+	lookups := append(append([]string{}, identityGroups...), "")
+	found := make(map[peer.ID]*Peer)
+	var order []peer.ID
+	for _, group := range lookups {
+		c, err := serviceCID(serviceName, group)
+		if err != nil {
+			return nil, err
+		}
+		for info := range n.dht.FindProvidersAsync(ctx, c, defaultDHTLookupCount) {
+			if info.ID == host.ID() {
+				continue
+			}
+			p, ok := found[info.ID]
+			if !ok {
+				record, allowed := n.dhtProviderAllowed(info.ID)
+				if !allowed {
+					continue
+				}
+				p = &Peer{
+					ID:            info.ID.String(),
+					Owner:         record.Owner,
+					OwnerVerified: record.OwnerVerified,
+					Service:       []Service{{Name: serviceName}},
+				}
+				found[info.ID] = p
+				order = append(order, info.ID)
+				host.Peerstore().AddAddrs(info.ID, info.Addrs, peerstore.TempAddrTTL)
+			}
+			if group != "" {
+				p.Service[0].IdentityGroup = append(p.Service[0].IdentityGroup, group)
+			}
+		}
+		if len(found) > 0 {
+			break
+		}
+	}
+
+	var providers []Peer
+	for _, id := range order {
+		connectCtx, cancelConnect := context.WithTimeout(ctx, dhtProviderConnectTimeout)
+		err := host.Connect(connectCtx, peer.AddrInfo{ID: id})
+		cancelConnect()
+		if err != nil {
+			common.Logger.With("peer", id).Debugf("DHT provider unreachable: %v", err)
+			continue
+		}
+		p := *found[id]
+		p.Connected = true
+		providers = append(providers, p)
+	}
+	if len(providers) == 0 {
+		return providers, errors.New("no providers found")
+	}
+	common.Logger.Infof("Found %d provider(s) of %s via the DHT", len(providers), serviceName)
+	return providers, nil
+}
+
+// dhtProviderAllowed applies the access policy to a provider found in the
+// DHT. Provider records carry no owner, so the owner is taken from the node
+// table. Without a table entry the owner is unknown, and the provider is only
+// accepted while no owner rules are set.
+func (n *Node) dhtProviderAllowed(id peer.ID) (Peer, bool) {
+	if !PeerAllowed(id.String()) {
+		return Peer{}, false
+	}
+	record, err := n.table.get(id.String())
+	if err != nil {
+		return Peer{ID: id.String()}, !getAccessPolicy().hasOwnerRules()
+	}
+	return record, PeerRecordAllowed(record)
+}
@@ -0,0 +1,68 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+func TestServiceCID(t *testing.T) {
+	a, err := serviceCID("llm", "model=llama3")
+	if err != nil {
+		t.Fatalf("serviceCID failed: %v", err)
+	}
+	b, _ := serviceCID("llm", "model=llama3")
+	if !a.Equals(b) {
+		t.Fatalf("expected service CIDs to be deterministic")
+	}
+	c, _ := serviceCID("llm", "")
+	if a.Equals(c) {
+		t.Fatalf("expected model CID to differ from service CID")
+	}
+}
+
+func TestServiceCIDs(t *testing.T) {
+	services := []Service{
+		{Name: "llm", IdentityGroup: []string{"model=a", "model=b"}},
+		{Name: "llm", IdentityGroup: []string{"model=a"}},
+	}
+	cids, names := serviceCIDs(services)
+	if len(cids) != 3 || len(names) != 3 {
+		t.Fatalf("expected 3 deduplicated keys, got %v", names)
+	}
+	if names[0] != "llm" || names[1] != "llm/model=a" || names[2] != "llm/model=b" {
+		t.Fatalf("unexpected keys: %v", names)
+	}
+}
+
+func TestDHTProviderOwnerRules(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	ap := getAccessPolicy()
+	t.Cleanup(func() { ap.set(AccessList{}) })
+	hb, _ := signedTestHeartbeat(t)
+	known, _ := peer.Decode(hb.PeerID)
+	hb, _ = signedTestHeartbeat(t)
+	unknown, _ := peer.Decode(hb.PeerID)
+	n := newNode(NodeConfig{})
+	n.table.peers["/"+known.String()] = Peer{ID: known.String(), Owner: "mallory", OwnerVerified: true}
+
+	if _, ok := n.dhtProviderAllowed(unknown); !ok {
+		t.Fatalf("expected a provider without a table entry to pass without owner rules")
+	}
+	if err := ap.set(AccessList{DenyOwners: []string{"mallory"}}); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	if _, ok := n.dhtProviderAllowed(known); ok {
+		t.Fatalf("expected a provider with a denied owner to be rejected")
+	}
+	if _, ok := n.dhtProviderAllowed(unknown); ok {
+		t.Fatalf("expected a provider of unknown owner to be rejected while owner rules are set")
+	}
+	if err := ap.set(AccessList{DenyOwners: []string{"alice"}}); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+	record, ok := n.dhtProviderAllowed(known)
+	if !ok || record.Owner != "mallory" {
+		t.Fatalf("expected the table owner to be carried over, got %+v", record)
+	}
+}
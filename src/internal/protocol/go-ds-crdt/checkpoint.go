@@ -0,0 +1,232 @@
+package crdt
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	dshelp "github.com/ipfs/boxo/datastore/dshelp"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	query "github.com/ipfs/go-datastore/query"
+)
+
+// ErrNoLocalBlocks is returned by PruneBelowCheckpoints when the DAG service
+// cannot tell local blocks from remote ones.
+var ErrNoLocalBlocks = errors.New("the DAG service cannot check for local blocks")
+
+// A LocalBlockChecker reports whether a block is stored locally without
+// fetching it from the network. ipfs-lite's Peer implements it. Pruning needs
+// a DAG service implementing it.
+type LocalBlockChecker interface {
+	HasBlock(ctx context.Context, c cid.Cid) (bool, error)
+}
+
+// Checkpoint is a block below which the DAG history may be truncated. Its
+// delta and everything below it have been merged into the set, so the
+// blocks themselves are no longer needed to compute the state.
+type Checkpoint struct {
+	Cid    cid.Cid
+	Height uint64
+}
+
+// CheckpointCandidate returns the block at or right below height on the
+// path that starts at the first head (in CID order) and follows the first
+// link of every block. Replicas that share the same history therefore pick
+// the same block for the same height.
+func (store *Datastore) CheckpointCandidate(ctx context.Context, height uint64) (Checkpoint, error) {
+	heads, maxHeight, err := store.heads.List(ctx)
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	if len(heads) == 0 {
+		return Checkpoint{}, errors.New("the store has no heads")
+	}
+	if height > maxHeight {
+		return Checkpoint{}, fmt.Errorf("height %d is above the current max height %d", height, maxHeight)
+	}
+
+	ng := &crdtNodeGetter{NodeGetter: store.dagService}
+	cur := heads[0]
+	for {
+		if err := ctx.Err(); err != nil {
+			return Checkpoint{}, err
+		}
+		// Nothing below an existing checkpoint may be present.
+		found, h, err := store.checkpointHeight(ctx, cur)
+		if err != nil {
+			return Checkpoint{}, err
+		}
+		if found && h <= height {
+			return Checkpoint{Cid: cur, Height: h}, nil
+		}
+		cctx, cancel := context.WithTimeout(ctx, store.opts.DAGSyncerTimeout)
+		nd, delta, err := ng.GetDelta(cctx, cur)
+		cancel()
+		if err != nil {
+			return Checkpoint{}, fmt.Errorf("error getting %s: %w", cur, err)
+		}
+		links := nd.Links()
+		if delta.GetPriority() <= height || len(links) == 0 {
+			return Checkpoint{Cid: cur, Height: delta.GetPriority()}, nil
+		}
+		cur = links[0].Cid
+	}
+}
+
+// AddCheckpoint records c as a checkpoint. It must have been processed
+// locally: a checkpoint promises that its history is merged in the set.
+func (store *Datastore) AddCheckpoint(ctx context.Context, c cid.Cid, height uint64) error {
+	processed, err := store.isProcessed(ctx, c)
+	if err != nil {
+		return err
+	}
+	if !processed {
+		return fmt.Errorf("%s has not been processed", c)
+	}
+	return store.writeCheckpoint(ctx, c, height)
+}
+
+func (store *Datastore) writeCheckpoint(ctx context.Context, c cid.Cid, height uint64) error {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, height)
+	return store.store.Put(ctx, store.checkpointKey(c), buf[:n])
+}
+
+func (store *Datastore) checkpointHeight(ctx context.Context, c cid.Cid) (bool, uint64, error) {
+	v, err := store.store.Get(ctx, store.checkpointKey(c))
+	if errors.Is(err, ds.ErrNotFound) {
+		return false, 0, nil
+	}
+	if err != nil {
+		return false, 0, err
+	}
+	height, n := binary.Uvarint(v)
+	if n <= 0 {
+		return false, 0, fmt.Errorf("malformed checkpoint height for %s", c)
+	}
+	return true, height, nil
+}
+
+// Checkpoints lists the recorded checkpoints, highest first.
+func (store *Datastore) Checkpoints(ctx context.Context) ([]Checkpoint, error) {
+	prefix := store.namespace.ChildString(checkpointsNs).String()
+	results, err := store.store.Query(ctx, query.Query{Prefix: prefix})
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	var out []Checkpoint
+	for r := range results.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		mhKey := strings.TrimPrefix(r.Key, prefix)
+		mh, err := dshelp.DsKeyToMultihash(ds.NewKey(mhKey))
+		if err != nil {
+			return nil, err
+		}
+		height, n := binary.Uvarint(r.Value)
+		if n <= 0 {
+			return nil, fmt.Errorf("malformed checkpoint height at %s", r.Key)
+		}
+		out = append(out, Checkpoint{Cid: cid.NewCidV1(cid.DagProtobuf, mh), Height: height})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Height > out[j].Height })
+	return out, nil
+}
+
+// prunedBlock reports whether c was processed but is no longer stored
+// locally, i.e. it was truncated by PruneBelowCheckpoints.
+func (store *Datastore) prunedBlock(ctx context.Context, c cid.Cid) bool {
+	checker, ok := store.dagService.(LocalBlockChecker)
+	if !ok {
+		return false
+	}
+	if processed, _ := store.isProcessed(ctx, c); !processed {
+		return false
+	}
+	has, err := checker.HasBlock(ctx, c)
+	return err == nil && !has
+}
+
+// PruneBelowCheckpoints removes every local block below the recorded
+// checkpoints from the DAG service and returns how many were removed. The
+// checkpoint blocks themselves are kept, as are the processed-block records,
+// so that late branches reaching into the pruned history stop there.
+//
+// Once history is pruned, replicas cannot sync the full DAG from this one
+// anymore and need to start from a snapshot (see ExportSnapshot).
+func (store *Datastore) PruneBelowCheckpoints(ctx context.Context) (int, error) {
+	checker, ok := store.dagService.(LocalBlockChecker)
+	if !ok {
+		return 0, ErrNoLocalBlocks
+	}
+	if store.IsDirty(ctx) {
+		return 0, errors.New("the store is dirty; repair it before pruning")
+	}
+	checkpoints, err := store.Checkpoints(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	ng := &crdtNodeGetter{NodeGetter: store.dagService}
+	visited := cid.NewSet()
+	var queue []cid.Cid
+	for _, cp := range checkpoints {
+		visited.Add(cp.Cid)
+		has, err := checker.HasBlock(ctx, cp.Cid)
+		if err != nil {
+			return 0, err
+		}
+		if !has {
+			continue
+		}
+		nd, err := ng.Get(ctx, cp.Cid)
+		if err != nil {
+			return 0, err
+		}
+		for _, l := range nd.Links() {
+			if visited.Visit(l.Cid) {
+				queue = append(queue, l.Cid)
+			}
+		}
+	}
+
+	removed := 0
+	for len(queue) > 0 {
+		if err := ctx.Err(); err != nil {
+			return removed, err
+		}
+		c := queue[0]
+		queue = queue[1:]
+		has, err := checker.HasBlock(ctx, c)
+		if err != nil {
+			return removed, err
+		}
+		if !has {
+			continue
+		}
+		nd, err := ng.Get(ctx, c)
+		if err != nil {
+			return removed, fmt.Errorf("error getting %s: %w", c, err)
+		}
+		for _, l := range nd.Links() {
+			if visited.Visit(l.Cid) {
+				queue = append(queue, l.Cid)
+			}
+		}
+		if err := store.dagService.Remove(ctx, c); err != nil {
+			return removed, fmt.Errorf("error removing %s: %w", c, err)
+		}
+		removed++
+	}
+	if removed > 0 {
+		store.logger.Infof("pruned %d DAG blocks below %d checkpoint(s)", removed, len(checkpoints))
+	}
+	return removed, nil
+}
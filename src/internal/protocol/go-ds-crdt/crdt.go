@@ -24,6 +24,7 @@ import (
 	"io"
 	"math/rand"
 	"ocf/internal/common"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -48,6 +49,7 @@ const (
 	headsNs           = "h" // heads
 	setNs             = "s" // set
 	processedBlocksNs = "b" // blocks
+	checkpointsNs     = "c" // checkpoints
 	dirtyBitKey       = "d" // dirty
 	versionKey        = "crdt_version"
 )
@@ -109,6 +111,11 @@ type Options struct {
 	// branching is not necessarily a bad thing and may improve
 	// throughput, but everything depends on usage.
 	MultiHeadProcessing bool
+	// SnapshotSource, when set, is asked for a snapshot (as written by
+	// ExportSnapshot) when New opens a store without heads. The snapshot
+	// is imported before any broadcast is processed. Returning an error
+	// falls back to syncing the full DAG.
+	SnapshotSource func(ctx context.Context) (io.ReadCloser, error)
 }
 
 func (opts *Options) verify() error {
@@ -294,6 +301,10 @@ func New(
 		return nil, err
 	}
 
+	if n, _ := dstore.heads.Len(ctx); n == 0 && opts.SnapshotSource != nil {
+		dstore.importInitialSnapshot(ctx)
+	}
+
 	headList, maxHeight, err := dstore.heads.List(ctx)
 	if err != nil {
 		cancel()
@@ -430,6 +441,13 @@ func (store *Datastore) handleNext(ctx context.Context) {
 }
 
 func (store *Datastore) decodeBroadcast(ctx context.Context, data []byte) ([]cid.Cid, error) {
+	return DecodeBroadcast(data)
+}
+
+// DecodeBroadcast returns the heads announced by a payload received from a
+// Broadcaster. Transports can use it to drop malformed payloads before they
+// reach the Datastore.
+func DecodeBroadcast(data []byte) ([]cid.Cid, error) {
 	// Make a list of heads we received
 	bcastData := pb.CRDTBroadcast{}
 	err := proto.Unmarshal(data, &bcastData)
@@ -445,7 +463,6 @@ func (store *Datastore) decodeBroadcast(ctx context.Context, data []byte) ([]cid
 		if err != nil {
 			return nil, err
 		}
-		store.logger.Debugf("a legacy CID broadcast was received for: %s", c)
 		return []cid.Cid{c}, nil
 	}
 
@@ -964,6 +981,18 @@ func (store *Datastore) repairDAG(ctx context.Context) error {
 		cur := nh.node
 		head := nh.head
 
+		// Snapshot heads were merged as a whole on import and the
+		// DAG below them was never fetched. Pruned blocks are gone
+		// too; neither can be walked.
+		isCheckpoint, err := store.isCheckpoint(ctx, cur)
+		if err != nil {
+			return fmt.Errorf("error checking for checkpoint %s: %w", cur, err)
+		}
+		if isCheckpoint || store.prunedBlock(ctx, cur) {
+			atomic.AddUint64(&visitedNodes, 1)
+			continue
+		}
+
 		cctx, cancel := context.WithTimeout(ctx, store.opts.DAGSyncerTimeout)
 		n, delta, err := getter.GetDelta(cctx, cur)
 		if err != nil {
@@ -1432,6 +1461,13 @@ func (store *Datastore) printDAGRec(ctx context.Context, from cid.Cid, depth uin
 // writer. It can be converted to image format and visualized with graphviz
 // tooling.
 func (store *Datastore) DotDAG(ctx context.Context, w io.Writer) error {
+	return store.DotDAGDepth(ctx, w, 0)
+}
+
+// DotDAGDepth is DotDAG limited to maxDepth levels below the heads, or the
+// whole DAG when maxDepth is 0. Blocks truncated by PruneBelowCheckpoints
+// end the walk instead of failing it.
+func (store *Datastore) DotDAGDepth(ctx context.Context, w io.Writer, maxDepth uint64) error {
 	heads, _, err := store.heads.List(ctx)
 	if err != nil {
 		return err
@@ -1450,7 +1486,7 @@ func (store *Datastore) DotDAG(ctx context.Context, w io.Writer) error {
 	fmt.Fprintln(w, "}")
 
 	for _, h := range heads {
-		err := store.dotDAGRec(ctx, w, h, 0, ng, set)
+		err := store.dotDAGRec(ctx, w, h, 0, maxDepth, ng, set)
 		if err != nil {
 			return err
 		}
@@ -1459,7 +1495,7 @@ func (store *Datastore) DotDAG(ctx context.Context, w io.Writer) error {
 	return nil
 }
 
-func (store *Datastore) dotDAGRec(ctx context.Context, w io.Writer, from cid.Cid, depth uint64, ng *crdtNodeGetter, set *cid.Set) error {
+func (store *Datastore) dotDAGRec(ctx context.Context, w io.Writer, from cid.Cid, depth, maxDepth uint64, ng *crdtNodeGetter, set *cid.Set) error {
 	cidLong := from.String()
 	cidShort := cidLong[len(cidLong)-4:]
 
@@ -1467,6 +1503,10 @@ func (store *Datastore) dotDAGRec(ctx context.Context, w io.Writer, from cid.Cid
 	if !ok {
 		return nil
 	}
+	if store.prunedBlock(ctx, from) {
+		fmt.Fprintf(w, "%s [label=\"%s: pruned\"]\n", cidLong, cidShort)
+		return nil
+	}
 
 	cctx, cancel := context.WithTimeout(ctx, store.opts.DAGSyncerTimeout)
 	defer cancel()
@@ -1494,14 +1534,46 @@ func (store *Datastore) dotDAGRec(ctx context.Context, w io.Writer, from cid.Cid
 	}
 	fmt.Fprintln(w, "}")
 
+	if maxDepth > 0 && depth+1 >= maxDepth {
+		return nil
+	}
 	for _, l := range nd.Links() {
-		if err := store.dotDAGRec(ctx, w, l.Cid, depth+1, ng, set); err != nil {
+		if err := store.dotDAGRec(ctx, w, l.Cid, depth+1, maxDepth, ng, set); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// Head is one of the current DAG heads.
+type Head struct {
+	Cid    cid.Cid
+	Height uint64
+}
+
+// Heads returns the current heads, highest first.
+func (store *Datastore) Heads(ctx context.Context) []Head {
+	store.heads.cacheMux.RLock()
+	out := make([]Head, 0, len(store.heads.cache))
+	for c, height := range store.heads.cache {
+		out = append(out, Head{Cid: c, Height: height})
+	}
+	store.heads.cacheMux.RUnlock()
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Height != out[j].Height {
+			return out[i].Height > out[j].Height
+		}
+		return out[i].Cid.KeyString() < out[j].Cid.KeyString()
+	})
+	return out
+}
+
+// PendingBlocks returns the blocks that have been queued for fetching and
+// processing but are not processed yet.
+func (store *Datastore) PendingBlocks() []cid.Cid {
+	return store.queuedChildren.List()
+}
+
 // Stats wraps internal information about the datastore.
 // Might be expanded in the future.
 type Stats struct {
@@ -1554,6 +1626,16 @@ func (s *cidSafeSet) Remove(c cid.Cid) {
 	s.mux.Unlock()
 }
 
+func (s *cidSafeSet) List() []cid.Cid {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	out := make([]cid.Cid, 0, len(s.set))
+	for c := range s.set {
+		out = append(out, c)
+	}
+	return out
+}
+
 func (s *cidSafeSet) Has(c cid.Cid) (ok bool) {
 	s.mux.RLock()
 	{
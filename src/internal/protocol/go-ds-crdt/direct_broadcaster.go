@@ -0,0 +1,192 @@
+package crdt
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"go.uber.org/multierr"
+)
+
+// DirectBroadcastProtocol is the default protocol DirectBroadcaster speaks.
+const DirectBroadcastProtocol = protocol.ID("/crdt/direct-broadcast/1.0.0")
+
+const (
+	// MaxDirectBroadcastSize bounds a single payload. Broadcasts only carry
+	// head CIDs, so this is generous.
+	MaxDirectBroadcastSize = 1 << 20
+	directSendTimeout      = 10 * time.Second
+	directQueueSize        = 128
+)
+
+var _ Broadcaster = (*DirectBroadcaster)(nil)
+
+// DirectBroadcaster implements a Broadcaster that pushes every payload to a
+// fixed set of peers over a libp2p stream protocol, instead of gossiping it.
+// Payloads are not relayed: every replica must list every replica it should
+// reach, so it suits small, fully known deployments. Payloads are only
+// accepted from the same set of peers.
+type DirectBroadcaster struct {
+	ctx   context.Context
+	h     host.Host
+	proto protocol.ID
+
+	mu    sync.RWMutex
+	peers map[peer.ID]struct{}
+
+	in chan []byte
+}
+
+// NewDirectBroadcaster registers a handler for proto on h and returns a
+// broadcaster sending to peers. The handler is removed when ctx is
+// cancelled, after which Next returns ErrNoMoreBroadcast.
+func NewDirectBroadcaster(ctx context.Context, h host.Host, proto protocol.ID, peers []peer.ID) (*DirectBroadcaster, error) {
+	if proto == "" {
+		proto = DirectBroadcastProtocol
+	}
+	dbc := &DirectBroadcaster{
+		ctx:   ctx,
+		h:     h,
+		proto: proto,
+		in:    make(chan []byte, directQueueSize),
+	}
+	dbc.SetPeers(peers)
+	h.SetStreamHandler(proto, dbc.handleStream)
+	go func() {
+		<-ctx.Done()
+		h.RemoveStreamHandler(proto)
+	}()
+	return dbc, nil
+}
+
+// SetPeers replaces the set of peers payloads are exchanged with.
+func (dbc *DirectBroadcaster) SetPeers(peers []peer.ID) {
+	set := make(map[peer.ID]struct{}, len(peers))
+	for _, p := range peers {
+		if p != dbc.h.ID() {
+			set[p] = struct{}{}
+		}
+	}
+	dbc.mu.Lock()
+	dbc.peers = set
+	dbc.mu.Unlock()
+}
+
+// Peers returns the peers payloads are exchanged with.
+func (dbc *DirectBroadcaster) Peers() []peer.ID {
+	dbc.mu.RLock()
+	defer dbc.mu.RUnlock()
+	out := make([]peer.ID, 0, len(dbc.peers))
+	for p := range dbc.peers {
+		out = append(out, p)
+	}
+	return out
+}
+
+func (dbc *DirectBroadcaster) isPeer(p peer.ID) bool {
+	dbc.mu.RLock()
+	defer dbc.mu.RUnlock()
+	_, ok := dbc.peers[p]
+	return ok
+}
+
+// Broadcast sends data to every peer in parallel. It only fails when no
+// peer could be reached; the others catch up with the next broadcast.
+func (dbc *DirectBroadcaster) Broadcast(ctx context.Context, data []byte) error {
+	if len(data) > MaxDirectBroadcastSize {
+		return fmt.Errorf("payload of %d bytes exceeds the direct broadcast limit", len(data))
+	}
+	peers := dbc.Peers()
+	if len(peers) == 0 {
+		return nil
+	}
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs error
+	)
+	for _, p := range peers {
+		wg.Add(1)
+		go func(p peer.ID) {
+			defer wg.Done()
+			if err := dbc.send(ctx, p, data); err != nil {
+				mu.Lock()
+				errs = multierr.Append(errs, fmt.Errorf("%s: %w", p, err))
+				mu.Unlock()
+			}
+		}(p)
+	}
+	wg.Wait()
+	if len(multierr.Errors(errs)) == len(peers) {
+		return errs
+	}
+	return nil
+}
+
+func (dbc *DirectBroadcaster) send(ctx context.Context, p peer.ID, data []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, directSendTimeout)
+	defer cancel()
+	s, err := dbc.h.NewStream(ctx, p, dbc.proto)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = s.SetWriteDeadline(deadline)
+	}
+	var lenBuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenBuf[:], uint64(len(data)))
+	if _, err := s.Write(lenBuf[:n]); err != nil {
+		_ = s.Reset()
+		return err
+	}
+	if _, err := s.Write(data); err != nil {
+		_ = s.Reset()
+		return err
+	}
+	return s.CloseWrite()
+}
+
+func (dbc *DirectBroadcaster) handleStream(s network.Stream) {
+	defer s.Close()
+	if !dbc.isPeer(s.Conn().RemotePeer()) {
+		_ = s.Reset()
+		return
+	}
+	_ = s.SetReadDeadline(time.Now().Add(directSendTimeout))
+	r := bufio.NewReader(s)
+	size, err := binary.ReadUvarint(r)
+	if err != nil || size > MaxDirectBroadcastSize {
+		_ = s.Reset()
+		return
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		_ = s.Reset()
+		return
+	}
+	select {
+	case dbc.in <- data:
+	case <-dbc.ctx.Done():
+	}
+}
+
+// Next returns the next payload received from one of the peers.
+func (dbc *DirectBroadcaster) Next(ctx context.Context) ([]byte, error) {
+	select {
+	case <-dbc.ctx.Done():
+		return nil, ErrNoMoreBroadcast
+	case <-ctx.Done():
+		return nil, ErrNoMoreBroadcast
+	case data := <-dbc.in:
+		return data, nil
+	}
+}
@@ -0,0 +1,142 @@
+package crdt
+
+import (
+	"context"
+	"sync"
+)
+
+var _ Broadcaster = (*MemoryBroadcaster)(nil)
+
+// MemoryHub connects MemoryBroadcasters living in the same process, which
+// makes it possible to run several replicas without any networking, e.g.
+// in tests.
+type MemoryHub struct {
+	mu      sync.Mutex
+	members []*MemoryBroadcaster
+}
+
+// NewMemoryHub returns an empty hub.
+func NewMemoryHub() *MemoryHub {
+	return &MemoryHub{}
+}
+
+// Join returns a new broadcaster attached to the hub. Data broadcast by a
+// member is delivered to every other member, in the order it was sent.
+// The broadcaster leaves the hub when ctx is cancelled.
+func (hub *MemoryHub) Join(ctx context.Context) *MemoryBroadcaster {
+	mbc := &MemoryBroadcaster{
+		ctx:    ctx,
+		hub:    hub,
+		notify: make(chan struct{}, 1),
+	}
+	hub.mu.Lock()
+	hub.members = append(hub.members, mbc)
+	hub.mu.Unlock()
+	go func() {
+		<-ctx.Done()
+		hub.leave(mbc)
+	}()
+	return mbc
+}
+
+func (hub *MemoryHub) leave(mbc *MemoryBroadcaster) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	for i, m := range hub.members {
+		if m == mbc {
+			hub.members = append(hub.members[:i], hub.members[i+1:]...)
+			return
+		}
+	}
+}
+
+// MemoryBroadcaster is a Broadcaster delivering through a MemoryHub.
+type MemoryBroadcaster struct {
+	ctx context.Context
+	hub *MemoryHub
+
+	mu          sync.Mutex
+	queue       [][]byte
+	notify      chan struct{}
+	partitioned bool
+}
+
+// Broadcast queues data for every other member of the hub. It never blocks
+// on slow receivers.
+func (mbc *MemoryBroadcaster) Broadcast(ctx context.Context, data []byte) error {
+	if mbc.ctx.Err() != nil {
+		return ErrNoMoreBroadcast
+	}
+	mbc.mu.Lock()
+	partitioned := mbc.partitioned
+	mbc.mu.Unlock()
+	if partitioned {
+		return nil
+	}
+	mbc.hub.mu.Lock()
+	members := make([]*MemoryBroadcaster, len(mbc.hub.members))
+	copy(members, mbc.hub.members)
+	mbc.hub.mu.Unlock()
+	for _, m := range members {
+		if m != mbc {
+			m.deliver(data)
+		}
+	}
+	return nil
+}
+
+func (mbc *MemoryBroadcaster) deliver(data []byte) {
+	mbc.mu.Lock()
+	if mbc.partitioned {
+		mbc.mu.Unlock()
+		return
+	}
+	msg := make([]byte, len(data))
+	copy(msg, data)
+	mbc.queue = append(mbc.queue, msg)
+	mbc.mu.Unlock()
+	select {
+	case mbc.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Next returns the next payload broadcast by another member.
+func (mbc *MemoryBroadcaster) Next(ctx context.Context) ([]byte, error) {
+	for {
+		mbc.mu.Lock()
+		if len(mbc.queue) > 0 {
+			data := mbc.queue[0]
+			mbc.queue = mbc.queue[1:]
+			mbc.mu.Unlock()
+			return data, nil
+		}
+		mbc.mu.Unlock()
+
+		select {
+		case <-mbc.ctx.Done():
+			return nil, ErrNoMoreBroadcast
+		case <-ctx.Done():
+			return nil, ErrNoMoreBroadcast
+		case <-mbc.notify:
+		}
+	}
+}
+
+// SetPartitioned drops everything broadcast to or by this member while
+// true, simulating a replica that is cut off from the others.
+func (mbc *MemoryBroadcaster) SetPartitioned(partitioned bool) {
+	mbc.mu.Lock()
+	defer mbc.mu.Unlock()
+	mbc.partitioned = partitioned
+	if partitioned {
+		mbc.queue = nil
+	}
+}
+
+// Pending returns the number of payloads waiting to be read by Next.
+func (mbc *MemoryBroadcaster) Pending() int {
+	mbc.mu.Lock()
+	defer mbc.mu.Unlock()
+	return len(mbc.queue)
+}
@@ -0,0 +1,226 @@
+package crdt
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	dshelp "github.com/ipfs/boxo/datastore/dshelp"
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	query "github.com/ipfs/go-datastore/query"
+)
+
+// SnapshotVersion is the snapshot format written by ExportSnapshot.
+const SnapshotVersion = 1
+
+// ErrSnapshotNotEmpty is returned when importing a snapshot into a store
+// that has already synced something.
+var ErrSnapshotNotEmpty = errors.New("cannot import a snapshot into a store that already has heads")
+
+// SnapshotHead is one of the heads a snapshot was taken at.
+type SnapshotHead struct {
+	Cid    string `json:"cid"`
+	Height uint64 `json:"height"`
+}
+
+// SnapshotInfo describes a snapshot. It is written as the snapshot header.
+type SnapshotInfo struct {
+	Version   int            `json:"version"`
+	CreatedAt int64          `json:"created_at"`
+	MaxHeight uint64         `json:"max_height"`
+	Heads     []SnapshotHead `json:"heads"`
+	// Records is only known once the snapshot has been fully read or
+	// written, so it is not part of the header.
+	Records int `json:"records,omitempty"`
+}
+
+type snapshotRecord struct {
+	Key   string `json:"k"`
+	Value []byte `json:"v,omitempty"`
+}
+
+// ExportSnapshot writes the current state of the set (values, priorities,
+// elements and tombstones) together with the current heads to w, as a
+// gzipped stream of JSON documents. A store importing it can skip walking the
+// DAG below those heads and only needs to sync newer deltas.
+//
+// Heads are read before the set, so the exported state may contain deltas
+// newer than the heads but never misses one below them. Merging such deltas
+// again on the importing side is harmless.
+func (store *Datastore) ExportSnapshot(ctx context.Context, w io.Writer) (SnapshotInfo, error) {
+	info := SnapshotInfo{
+		Version:   SnapshotVersion,
+		CreatedAt: time.Now().Unix(),
+	}
+	store.heads.cacheMux.RLock()
+	for c, height := range store.heads.cache {
+		info.Heads = append(info.Heads, SnapshotHead{Cid: c.String(), Height: height})
+		if height > info.MaxHeight {
+			info.MaxHeight = height
+		}
+	}
+	store.heads.cacheMux.RUnlock()
+	if len(info.Heads) == 0 {
+		return info, errors.New("nothing to export: the store has no heads")
+	}
+
+	gz := gzip.NewWriter(w)
+	enc := json.NewEncoder(gz)
+	if err := enc.Encode(info); err != nil {
+		return info, err
+	}
+
+	prefix := store.set.namespace.String()
+	results, err := store.store.Query(ctx, query.Query{Prefix: prefix})
+	if err != nil {
+		return info, err
+	}
+	defer results.Close()
+	for r := range results.Next() {
+		if r.Error != nil {
+			return info, r.Error
+		}
+		rec := snapshotRecord{Key: strings.TrimPrefix(r.Key, prefix), Value: r.Value}
+		if err := enc.Encode(rec); err != nil {
+			return info, err
+		}
+		info.Records++
+	}
+	if err := gz.Close(); err != nil {
+		return info, err
+	}
+	return info, nil
+}
+
+// ImportSnapshot loads a snapshot produced by ExportSnapshot into an empty
+// store. The snapshot heads become the current heads and checkpoints: they
+// are marked as processed so that syncing stops there, and Repair does not
+// walk below them. The PutHook is called for every imported value.
+func (store *Datastore) ImportSnapshot(ctx context.Context, r io.Reader) (SnapshotInfo, error) {
+	var info SnapshotInfo
+	if n, _ := store.heads.Len(ctx); n > 0 {
+		return info, ErrSnapshotNotEmpty
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return info, fmt.Errorf("error reading snapshot: %w", err)
+	}
+	defer gz.Close()
+	dec := json.NewDecoder(gz)
+	if err := dec.Decode(&info); err != nil {
+		return info, fmt.Errorf("error reading snapshot header: %w", err)
+	}
+	if info.Version != SnapshotVersion {
+		return info, fmt.Errorf("unsupported snapshot version %d", info.Version)
+	}
+	if len(info.Heads) == 0 {
+		return info, errors.New("snapshot has no heads")
+	}
+	heads := make(map[cid.Cid]uint64, len(info.Heads))
+	for _, h := range info.Heads {
+		c, err := cid.Decode(h.Cid)
+		if err != nil {
+			return info, fmt.Errorf("invalid snapshot head %q: %w", h.Cid, err)
+		}
+		heads[c] = h.Height
+	}
+
+	var write ds.Write = store.store
+	var batch ds.Batch
+	if batchingDs, ok := store.store.(ds.Batching); ok {
+		batch, err = batchingDs.Batch(ctx)
+		if err != nil {
+			return info, err
+		}
+		write = batch
+	}
+
+	// key -> value of every live element, for the hooks.
+	values := make(map[string][]byte)
+	valuePrefix := "/" + keysNs + "/"
+	valueSuffixKey := "/" + valueSuffix
+	info.Records = 0
+	for {
+		var rec snapshotRecord
+		err := dec.Decode(&rec)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return info, fmt.Errorf("error reading snapshot record: %w", err)
+		}
+		if !strings.HasPrefix(rec.Key, "/") {
+			return info, fmt.Errorf("invalid snapshot record key %q", rec.Key)
+		}
+		if err := write.Put(ctx, store.set.namespace.Child(ds.RawKey(rec.Key)), rec.Value); err != nil {
+			return info, err
+		}
+		if strings.HasPrefix(rec.Key, valuePrefix) && strings.HasSuffix(rec.Key, valueSuffixKey) {
+			key := strings.TrimSuffix(strings.TrimPrefix(rec.Key, valuePrefix[:len(valuePrefix)-1]), valueSuffixKey)
+			values[key] = rec.Value
+		}
+		info.Records++
+	}
+
+	for c, height := range heads {
+		if err := write.Put(ctx, store.processedBlockKey(c), nil); err != nil {
+			return info, err
+		}
+		buf := make([]byte, binary.MaxVarintLen64)
+		n := binary.PutUvarint(buf, height)
+		if err := write.Put(ctx, store.checkpointKey(c), buf[:n]); err != nil {
+			return info, err
+		}
+	}
+	if batch != nil {
+		if err := batch.Commit(ctx); err != nil {
+			return info, err
+		}
+	}
+	for c, height := range heads {
+		if err := store.heads.Add(ctx, c, height); err != nil {
+			return info, err
+		}
+	}
+
+	for key, value := range values {
+		store.set.putHook(key, value)
+	}
+	store.logger.Infof(
+		"imported snapshot with %d heads (max height %d) and %d records",
+		len(heads), info.MaxHeight, info.Records,
+	)
+	return info, nil
+}
+
+func (store *Datastore) checkpointKey(c cid.Cid) ds.Key {
+	return store.namespace.ChildString(checkpointsNs).ChildString(dshelp.MultihashToDsKey(c.Hash()).String())
+}
+
+// isCheckpoint returns whether c is the head of an imported snapshot. Blocks
+// below a checkpoint may not be available locally.
+func (store *Datastore) isCheckpoint(ctx context.Context, c cid.Cid) (bool, error) {
+	return store.store.Has(ctx, store.checkpointKey(c))
+}
+
+// importInitialSnapshot imports the snapshot offered by opts.SnapshotSource.
+// Failures are logged only: the store then syncs from scratch.
+func (store *Datastore) importInitialSnapshot(ctx context.Context) {
+	rc, err := store.opts.SnapshotSource(ctx)
+	if err != nil {
+		store.logger.Warnf("no snapshot to start from, syncing the full DAG: %s", err)
+		return
+	}
+	defer rc.Close()
+	if _, err := store.ImportSnapshot(ctx, rc); err != nil {
+		store.logger.Errorf("error importing snapshot, syncing the full DAG: %s", err)
+	}
+}
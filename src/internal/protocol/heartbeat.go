@@ -0,0 +1,360 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ocf/internal/common"
+	"sort"
+	"sync"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	heartbeatTopicName       = "ocf-heartbeat"
+	heartbeatDomain          = "ocf-heartbeat/v1|"
+	defaultHeartbeatInterval = 20 * time.Second
+	// heartbeatMaxSkew bounds how far a heartbeat timestamp may be from our
+	// clock before it is rejected as stale or forged.
+	heartbeatMaxSkew = 2 * time.Minute
+	maxHeartbeatSize = 2048
+)
+
+// Heartbeat is a small signed liveness message. It is gossiped on its own
+// topic and only updates local state: unlike the node table record, it is
+// never written to the CRDT.
+type Heartbeat struct {
+	PeerID    string `json:"peer_id"`
+	Seq       uint64 `json:"seq"`
+	Timestamp int64  `json:"timestamp"` // unix milliseconds
+	Status    string `json:"status,omitempty"`
+	InFlight  int64  `json:"in_flight"`
+	Load      []int  `json:"load,omitempty"`
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// PeerLiveness is the latest heartbeat received from a peer.
+type PeerLiveness struct {
+	PeerID     string `json:"peer_id"`
+	Status     string `json:"status,omitempty"`
+	InFlight   int64  `json:"in_flight"`
+	Load       []int  `json:"load,omitempty"`
+	Seq        uint64 `json:"seq"`
+	ReceivedAt int64  `json:"received_at"`
+}
+
+type livenessBook struct {
+	mu    sync.RWMutex
+	peers map[string]PeerLiveness
+}
+
+func newLivenessBook() *livenessBook {
+	return &livenessBook{peers: make(map[string]PeerLiveness)}
+}
+
+// SetInFlightReporter sets the function whose value the heartbeats of the
+// default node carry as the number of in-flight requests.
+func SetInFlightReporter(f func() int64) {
+	std.SetInFlightReporter(f)
+}
+
+// SetInFlightReporter sets the function whose value heartbeats carry as the
+// number of in-flight requests.
+func (n *Node) SetInFlightReporter(f func() int64) {
+	if f == nil {
+		n.inFlight.Store(nil)
+		return
+	}
+	n.inFlight.Store(&f)
+}
+
+func heartbeatPayload(hb Heartbeat) ([]byte, error) {
+	hb.Signature = nil
+	b, err := json.Marshal(hb)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(heartbeatDomain), b...), nil
+}
+
+func signHeartbeat(hb *Heartbeat, priv crypto.PrivKey) error {
+	payload, err := heartbeatPayload(*hb)
+	if err != nil {
+		return err
+	}
+	hb.Signature, err = priv.Sign(payload)
+	return err
+}
+
+var errHeartbeatSignature = errors.New("invalid signature")
+
+// verifyHeartbeat checks that hb was signed by the key of its peer ID and
+// that it is recent. pubKey may be nil for ed25519 identities.
+func verifyHeartbeat(hb Heartbeat, pubKey crypto.PubKey, now time.Time) error {
+	pid, err := peer.Decode(hb.PeerID)
+	if err != nil {
+		return fmt.Errorf("invalid peer ID: %w", err)
+	}
+	if pubKey == nil {
+		pubKey, err = pid.ExtractPublicKey()
+		if err != nil {
+			return fmt.Errorf("peer public key unknown: %w", err)
+		}
+	}
+	if !pid.MatchesPublicKey(pubKey) {
+		return errors.New("public key does not match peer ID")
+	}
+	payload, err := heartbeatPayload(hb)
+	if err != nil {
+		return err
+	}
+	ok, err := pubKey.Verify(payload, hb.Signature)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errHeartbeatSignature
+	}
+	sent := time.UnixMilli(hb.Timestamp)
+	if sent.Before(now.Add(-heartbeatMaxSkew)) || sent.After(now.Add(heartbeatMaxSkew)) {
+		return fmt.Errorf("timestamp %s is out of range", sent.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// newHeartbeat builds our next heartbeat.
+func (n *Node) newHeartbeat() (Heartbeat, error) {
+	h := n.host
+	self := n.registrar.Self()
+	hb := Heartbeat{
+		PeerID:    h.ID().String(),
+		Seq:       n.heartbeatSeq.Add(1),
+		Timestamp: time.Now().UnixMilli(),
+		Status:    self.Status,
+		Load:      self.Load,
+	}
+	if f := n.inFlight.Load(); f != nil {
+		hb.InFlight = (*f)()
+	}
+	priv := h.Peerstore().PrivKey(h.ID())
+	if priv == nil {
+		return hb, errors.New("host private key not available")
+	}
+	return hb, signHeartbeat(&hb, priv)
+}
+
+func (n *Node) publishHeartbeat(ctx context.Context) error {
+	topic := n.heartbeatTopic.Load()
+	if topic == nil {
+		return nil
+	}
+	msg, err := n.newHeartbeat()
+	if err != nil {
+		return fmt.Errorf("cannot sign heartbeat: %w", err)
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return topic.Publish(ctx, data)
+}
+
+// PublishHeartbeat sends a heartbeat of the default node right away.
+func PublishHeartbeat() {
+	std.PublishHeartbeat()
+}
+
+// PublishHeartbeat sends a heartbeat right away, e.g. after a new
+// connection, instead of waiting for the next interval. It does nothing
+// unless heartbeats are enabled.
+func (n *Node) PublishHeartbeat() {
+	if err := n.publishHeartbeat(context.Background()); err != nil {
+		common.Logger.Debug("Error while publishing heartbeat: ", err)
+	}
+}
+
+// validateHeartbeat checks a heartbeat published by from, without recording
+// it. It returns an error when the heartbeat must be dropped.
+func (n *Node) validateHeartbeat(data []byte, from peer.ID) (Heartbeat, error) {
+	h := n.host
+	var hb Heartbeat
+	if len(data) > maxHeartbeatSize {
+		return hb, errors.New("heartbeat too large")
+	}
+	if err := json.Unmarshal(data, &hb); err != nil {
+		return hb, err
+	}
+	if hb.PeerID != from.String() {
+		return hb, fmt.Errorf("heartbeat for %s published by %s", hb.PeerID, from)
+	}
+	if hb.PeerID == h.ID().String() {
+		return hb, nil
+	}
+	if err := verifyHeartbeat(hb, h.Peerstore().PubKey(from), time.Now()); err != nil {
+		return hb, err
+	}
+	if !PeerAllowed(hb.PeerID) {
+		return hb, errors.New("peer denied by access policy")
+	}
+	if !n.liveness.fresh(hb) {
+		return hb, errors.New("replayed heartbeat")
+	}
+	return hb, nil
+}
+
+// applyHeartbeat records a validated heartbeat and marks its peer alive in
+// the node table.
+func (n *Node) applyHeartbeat(hb Heartbeat) {
+	if hb.PeerID == n.host.ID().String() || !n.liveness.record(hb) {
+		return
+	}
+	RecordSignatureResult(hb.PeerID, true)
+	created := n.table.refresh(hb.PeerID, func(p *Peer) {
+		p.Connected = true
+		p.LastSeen = time.Now().Unix()
+		if hb.Status != "" {
+			p.Status = hb.Status
+		}
+		if hb.Load != nil {
+			p.Load = hb.Load
+		}
+	})
+	if created {
+		common.Logger.Infof("Adding peer: [%s] triggered by heartbeat", hb.PeerID)
+	}
+}
+
+// newer reports whether hb follows prev, the last heartbeat of its peer.
+func newer(prev PeerLiveness, hb Heartbeat) bool {
+	// A restarted peer counts from 1 again; accept once the previous
+	// sequence is clearly outdated.
+	return hb.Seq > prev.Seq || time.Since(time.Unix(prev.ReceivedAt, 0)) >= heartbeatMaxSkew
+}
+
+// fresh reports whether record would accept hb.
+func (b *livenessBook) fresh(hb Heartbeat) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	prev, ok := b.peers[hb.PeerID]
+	return !ok || newer(prev, hb)
+}
+
+// record stores hb unless an equal or newer sequence number was seen.
+func (b *livenessBook) record(hb Heartbeat) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if prev, ok := b.peers[hb.PeerID]; ok && !newer(prev, hb) {
+		return false
+	}
+	b.peers[hb.PeerID] = PeerLiveness{
+		PeerID:     hb.PeerID,
+		Status:     hb.Status,
+		InFlight:   hb.InFlight,
+		Load:       hb.Load,
+		Seq:        hb.Seq,
+		ReceivedAt: time.Now().Unix(),
+	}
+	return true
+}
+
+// GetLiveness returns the latest heartbeat of every peer known to the
+// default node.
+func GetLiveness() []PeerLiveness {
+	return std.GetLiveness()
+}
+
+// GetLiveness returns the latest heartbeat of every peer, most recent first.
+func (n *Node) GetLiveness() []PeerLiveness {
+	n.liveness.mu.RLock()
+	out := make([]PeerLiveness, 0, len(n.liveness.peers))
+	for _, l := range n.liveness.peers {
+		out = append(out, l)
+	}
+	n.liveness.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].ReceivedAt > out[j].ReceivedAt })
+	return out
+}
+
+// PeerInFlight returns the number of in-flight requests the peer last
+// reported to the default node.
+func PeerInFlight(peerID string) (int64, bool) {
+	return std.PeerInFlight(peerID)
+}
+
+// PeerInFlight returns the number of in-flight requests the peer last
+// reported and whether it has sent a heartbeat.
+func (n *Node) PeerInFlight(peerID string) (int64, bool) {
+	n.liveness.mu.RLock()
+	defer n.liveness.mu.RUnlock()
+	l, ok := n.liveness.peers[peerID]
+	return l.InFlight, ok
+}
+
+// startHeartbeats joins the heartbeat topic, publishes a heartbeat every
+// heartbeat.interval and records the ones received. The topic validator
+// only checks heartbeats; they are recorded once delivered.
+func (n *Node) startHeartbeats(ctx context.Context) error {
+	h, psub := n.host, n.psub
+	err := psub.RegisterTopicValidator(heartbeatTopicName, func(ctx context.Context, from peer.ID, msg *pubsub.Message) bool {
+		if msg.GetFrom() == h.ID() {
+			return true
+		}
+		if _, err := n.validateHeartbeat(msg.Data, msg.GetFrom()); err != nil {
+			common.Logger.With("peer", msg.GetFrom()).Debugf("Dropping heartbeat: %v", err)
+			// pubsub has already checked that the author signed the
+			// message, so a bad heartbeat signature is the author's own.
+			if errors.Is(err, errHeartbeatSignature) {
+				RecordSignatureResult(msg.GetFrom().String(), false)
+			}
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	topic, err := psub.Join(heartbeatTopicName)
+	if err != nil {
+		return err
+	}
+	sub, err := topic.Subscribe()
+	if err != nil {
+		return err
+	}
+	n.heartbeatTopic.Store(topic)
+	go func() {
+		for {
+			msg, err := sub.Next(ctx)
+			if err != nil {
+				return
+			}
+			if msg.GetFrom() == h.ID() {
+				continue
+			}
+			var hb Heartbeat
+			if err := json.Unmarshal(msg.Data, &hb); err != nil {
+				continue
+			}
+			n.applyHeartbeat(hb)
+		}
+	}()
+	go func() {
+		interval := readDurationSetting("heartbeat.interval", defaultHeartbeatInterval)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			n.PublishHeartbeat()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
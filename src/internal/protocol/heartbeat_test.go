@@ -0,0 +1,179 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/spf13/viper"
+)
+
+func signedTestHeartbeat(t *testing.T) (Heartbeat, crypto.PrivKey) {
+	t.Helper()
+	priv, _, err := crypto.GenerateEd25519Key(nil)
+	if err != nil {
+		t.Fatalf("GenerateEd25519Key failed: %v", err)
+	}
+	pid, err := peer.IDFromPrivateKey(priv)
+	if err != nil {
+		t.Fatalf("IDFromPrivateKey failed: %v", err)
+	}
+	hb := Heartbeat{PeerID: pid.String(), Seq: 1, Timestamp: time.Now().UnixMilli(), InFlight: 3}
+	if err := signHeartbeat(&hb, priv); err != nil {
+		t.Fatalf("signHeartbeat failed: %v", err)
+	}
+	return hb, priv
+}
+
+func TestHeartbeatSignature(t *testing.T) {
+	hb, _ := signedTestHeartbeat(t)
+	now := time.Now()
+	if err := verifyHeartbeat(hb, nil, now); err != nil {
+		t.Fatalf("expected valid heartbeat, got %v", err)
+	}
+
+	tampered := hb
+	tampered.InFlight = 0
+	if err := verifyHeartbeat(tampered, nil, now); !errors.Is(err, errHeartbeatSignature) {
+		t.Fatalf("expected tampered heartbeat to be rejected for its signature, got %v", err)
+	}
+
+	other, _ := signedTestHeartbeat(t)
+	forged := hb
+	forged.PeerID = other.PeerID
+	if err := verifyHeartbeat(forged, nil, now); err == nil {
+		t.Fatalf("expected heartbeat signed by another key to be rejected")
+	}
+
+	if err := verifyHeartbeat(hb, nil, now.Add(2*heartbeatMaxSkew)); err == nil {
+		t.Fatalf("expected stale heartbeat to be rejected")
+	}
+}
+
+func TestHeartbeatReplay(t *testing.T) {
+	b := newLivenessBook()
+	hb := Heartbeat{PeerID: "peer", Seq: 5}
+	if !b.record(hb) {
+		t.Fatalf("expected first heartbeat to be recorded")
+	}
+	if b.record(hb) {
+		t.Fatalf("expected replayed heartbeat to be rejected")
+	}
+	hb.Seq = 6
+	if !b.record(hb) {
+		t.Fatalf("expected newer heartbeat to be recorded")
+	}
+}
+
+func TestValidateHeartbeatPublisherMismatch(t *testing.T) {
+	mn := mocknet.New()
+	defer mn.Close()
+	h, err := mn.GenPeer()
+	if err != nil {
+		t.Fatalf("GenPeer failed: %v", err)
+	}
+	n := newNode(NodeConfig{})
+	n.host = h
+	hb, _ := signedTestHeartbeat(t)
+	data, _ := json.Marshal(hb)
+	if _, err := n.validateHeartbeat(data, h.ID()); err == nil {
+		t.Fatalf("expected heartbeat relayed under another publisher to be rejected")
+	}
+}
+
+func TestValidateHeartbeatHasNoSideEffects(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	mn := mocknet.New()
+	defer mn.Close()
+	h, err := mn.GenPeer()
+	if err != nil {
+		t.Fatalf("GenPeer failed: %v", err)
+	}
+	n := newNode(NodeConfig{})
+	n.host = h
+	hb, _ := signedTestHeartbeat(t)
+	data, _ := json.Marshal(hb)
+	from, _ := peer.Decode(hb.PeerID)
+
+	validated, err := n.validateHeartbeat(data, from)
+	if err != nil {
+		t.Fatalf("expected valid heartbeat, got %v", err)
+	}
+	if _, ok := n.PeerInFlight(hb.PeerID); ok {
+		t.Fatalf("expected validation not to record the heartbeat")
+	}
+	if _, err := n.GetPeer(hb.PeerID); err == nil {
+		t.Fatalf("expected validation not to touch the node table")
+	}
+
+	n.applyHeartbeat(validated)
+	if inFlight, ok := n.PeerInFlight(hb.PeerID); !ok || inFlight != 3 {
+		t.Fatalf("expected the heartbeat to be recorded, got %d (%v)", inFlight, ok)
+	}
+	var valid int64
+	for _, r := range GetReputations() {
+		if r.PeerID == hb.PeerID {
+			valid = r.ValidSignatures
+		}
+	}
+	if valid != 1 {
+		t.Fatalf("expected the applied heartbeat to count as one valid signature, got %d", valid)
+	}
+	if _, err := n.validateHeartbeat(data, from); err == nil {
+		t.Fatalf("expected the applied heartbeat to be rejected as a replay")
+	}
+}
+
+func TestHeartbeatsOverPubSub(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	t.Setenv("HOME", t.TempDir())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mn, err := mocknet.FullMeshLinked(2)
+	if err != nil {
+		t.Fatalf("FullMeshLinked failed: %v", err)
+	}
+	defer mn.Close()
+	nodes := make([]*Node, 2)
+	for i, h := range mn.Hosts() {
+		n, err := NewNode(ctx, NodeConfig{Mode: "test", Host: h, Heartbeats: true})
+		if err != nil {
+			t.Fatalf("NewNode failed: %v", err)
+		}
+		defer n.Close()
+		nodes[i] = n
+	}
+	if err := mn.ConnectAllButSelf(); err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	receiver, sender := nodes[0], nodes[1]
+	sender.SetInFlightReporter(func() int64 { return 7 })
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		sender.PublishHeartbeat()
+		if n, ok := receiver.PeerInFlight(sender.ID().String()); ok {
+			if n != 7 {
+				t.Fatalf("expected 7 in-flight requests, got %d", n)
+			}
+			if _, ok := PeerInFlight(sender.ID().String()); ok {
+				t.Fatalf("expected the default node not to see the heartbeat")
+			}
+			p, err := receiver.GetPeer(sender.ID().String())
+			if err != nil || !p.Connected {
+				t.Fatalf("expected sender marked alive in the node table, got %+v (%v)", p, err)
+			}
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("no heartbeat received")
+}
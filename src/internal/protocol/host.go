@@ -3,11 +3,10 @@ package protocol
 import (
 	"bytes"
 	"context"
-	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
+	"fmt"
 	mrand "math/rand"
 	"net"
 	"ocf/internal/common"
@@ -21,19 +20,16 @@ import (
 	dht "github.com/libp2p/go-libp2p-kad-dht"
 	dualdht "github.com/libp2p/go-libp2p-kad-dht/dual"
 	record "github.com/libp2p/go-libp2p-record"
-	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/routing"
-	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
 	"github.com/libp2p/go-libp2p/p2p/security/noise"
 	libp2ptls "github.com/libp2p/go-libp2p/p2p/security/tls"
 	"github.com/spf13/viper"
 )
 
 var P2PNode *host.Host
-var ddht *dualdht.DHT
 var hostOnce sync.Once
 var MyID string
 
@@ -41,55 +37,36 @@ const (
 	Version = "0.0.0-dev.0"
 )
 
+// GetP2PNode creates the host of the default node on first use and
+// returns it with its DHT.
 func GetP2PNode(ds datastore.Batching) (host.Host, dualdht.DHT) {
 	hostOnce.Do(func() {
 		ctx := context.Background()
-		var err error
 		seed := viper.GetString("seed")
 		// try to parse the seed as int64
 		seedInt, err := strconv.ParseInt(seed, 10, 64)
 		if err != nil {
 			panic(err)
 		}
-		host, err := newHost(ctx, seedInt, ds)
-		MyID = host.ID().String()
-		P2PNode = &host
-		if err != nil {
+		std.cfg.Mode = viper.GetString("mode")
+		std.cfg.Seed = seedInt
+		if err := std.setupHost(ctx, ds); err != nil {
 			panic(err)
 		}
+		MyID = std.host.ID().String()
+		P2PNode = &std.host
+
+		// Start a background auto-reconnector that watches connectivity
+		go startAutoReconnect(ctx, std.host)
+		go watchConnectivity(ctx, std.host)
 	})
-	return *P2PNode, *ddht
+	return *P2PNode, *std.dht
 }
 
-func newHost(ctx context.Context, seed int64, ds datastore.Batching) (host.Host, error) {
-	var err error
-	if err != nil {
-		common.Logger.Error("Error while creating connection manager: ", err)
-	}
-	var priv crypto.PrivKey
-	// try to load the private key from file
-	if seed == 0 {
-		// try to load from the file
-		priv = loadKeyFromFile()
-		if priv == nil {
-			common.Logger.Info("No existing private key found, generating a new one...")
-			r := rand.Reader
-			priv, _, err = crypto.GenerateKeyPairWithReader(crypto.RSA, 2048, r)
-			if err != nil {
-				return nil, err
-			}
-			writeKeyToFile(priv)
-		}
-	} else {
-		r := mrand.New(mrand.NewSource(seed))
-		priv, _, err = crypto.GenerateKeyPairWithReader(crypto.RSA, 2048, r)
-		if err != nil {
-			return nil, err
-		}
-		writeKeyToFile(priv)
-	}
+func newHost(ctx context.Context, mode string, seed int64, ds datastore.Batching) (host.Host, *dualdht.DHT, error) {
+	priv, err := loadOrCreateIdentity(seed, mode)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	hash := sha256.Sum256([]byte(Version))
@@ -100,23 +77,51 @@ func newHost(ctx context.Context, seed int64, ds datastore.Batching) (host.Host,
 	buf.WriteString("/base16/\n")
 	buf.WriteString(keyHex + "\n")
 
+	rm, err := newResourceManager()
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create resource manager: %w", err)
+	}
+	cm, err := newConnManager()
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create connection manager: %w", err)
+	}
+
+	transports, err := enabledTransports()
+	if err != nil {
+		return nil, nil, err
+	}
+	listen, err := listenAddrs(transports)
+	if err != nil {
+		return nil, nil, err
+	}
+	addrsFactory, err := newAddrsFactory(transports)
+	if err != nil {
+		return nil, nil, err
+	}
+	reachability, err := reachabilityOption()
+	if err != nil {
+		return nil, nil, err
+	}
+	relays, err := staticRelayOption()
+	if err != nil {
+		return nil, nil, err
+	}
+
 	// psk, err := pnet.DecodeV1PSK(bytes.NewReader(buf.Bytes()))
 	// if err != nil {
 	// 	panic(err)
 	// }
 
+	var d *dualdht.DHT
 	opts := []libp2p.Option{
-		libp2p.DefaultTransports,
 		libp2p.Identity(priv),
 		// libp2p.PrivateNetwork(psk),
-		libp2p.ResourceManager(&network.NullResourceManager{}),
-		// libp2p.ConnectionManager(connmgr),
+		libp2p.ResourceManager(rm),
+		libp2p.ConnectionGater(accessGater{}),
+		libp2p.ConnectionManager(cm),
 		libp2p.NATPortMap(),
-		libp2p.ListenAddrStrings(
-			"/ip4/0.0.0.0/tcp/"+viper.GetString("tcpport"),
-			"/ip4/0.0.0.0/tcp/"+viper.GetString("tcpport")+"/ws",
-			"/ip4/0.0.0.0/udp/"+viper.GetString("udpport")+"/quic",
-		),
+		libp2p.ListenAddrStrings(listen...),
+		libp2p.AddrsFactory(addrsFactory),
 		libp2p.Security(libp2ptls.ID, libp2ptls.New),
 		libp2p.Security(noise.ID, noise.New),
 		libp2p.EnableNATService(),
@@ -124,74 +129,25 @@ func newHost(ctx context.Context, seed int64, ds datastore.Batching) (host.Host,
 		libp2p.EnableHolePunching(),
 		libp2p.EnableAutoNATv2(),
 		libp2p.EnableRelayService(),
-		libp2p.ForceReachabilityPublic(),
 		libp2p.Routing(func(h host.Host) (routing.PeerRouting, error) {
-			ddht, err = newDHT(ctx, h, ds)
-			return ddht, err
+			d, err = newDHT(ctx, h, ds)
+			return d, err
 		}),
 	}
+	opts = append(opts, transportOptions(transports)...)
+	if reachability != nil {
+		opts = append(opts, reachability)
+	}
+	if relays != nil {
+		opts = append(opts, relays)
+	}
 
 	host, err := libp2p.New(opts...)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	// Log connection events for debugging
-	host.Network().Notify(&network.NotifyBundle{
-		ConnectedF: func(n network.Network, c network.Conn) {
-			common.Logger.Info("Connected to peer: ", c.RemotePeer(), " Total connections: ", len(n.Conns()))
-			// On (re)connections, re-announce local services
-			go ReannounceLocalServices()
-
-			// Mark peer as connected in node table immediately
-			go func(pid peer.ID) {
-				// Avoid updating self
-				if pid == host.ID() {
-					return
-				}
-				p, err := GetPeerFromTable(pid.String())
-				if err != nil {
-					p = Peer{ID: pid.String()}
-					common.Logger.Infof("Adding peer: [%s] triggered by new connection", pid.String())
-				} else {
-					common.Logger.Infof("Updating peer: [%s] triggered by new connection", pid.String())
-				}
-				p.Connected = true
-				p.LastSeen = time.Now().Unix()
-				if b, e := json.Marshal(p); e == nil {
-					UpdateNodeTableHook(datastore.NewKey(pid.String()), b)
-				} else {
-					common.Logger.Error("Failed to marshal peer on connect: ", e)
-				}
-			}(c.RemotePeer())
-		},
-		DisconnectedF: func(n network.Network, c network.Conn) {
-			common.Logger.Info("Disconnected from peer: ", c.RemotePeer(), " Total connections: ", len(n.Conns()))
-			// Mark peer as disconnected in node table immediately
-			go func(pid peer.ID) {
-				if pid == host.ID() {
-					return
-				}
-				p, err := GetPeerFromTable(pid.String())
-				if err != nil {
-					p = Peer{ID: pid.String()}
-				}
-				p.Connected = false
-				common.Logger.Infof("Removing peer: [%s] triggered by disconnection", pid.String())
-				// keep LastSeen as last known good; do not bump here
-				if b, e := json.Marshal(p); e == nil {
-					UpdateNodeTableHook(datastore.NewKey(pid.String()), b)
-				} else {
-					common.Logger.Error("Failed to marshal peer on disconnect: ", e)
-				}
-			}(c.RemotePeer())
-		},
-	})
-
-	// Start a background auto-reconnector that watches connectivity
-	go startAutoReconnect(ctx, host)
-
-	return host, nil
+	return host, d, nil
 }
 
 // startAutoReconnect periodically checks if we lost connectivity and attempts to reconnect to bootstraps with backoff.
@@ -261,6 +217,9 @@ func tryReconnectToBootstraps(ctx context.Context, h host.Host, dialTimeout time
 		common.Logger.Error("Failed to parse bootstrap peers during reconnect: ", err)
 		return false
 	}
+	// Try the bootstraps that have behaved best and are closest first.
+	sortByReputation(peerInfos)
+	protectBootstraps(h, peerInfos)
 
 	successes := 0
 	for _, info := range peerInfos {
@@ -416,43 +375,23 @@ func AllPeers() []*PeerWithStatus {
 
 func ConnectedBootstraps() []string {
 	var bootstraps = []string{}
+	mode := viper.GetString("mode")
 	dnt := GetAllPeers()
 	host, _ := GetP2PNode(nil)
 	for _, p := range *dnt {
 		if p.PublicAddress != "" {
 			common.Logger.Info("Peer: ", p.ID, " Public Address: ", p.PublicAddress, " Connectedness: ", host.Network().Connectedness(peer.ID(p.ID)), " Host ID: ", host.ID())
 			if host.Network().Connectedness(peer.ID(p.ID)) == network.Connected || host.ID().String() == p.ID {
-				bootstrapAddr := "/ip4/" + p.PublicAddress + "/tcp/" + viper.GetString("tcpport") + "/p2p/" + p.ID
-				bootstraps = append(bootstraps, bootstrapAddr)
+				// Prefer the addresses the peer announced; older peers only
+				// publish public-addr, which implies the default TCP port.
+				addrs := bootstrapAddrs(p.ID, p.Addrs, mode)
+				if len(addrs) == 0 {
+					addrs = []string{"/ip4/" + p.PublicAddress + "/tcp/" + viper.GetString("tcpport") + "/p2p/" + p.ID}
+				}
+				bootstraps = append(bootstraps, addrs...)
 			}
 		}
 	}
 	bootstraps = common.DeduplicateStrings(bootstraps)
 	return bootstraps
 }
-
-// GetResourceManagerStats returns current resource usage statistics
-func GetResourceManagerStats() {
-	host, _ := GetP2PNode(nil)
-	if rm := host.Network().ResourceManager(); rm != nil {
-		// Try to get stats if available
-		if statsGetter, ok := rm.(interface {
-			Stat() rcmgr.ResourceManagerStat
-		}); ok {
-			stats := statsGetter.Stat()
-			common.Logger.Infof("Resource Manager Stats - System: Conns=%d (in:%d out:%d), Streams=%d (in:%d out:%d), Memory=%d",
-				stats.System.NumConnsInbound+stats.System.NumConnsOutbound,
-				stats.System.NumConnsInbound,
-				stats.System.NumConnsOutbound,
-				stats.System.NumStreamsInbound+stats.System.NumStreamsOutbound,
-				stats.System.NumStreamsInbound,
-				stats.System.NumStreamsOutbound,
-				stats.System.Memory,
-			)
-		} else {
-			common.Logger.Info("Resource Manager present but stats not available")
-		}
-	} else {
-		common.Logger.Info("No Resource Manager configured")
-	}
-}
@@ -1,55 +1,212 @@
 package protocol
 
 import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
+	mrand "math/rand"
 	"ocf/internal/common"
 	"os"
 	"path"
+	"strings"
+	"time"
 
 	"github.com/libp2p/go-libp2p/core/crypto"
-	"github.com/mitchellh/go-homedir"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/spf13/viper"
 )
 
-func writeKeyToFile(priv crypto.PrivKey) {
+const (
+	KeyTypeEd25519 = "ed25519"
+	KeyTypeRSA     = "rsa"
+
+	defaultIdentityKeyType = KeyTypeEd25519
+	rsaKeyBits             = 2048
+)
+
+// seededKeyModes lists the modes in which a deterministic, seed-derived
+// identity is acceptable. Such keys are trivially reproducible by anyone who
+// knows the seed, so they must never be used on a public network.
+var seededKeyModes = map[string]struct{}{
+	"local": {},
+	"test":  {},
+}
+
+// IdentityKeyPath returns where the node identity key is stored. It can be
+// overridden with the identity.key_path setting.
+func IdentityKeyPath() string {
+	if p := strings.TrimSpace(viper.GetString("identity.key_path")); p != "" {
+		return p
+	}
+	return path.Join(common.GetHomePath(), "keys", "id")
+}
+
+// GenerateIdentityKey creates a new random identity key of the given type.
+func GenerateIdentityKey(keyType string) (crypto.PrivKey, error) {
+	return generateKey(keyType, rand.Reader)
+}
+
+func generateKey(keyType string, r io.Reader) (crypto.PrivKey, error) {
+	var priv crypto.PrivKey
+	var err error
+	switch strings.ToLower(keyType) {
+	case "", KeyTypeEd25519:
+		priv, _, err = crypto.GenerateKeyPairWithReader(crypto.Ed25519, -1, r)
+	case KeyTypeRSA:
+		priv, _, err = crypto.GenerateKeyPairWithReader(crypto.RSA, rsaKeyBits, r)
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", keyType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return priv, nil
+}
+
+// seededIdentityKey derives a deterministic key from seed. It is only allowed
+// in local or test mode. Ed25519 is used because recent Go releases no longer
+// honour the random source passed to RSA key generation.
+func seededIdentityKey(seed int64, mode string) (crypto.PrivKey, error) {
+	if _, ok := seededKeyModes[mode]; !ok {
+		return nil, fmt.Errorf("refusing to use a seeded identity key in %q mode; seeds are only allowed in local or test mode", mode)
+	}
+	common.Logger.Warnf("Using a deterministic identity derived from seed %d; never do this on a public network", seed)
+	return generateKey(KeyTypeEd25519, mrand.New(mrand.NewSource(seed)))
+}
+
+// LoadIdentityKey reads a protobuf-encoded libp2p private key from keyPath.
+func LoadIdentityKey(keyPath string) (crypto.PrivKey, error) {
+	keyData, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	return decodeIdentityKey(keyData)
+}
+
+// decodeIdentityKey accepts either the raw protobuf encoding written by
+// SaveIdentityKey or its base64 form (as found in IPFS/Kubo configs).
+func decodeIdentityKey(data []byte) (crypto.PrivKey, error) {
+	priv, err := crypto.UnmarshalPrivateKey(data)
+	if err == nil {
+		return priv, nil
+	}
+	decoded, decErr := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if decErr != nil {
+		return nil, fmt.Errorf("error while unmarshalling private key: %w", err)
+	}
+	return crypto.UnmarshalPrivateKey(decoded)
+}
+
+// SaveIdentityKey writes priv to keyPath with owner-only permissions.
+func SaveIdentityKey(keyPath string, priv crypto.PrivKey) error {
 	keyData, err := crypto.MarshalPrivateKey(priv)
 	if err != nil {
-		common.Logger.Error("Error while marshalling private key: ", err)
+		return fmt.Errorf("error while marshalling private key: %w", err)
+	}
+	if err := os.MkdirAll(path.Dir(keyPath), 0o700); err != nil {
+		return fmt.Errorf("could not create keys directory: %w", err)
+	}
+	if err := os.WriteFile(keyPath, keyData, 0o600); err != nil {
+		return fmt.Errorf("could not write key to file: %w", err)
 	}
-	home, err := homedir.Dir()
+	return nil
+}
+
+// ImportIdentityKey validates data as a private key and stores it at keyPath.
+func ImportIdentityKey(keyPath string, data []byte) (crypto.PrivKey, error) {
+	priv, err := decodeIdentityKey(data)
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		return nil, err
+	}
+	if err := SaveIdentityKey(keyPath, priv); err != nil {
+		return nil, err
 	}
-	keyPath := path.Join(home, ".ocfcore", "keys", "id")
-	err = os.MkdirAll(path.Dir(keyPath), os.ModePerm)
+	return priv, nil
+}
+
+// ExportIdentityKey returns the base64 encoded private key stored at keyPath.
+func ExportIdentityKey(keyPath string) (string, error) {
+	priv, err := LoadIdentityKey(keyPath)
 	if err != nil {
-		common.Logger.Error("Could not create keys directory", "error", err)
-		os.Exit(1)
+		return "", err
 	}
-	err = os.WriteFile(keyPath, keyData, 0600)
+	keyData, err := crypto.MarshalPrivateKey(priv)
 	if err != nil {
-		common.Logger.Error("Could not write key to file", err)
-		os.Exit(1)
+		return "", err
 	}
+	return base64.StdEncoding.EncodeToString(keyData), nil
 }
 
-func loadKeyFromFile() crypto.PrivKey {
-	home, err := homedir.Dir()
+// RotateIdentityKey replaces the key at keyPath with a freshly generated one.
+// The previous key is kept next to it with a timestamp suffix so that the old
+// peer ID can be recovered. It returns the new key and the backup location.
+func RotateIdentityKey(keyPath string, keyType string) (crypto.PrivKey, string, error) {
+	priv, err := GenerateIdentityKey(keyType)
 	if err != nil {
-		fmt.Println(err)
-		return nil
+		return nil, "", err
 	}
-	keyPath := path.Join(home, ".ocfcore", "keys", "id")
+	backup := ""
+	if _, err := os.Stat(keyPath); err == nil {
+		backup = fmt.Sprintf("%s.%s.bak", keyPath, time.Now().UTC().Format("20060102T150405Z"))
+		if err := os.Rename(keyPath, backup); err != nil {
+			return nil, "", fmt.Errorf("could not back up current key: %w", err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, "", err
+	}
+	if err := SaveIdentityKey(keyPath, priv); err != nil {
+		return nil, backup, err
+	}
+	return priv, backup, nil
+}
+
+// KeyTypeName returns a human readable name of the key algorithm.
+func KeyTypeName(priv crypto.PrivKey) string {
+	switch priv.Type() {
+	case crypto.Ed25519:
+		return KeyTypeEd25519
+	case crypto.RSA:
+		return KeyTypeRSA
+	default:
+		return strings.ToLower(priv.Type().String())
+	}
+}
+
+// PeerIDFromKey returns the peer ID derived from priv.
+func PeerIDFromKey(priv crypto.PrivKey) (peer.ID, error) {
+	return peer.IDFromPrivateKey(priv)
+}
+
+// loadOrCreateIdentity returns the identity used by newHost. With a zero seed
+// it loads the key from disk, generating and persisting one on first start.
+// Seeded keys are never written to disk so they cannot overwrite a real
+// identity.
+func loadOrCreateIdentity(seed int64, mode string) (crypto.PrivKey, error) {
+	if seed != 0 {
+		return seededIdentityKey(seed, mode)
+	}
+	keyPath := IdentityKeyPath()
 	common.Logger.Info("Looking for keys under: ", keyPath)
-	keyData, err := os.ReadFile(keyPath)
-	if err != nil {
-		common.Logger.Error("Error while reading private key file: ", err)
-		return nil
+	priv, err := LoadIdentityKey(keyPath)
+	if err == nil {
+		return priv, nil
 	}
-	priv, err := crypto.UnmarshalPrivateKey(keyData)
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("error while reading private key file %s: %w", keyPath, err)
+	}
+	keyType := viper.GetString("identity.key_type")
+	if keyType == "" {
+		keyType = defaultIdentityKeyType
+	}
+	common.Logger.Infof("No existing private key found, generating a new %s key...", keyType)
+	priv, err = GenerateIdentityKey(keyType)
 	if err != nil {
-		common.Logger.Error("Error while unmarshalling private key: ", err)
-		return nil
+		return nil, err
+	}
+	if err := SaveIdentityKey(keyPath, priv); err != nil {
+		return nil, err
 	}
-	return priv
+	return priv, nil
 }
@@ -0,0 +1,85 @@
+package protocol
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/spf13/viper"
+)
+
+func TestSeededIdentityKeyRefusedOutsideLocal(t *testing.T) {
+	if _, err := seededIdentityKey(42, "node"); err == nil {
+		t.Fatalf("expected seeded key to be refused in node mode")
+	}
+	a, err := seededIdentityKey(42, "local")
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	b, _ := seededIdentityKey(42, "local")
+	if !a.Equals(b) {
+		t.Fatalf("expected seeded keys to be deterministic")
+	}
+}
+
+func TestLoadOrCreateIdentityPersists(t *testing.T) {
+	viper.Reset()
+	keyPath := filepath.Join(t.TempDir(), "keys", "id")
+	viper.Set("identity.key_path", keyPath)
+	defer viper.Reset()
+
+	first, err := loadOrCreateIdentity(0, "node")
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	if first.Type() != crypto.Ed25519 {
+		t.Fatalf("expected ed25519 key by default, got %s", first.Type())
+	}
+	second, err := loadOrCreateIdentity(0, "node")
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	if !first.Equals(second) {
+		t.Fatalf("expected identity to be reloaded from %s", keyPath)
+	}
+}
+
+func TestImportExportRotateIdentity(t *testing.T) {
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "id")
+	priv, err := GenerateIdentityKey(KeyTypeRSA)
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	if err := SaveIdentityKey(keyPath, priv); err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+
+	encoded, err := ExportIdentityKey(keyPath)
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	imported, err := ImportIdentityKey(filepath.Join(dir, "imported"), []byte(encoded))
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	if !imported.Equals(priv) {
+		t.Fatalf("expected imported key to match exported key")
+	}
+
+	rotated, backup, err := RotateIdentityKey(keyPath, KeyTypeEd25519)
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	if rotated.Equals(priv) {
+		t.Fatalf("expected a new key after rotation")
+	}
+	old, err := LoadIdentityKey(backup)
+	if err != nil || !old.Equals(priv) {
+		t.Fatalf("expected backup to hold the previous key: %v", err)
+	}
+	if st, err := os.Stat(keyPath); err != nil || st.Mode().Perm() != 0o600 {
+		t.Fatalf("expected rotated key with 0600 permissions: %v", err)
+	}
+}
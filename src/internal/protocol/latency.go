@@ -0,0 +1,174 @@
+package protocol
+
+import (
+	"context"
+	"ocf/internal/common"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+)
+
+const (
+	// rttEWMAAlpha weights the newest round trip sample.
+	rttEWMAAlpha     = 0.3
+	pingTimeout      = 10 * time.Second
+	maxParallelPings = 16
+	// latencyReferenceMs is the round trip at which latencyWeight is 0.5. It
+	// is also assumed for peers that have not been measured yet.
+	latencyReferenceMs = 200.0
+)
+
+// PeerLatency is the measured round trip time to a peer. It is kept locally
+// and never replicated, since it only makes sense from this node's position.
+type PeerLatency struct {
+	PeerID    string  `json:"peer_id"`
+	RTTMs     float64 `json:"rtt_ms"`      // EWMA of ping round trips
+	LastRTTMs float64 `json:"last_rtt_ms"` // most recent sample
+	Samples   int64   `json:"samples"`
+	Failures  int64   `json:"failures"`
+	UpdatedAt int64   `json:"updated_at"`
+}
+
+type latencyBook struct {
+	mu    sync.RWMutex
+	peers map[string]*PeerLatency
+}
+
+var latencies = &latencyBook{peers: make(map[string]*PeerLatency)}
+
+func (b *latencyBook) record(peerID string, rtt time.Duration) float64 {
+	ms := float64(rtt) / float64(time.Millisecond)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	l, ok := b.peers[peerID]
+	if !ok {
+		l = &PeerLatency{PeerID: peerID}
+		b.peers[peerID] = l
+	}
+	if l.Samples == 0 {
+		l.RTTMs = ms
+	} else {
+		l.RTTMs = rttEWMAAlpha*ms + (1-rttEWMAAlpha)*l.RTTMs
+	}
+	l.LastRTTMs = ms
+	l.Samples++
+	l.UpdatedAt = time.Now().Unix()
+	return l.RTTMs
+}
+
+func (b *latencyBook) recordFailure(peerID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	l, ok := b.peers[peerID]
+	if !ok {
+		l = &PeerLatency{PeerID: peerID}
+		b.peers[peerID] = l
+	}
+	l.Failures++
+	l.UpdatedAt = time.Now().Unix()
+}
+
+func (b *latencyBook) rtt(peerID string) (float64, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	l, ok := b.peers[peerID]
+	if !ok || l.Samples == 0 {
+		return 0, false
+	}
+	return l.RTTMs, true
+}
+
+// PeerRTT returns the smoothed round trip time to peerID in milliseconds and
+// whether it has been measured.
+func PeerRTT(peerID string) (float64, bool) {
+	return latencies.rtt(peerID)
+}
+
+// GetLatencies returns every measured peer, fastest first.
+func GetLatencies() []PeerLatency {
+	latencies.mu.RLock()
+	out := make([]PeerLatency, 0, len(latencies.peers))
+	for _, l := range latencies.peers {
+		out = append(out, *l)
+	}
+	latencies.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool {
+		if (out[i].Samples == 0) != (out[j].Samples == 0) {
+			return out[i].Samples > 0
+		}
+		return out[i].RTTMs < out[j].RTTMs
+	})
+	return out
+}
+
+// latencyWeight maps a round trip time to (0, 1]; faster peers weigh more.
+// Unmeasured peers get the weight of latencyReferenceMs.
+func latencyWeight(peerID string) float64 {
+	ms, ok := PeerRTT(peerID)
+	if !ok {
+		ms = latencyReferenceMs
+	}
+	return latencyReferenceMs / (latencyReferenceMs + ms)
+}
+
+// setTableLatency stores the measured latency in the local node table entry.
+func setTableLatency(peerID string, rttMs float64) {
+	std.table.setLatency(peerID, rttMs)
+}
+
+// localLatency returns the latency to store in the node table for peerID.
+func localLatency(key string) int {
+	ms, ok := PeerRTT(strings.TrimPrefix(key, "/"))
+	if !ok {
+		return 0
+	}
+	return int(ms + 0.5)
+}
+
+// probePeer pings pid once and records the result.
+func probePeer(ctx context.Context, h host.Host, pid peer.ID) {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	select {
+	case res := <-ping.Ping(ctx, h, pid):
+		if res.Error != nil {
+			common.Logger.With("peer", pid).Debugf("Ping failed: %v", res.Error)
+			latencies.recordFailure(pid.String())
+			return
+		}
+		rtt := latencies.record(pid.String(), res.RTT)
+		setTableLatency(pid.String(), rtt)
+	case <-ctx.Done():
+		latencies.recordFailure(pid.String())
+	}
+}
+
+// ProbeLatencies pings every connected peer and updates the RTT estimates.
+func ProbeLatencies() {
+	h, _ := GetP2PNode(nil)
+	probeConnectedPeers(context.Background(), h)
+}
+
+func probeConnectedPeers(ctx context.Context, h host.Host) {
+	sem := make(chan struct{}, maxParallelPings)
+	var wg sync.WaitGroup
+	for _, pid := range h.Network().Peers() {
+		if pid == h.ID() || h.Network().Connectedness(pid) != network.Connected {
+			continue
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(pid peer.ID) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			probePeer(ctx, h, pid)
+		}(pid)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,66 @@
+package protocol
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+)
+
+func TestLatencyEWMA(t *testing.T) {
+	b := &latencyBook{peers: make(map[string]*PeerLatency)}
+	if _, ok := b.rtt("a"); ok {
+		t.Fatalf("expected unmeasured peer to have no RTT")
+	}
+	if got := b.record("a", 100*time.Millisecond); got != 100 {
+		t.Fatalf("expected first sample to seed the average, got %v", got)
+	}
+	got := b.record("a", 200*time.Millisecond)
+	want := rttEWMAAlpha*200 + (1-rttEWMAAlpha)*100
+	if got != want {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	b.recordFailure("a")
+	if b.peers["a"].Failures != 1 || b.peers["a"].LastRTTMs != 200 {
+		t.Fatalf("unexpected entry: %+v", b.peers["a"])
+	}
+}
+
+func TestLatencyWeight(t *testing.T) {
+	latencies.record("fast", 10*time.Millisecond)
+	latencies.record("slow", 2*time.Second)
+	if !(latencyWeight("fast") > latencyWeight("unknown") && latencyWeight("unknown") > latencyWeight("slow")) {
+		t.Fatalf("expected fast > unmeasured > slow")
+	}
+}
+
+func TestProbeConnectedPeers(t *testing.T) {
+	mn := mocknet.New()
+	defer mn.Close()
+	mn.SetLinkDefaults(mocknet.LinkOptions{Latency: 5 * time.Millisecond})
+	for i := 0; i < 2; i++ {
+		h, err := mn.GenPeer()
+		if err != nil {
+			t.Fatalf("GenPeer failed: %v", err)
+		}
+		ping.NewPingService(h)
+	}
+	if err := mn.LinkAll(); err != nil {
+		t.Fatalf("LinkAll failed: %v", err)
+	}
+	if err := mn.ConnectAllButSelf(); err != nil {
+		t.Fatalf("ConnectAllButSelf failed: %v", err)
+	}
+	hosts := mn.Hosts()
+
+	probeConnectedPeers(context.Background(), hosts[0])
+	ms, ok := PeerRTT(hosts[1].ID().String())
+	if !ok {
+		t.Fatalf("expected the connected peer to be measured")
+	}
+	if ms < 5 {
+		t.Fatalf("expected RTT to include the link latency, got %vms", ms)
+	}
+}
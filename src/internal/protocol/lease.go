@@ -0,0 +1,110 @@
+package protocol
+
+import (
+	"context"
+	"ocf/internal/common"
+	"time"
+
+	crdt "ocf/internal/protocol/go-ds-crdt"
+
+	ds "github.com/ipfs/go-datastore"
+)
+
+const (
+	defaultLeaseTTL              = 30 * time.Minute
+	defaultExpiredTombstoneAfter = time.Hour
+	// leaseClockSkew is how far another node's clock may be ahead of ours
+	// before its lease is considered expired too early.
+	leaseClockSkew = time.Minute
+)
+
+func leaseTTL() time.Duration {
+	return readDurationSetting("crdt.lease_ttl", defaultLeaseTTL)
+}
+
+// setSelfLease extends the lease on our own node table entry. Every record
+// we publish about ourselves carries it; other nodes treat the entry as
+// absent once it runs out.
+func setSelfLease(p *Peer) {
+	p.LeaseExpires = time.Now().Add(leaseTTL()).Unix()
+}
+
+// leaseExpired reports whether p's lease ran out before now. Records from
+// nodes that do not set a lease never expire.
+func leaseExpired(p Peer, now time.Time) bool {
+	if p.LeaseExpires == 0 {
+		return false
+	}
+	return now.After(time.Unix(p.LeaseExpires, 0).Add(leaseClockSkew))
+}
+
+// RenewLeaseIfDue re-announces our entry once a third of the lease is left.
+func RenewLeaseIfDue() {
+	self := std.registrar.Self()
+	if self.ID == "" {
+		return
+	}
+	remaining := time.Until(time.Unix(self.LeaseExpires, 0))
+	if remaining > leaseTTL()/3 {
+		return
+	}
+	common.Logger.Debug("Renewing node table lease")
+	ReannounceLocalServices()
+}
+
+// ExpireLeases drops expired entries from the local node table, and
+// tombstones them in the CRDT once they have been expired for
+// crdt.expired_tombstone_after, so that crashed nodes disappear everywhere.
+// Any node may tombstone an entry: removals only cover the versions seen,
+// so a concurrent renewal by its owner wins.
+func ExpireLeases() {
+	DefaultNode().ExpireLeases(time.Now())
+}
+
+// ExpireLeases drops the entries whose lease had expired at now from the
+// node table, and tombstones the long expired ones. It returns how many
+// entries were dropped and tombstoned.
+func (n *Node) ExpireLeases(now time.Time) (int, int) {
+	dropped, tombstoned := expireLeases(context.Background(), n.store, n.table, n.host.ID().String(), now)
+	if dropped > 0 || tombstoned > 0 {
+		common.Logger.Infof("Lease expiry: %d expired peer(s) dropped, %d tombstoned", dropped, tombstoned)
+	}
+	return dropped, tombstoned
+}
+
+func expireLeases(ctx context.Context, store *crdt.Datastore, table *nodeTable, selfID string, now time.Time) (int, int) {
+	tombstoneAfter := readDurationSetting("crdt.expired_tombstone_after", defaultExpiredTombstoneAfter)
+	records, err := peerRecords(ctx, store)
+	if err != nil {
+		common.Logger.Warn("Could not read node table for lease expiry: ", err)
+		return 0, 0
+	}
+	var expired []string
+	var stale []string
+	for id, value := range records {
+		if id == selfID {
+			continue
+		}
+		p, err := decodePeerRecord(value)
+		if err != nil || !leaseExpired(p, now) {
+			continue
+		}
+		expired = append(expired, id)
+		if leaseExpired(p, now.Add(-tombstoneAfter)) {
+			stale = append(stale, id)
+		}
+	}
+
+	for _, id := range expired {
+		table.remove(ds.NewKey(id))
+	}
+	tombstoned := 0
+	for _, id := range stale {
+		if err := deletePeerRecord(ctx, store, id); err != nil {
+			common.Logger.Warnf("Could not tombstone expired peer %s: %v", id, err)
+			continue
+		}
+		tombstoned++
+	}
+	return len(expired), tombstoned
+}
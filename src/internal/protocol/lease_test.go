@@ -0,0 +1,129 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/spf13/viper"
+)
+
+func TestLeaseExpired(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		name    string
+		expires int64
+		want    bool
+	}{
+		{"no lease", 0, false},
+		{"valid", now.Add(time.Minute).Unix(), false},
+		{"within clock skew", now.Add(-leaseClockSkew / 2).Unix(), false},
+		{"expired", now.Add(-2 * leaseClockSkew).Unix(), true},
+	}
+	for _, tc := range cases {
+		if got := leaseExpired(Peer{LeaseExpires: tc.expires}, now); got != tc.want {
+			t.Fatalf("%s: leaseExpired = %t, want %t", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestSetSelfLease(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	viper.Set("crdt.lease_ttl", "10m")
+	var p Peer
+	setSelfLease(&p)
+	if d := time.Until(time.Unix(p.LeaseExpires, 0)); d < 9*time.Minute || d > 10*time.Minute {
+		t.Fatalf("expected a 10 minute lease, got %s", d)
+	}
+}
+
+func TestExpiredPeersAreAbsent(t *testing.T) {
+	expired := Peer{
+		ID:           "expiredprovider",
+		Connected:    true,
+		LeaseExpires: time.Now().Add(-time.Hour).Unix(),
+		Service:      []Service{{Name: "lease-test"}},
+	}
+	b, _ := json.Marshal(expired)
+	UpdateNodeTableHook(ds.NewKey(expired.ID), b)
+	defer DeleteNodeTableHook(ds.NewKey(expired.ID))
+
+	if _, err := GetPeerFromTable(expired.ID); err == nil {
+		t.Fatalf("expected expired peer to be absent")
+	}
+	if _, ok := (*GetAllPeers())["/"+expired.ID]; ok {
+		t.Fatalf("expected expired peer to be hidden from the table")
+	}
+	if _, err := GetAllProviders("lease-test"); err == nil {
+		t.Fatalf("expected no providers from an expired entry")
+	}
+}
+
+func TestLivenessDoesNotReviveExpiredPeers(t *testing.T) {
+	table := newNodeTable()
+	expired := Peer{ID: "crashedprovider", LeaseExpires: time.Now().Add(-time.Hour).Unix()}
+	b, _ := json.Marshal(expired)
+	table.update(ds.NewKey(expired.ID), b)
+
+	if created := table.refresh(expired.ID, func(p *Peer) { p.Connected = true }); created {
+		t.Fatalf("expected the expired entry to be refreshed in place")
+	}
+	if _, err := table.get(expired.ID); err == nil {
+		t.Fatalf("expected a ping not to revive an expired peer")
+	}
+
+	if created := table.refresh("newpeer", func(p *Peer) { p.Connected = true }); !created {
+		t.Fatalf("expected an entry to be created for an unknown peer")
+	}
+	p, err := table.get("newpeer")
+	if err != nil {
+		t.Fatalf("expected the new peer to be present: %v", err)
+	}
+	if p.LeaseExpires == 0 || !leaseExpired(p, time.Now().Add(leaseTTL()+2*leaseClockSkew)) {
+		t.Fatalf("expected a connection-only entry to get a local lease, got %d", p.LeaseExpires)
+	}
+}
+
+func TestExpireLeases(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	viper.Set("crdt.expired_tombstone_after", "1h")
+	ctx := context.Background()
+	store := newTestCRDT(t, nil)
+	now := time.Now()
+
+	records := map[string]int64{
+		"self":     now.Add(-2 * time.Hour).Unix(),
+		"live":     now.Add(time.Minute).Unix(),
+		"nolease":  0,
+		"recent":   now.Add(-10 * time.Minute).Unix(),
+		"longgone": now.Add(-2 * time.Hour).Unix(),
+	}
+	for id, expires := range records {
+		b, _ := json.Marshal(Peer{ID: id, LeaseExpires: expires})
+		if err := store.Put(ctx, ds.NewKey(id), b); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		UpdateNodeTableHook(ds.NewKey(id), b)
+		defer DeleteNodeTableHook(ds.NewKey(id))
+	}
+
+	dropped, tombstoned := expireLeases(ctx, store, std.table, "self", now)
+	if dropped != 2 || tombstoned != 1 {
+		t.Fatalf("expected 2 dropped and 1 tombstoned, got %d and %d", dropped, tombstoned)
+	}
+	table := *getNodeTable()
+	for _, id := range []string{"recent", "longgone"} {
+		if _, ok := table["/"+id]; ok {
+			t.Fatalf("expected %s to be dropped from the local table", id)
+		}
+	}
+	for id, wantPresent := range map[string]bool{"self": true, "live": true, "nolease": true, "recent": true, "longgone": false} {
+		if ok, _ := store.Has(ctx, ds.NewKey(id)); ok != wantPresent {
+			t.Fatalf("expected %s in the CRDT: %t, got %t", id, wantPresent, ok)
+		}
+	}
+}
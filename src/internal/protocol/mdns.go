@@ -0,0 +1,112 @@
+package protocol
+
+import (
+	"context"
+	"ocf/internal/common"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultMDNSServiceName = "_ocf._udp"
+	mdnsConnectTimeout     = 10 * time.Second
+	// mdnsTagValue keeps LAN peers ahead of random peers when the connection
+	// manager trims.
+	mdnsTagValue = 50
+)
+
+// mdnsNotifee connects to peers announced on the local network segment. The
+// connection handler in newHost then adds them to the node table.
+type mdnsNotifee struct {
+	h host.Host
+}
+
+func (n *mdnsNotifee) HandlePeerFound(pi peer.AddrInfo) {
+	if pi.ID == n.h.ID() {
+		return
+	}
+	if !PeerAllowed(pi.ID.String()) {
+		common.Logger.Debugf("Ignoring mDNS peer %s denied by access policy", pi.ID)
+		return
+	}
+	// The announcement is unauthenticated; only keep the addresses the
+	// connection gater would let us dial.
+	ap := getAccessPolicy()
+	var addrs []multiaddr.Multiaddr
+	for _, addr := range pi.Addrs {
+		if ap.addrAllowed(addr) {
+			addrs = append(addrs, addr)
+		}
+	}
+	if len(addrs) == 0 {
+		common.Logger.Debugf("Ignoring mDNS peer %s without an allowed address", pi.ID)
+		return
+	}
+	pi.Addrs = addrs
+	n.h.Peerstore().AddAddrs(pi.ID, pi.Addrs, peerstore.TempAddrTTL)
+	n.h.ConnManager().TagPeer(pi.ID, "mdns", mdnsTagValue)
+	if n.h.Network().Connectedness(pi.ID) == network.Connected {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mdnsConnectTimeout)
+	defer cancel()
+	if err := n.h.Connect(ctx, pi); err != nil {
+		common.Logger.With("peer", pi.ID).Debugf("Failed to connect to mDNS peer: %v", err)
+		return
+	}
+	common.Logger.Infof("Connected to peer %s discovered via mDNS", pi.ID)
+}
+
+// StartMDNS starts local network discovery for the default node.
+func StartMDNS() {
+	DefaultNode().StartMDNS()
+}
+
+// StartMDNS starts local network discovery when mdns.enabled is set. Only
+// nodes using the same mdns.service_name find each other.
+func (n *Node) StartMDNS() {
+	if !viper.GetBool("mdns.enabled") {
+		return
+	}
+	n.mdnsLock.Lock()
+	defer n.mdnsLock.Unlock()
+	if n.mdns != nil {
+		return
+	}
+	name := viper.GetString("mdns.service_name")
+	if name == "" {
+		name = defaultMDNSServiceName
+	}
+	svc := mdns.NewMdnsService(n.host, name, &mdnsNotifee{h: n.host})
+	if err := svc.Start(); err != nil {
+		common.ReportError(err, "Error while starting mDNS discovery")
+		return
+	}
+	n.mdns = svc
+	common.Logger.Infof("mDNS discovery started (service %s)", name)
+}
+
+// StopMDNS stops local network discovery of the default node.
+func StopMDNS() {
+	std.StopMDNS()
+}
+
+// StopMDNS stops local network discovery if it is running.
+func (n *Node) StopMDNS() {
+	n.mdnsLock.Lock()
+	defer n.mdnsLock.Unlock()
+	if n.mdns == nil {
+		return
+	}
+	if err := n.mdns.Close(); err != nil {
+		common.Logger.Warn("Error while stopping mDNS discovery: ", err)
+	}
+	n.mdns = nil
+}
@@ -0,0 +1,55 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+)
+
+func TestMDNSNotifeeConnects(t *testing.T) {
+	mn, err := mocknet.FullMeshLinked(2)
+	if err != nil {
+		t.Fatalf("mocknet failed: %v", err)
+	}
+	defer mn.Close()
+	hosts := mn.Hosts()
+	a, b := hosts[0], hosts[1]
+
+	n := &mdnsNotifee{h: a}
+	n.HandlePeerFound(peer.AddrInfo{ID: a.ID(), Addrs: a.Addrs()})
+	if len(a.Network().Conns()) != 0 {
+		t.Fatalf("expected the notifee to ignore itself")
+	}
+
+	n.HandlePeerFound(peer.AddrInfo{ID: b.ID(), Addrs: b.Addrs()})
+	if a.Network().Connectedness(b.ID()) != network.Connected {
+		t.Fatalf("expected discovered peer to be connected")
+	}
+}
+
+func TestMDNSNotifeeFiltersAddresses(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	ap := getAccessPolicy()
+	t.Cleanup(func() { ap.set(AccessList{}) })
+	mn, err := mocknet.FullMeshLinked(2)
+	if err != nil {
+		t.Fatalf("mocknet failed: %v", err)
+	}
+	defer mn.Close()
+	hosts := mn.Hosts()
+	a, b := hosts[0], hosts[1]
+	if err := ap.set(AccessList{DenyCIDRs: []string{"::/0", "0.0.0.0/0"}}); err != nil {
+		t.Fatalf("set failed: %v", err)
+	}
+
+	n := &mdnsNotifee{h: a}
+	n.HandlePeerFound(peer.AddrInfo{ID: b.ID(), Addrs: b.Addrs()})
+	if a.Network().Connectedness(b.ID()) == network.Connected {
+		t.Fatalf("expected a peer announcing only denied addresses to be ignored")
+	}
+	if len(a.Peerstore().Addrs(b.ID())) != 0 {
+		t.Fatalf("expected denied addresses to stay out of the peerstore")
+	}
+}
@@ -0,0 +1,377 @@
+package protocol
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"ocf/internal/common"
+	"strings"
+	"sync"
+
+	crdt "ocf/internal/protocol/go-ds-crdt"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/spf13/viper"
+)
+
+// Namespaces of the replicated keyspace. Every record type lives under its
+// own prefix, so hooks and validators only ever see the records they know
+// how to read.
+const (
+	PeersNamespace         = "/peers"
+	ServicesNamespace      = "/services"
+	ModelsNamespace        = "/models"
+	ConfigNamespace        = "/config"
+	AnnouncementsNamespace = "/announcements"
+)
+
+// maxRecordSize bounds the values accepted by the default validator.
+const maxRecordSize = 64 << 10
+
+var (
+	ErrUnknownNamespace = errors.New("unknown namespace")
+	ErrInvalidRecord    = errors.New("invalid record")
+)
+
+// NamespaceHandler holds the callbacks for one namespace. Keys passed to
+// them are relative to the namespace, e.g. "/<peerID>" for /peers/<peerID>.
+// Validate runs before local writes and before the hooks; records it
+// rejects are neither written nor applied. Any callback may be nil.
+//
+// Schema is the version of the records this node writes to the namespace
+// and MinSchema the oldest it still accepts; zero means 1 for both.
+type NamespaceHandler struct {
+	Schema    int
+	MinSchema int
+	Validate  func(key ds.Key, rec Record) error
+	Put       func(key ds.Key, rec Record)
+	Delete    func(key ds.Key)
+}
+
+type namespaceRegistry struct {
+	mu       sync.RWMutex
+	handlers map[string]NamespaceHandler
+}
+
+var namespaces = &namespaceRegistry{handlers: make(map[string]NamespaceHandler)}
+
+func init() {
+	// Peer records are applied to the node table by the node receiving
+	// them (see Node.dispatchPut); the handler only validates them.
+	namespaces.handlers[PeersNamespace] = NamespaceHandler{
+		Schema:   PeerSchemaVersion,
+		Validate: validatePeerRecord,
+	}
+	for _, ns := range []string{ServicesNamespace, ModelsNamespace, ConfigNamespace, AnnouncementsNamespace} {
+		namespaces.handlers[ns] = NamespaceHandler{Validate: validateJSONRecord}
+	}
+}
+
+// RegisterNamespace installs the handler for ns, replacing any previous
+// one. Nil callbacks and zero versions in h keep the ones already
+// registered.
+func RegisterNamespace(ns string, h NamespaceHandler) {
+	ns = ds.NewKey(ns).String()
+	namespaces.mu.Lock()
+	defer namespaces.mu.Unlock()
+	prev := namespaces.handlers[ns]
+	if h.Schema == 0 {
+		h.Schema = prev.Schema
+	}
+	if h.MinSchema == 0 {
+		h.MinSchema = prev.MinSchema
+	}
+	if h.Validate == nil {
+		h.Validate = prev.Validate
+	}
+	if h.Put == nil {
+		h.Put = prev.Put
+	}
+	if h.Delete == nil {
+		h.Delete = prev.Delete
+	}
+	namespaces.handlers[ns] = h
+}
+
+func namespaceHandler(ns string) (NamespaceHandler, bool) {
+	namespaces.mu.RLock()
+	defer namespaces.mu.RUnlock()
+	h, ok := namespaces.handlers[ns]
+	return h, ok
+}
+
+// splitNamespace splits a store key into its namespace and the key within
+// it. Single-component keys that are not a namespace are peer records
+// written by nodes that predate namespaces.
+func splitNamespace(k ds.Key) (string, ds.Key) {
+	parts := k.Namespaces()
+	if len(parts) == 0 {
+		return "", k
+	}
+	ns := "/" + parts[0]
+	if _, ok := namespaceHandler(ns); ok {
+		if len(parts) == 1 {
+			return ns, ds.NewKey("")
+		}
+		return ns, ds.KeyWithNamespaces(parts[1:])
+	}
+	if len(parts) == 1 {
+		return PeersNamespace, k
+	}
+	return "", k
+}
+
+// RecordKey returns the store key of name within ns.
+func RecordKey(ns, name string) ds.Key {
+	return ds.NewKey(ns).Child(ds.NewKey(name))
+}
+
+func peerRecordKey(id string) ds.Key {
+	return RecordKey(PeersNamespace, id)
+}
+
+// legacyPeerKeys reports whether peer records are also written under the
+// bare peer ID, where nodes that predate namespaces look for them.
+func legacyPeerKeys() bool {
+	return viper.GetBool("crdt.legacy_peer_keys")
+}
+
+// dispatchPut routes a CRDT put to the handler of its namespace.
+func dispatchPut(k ds.Key, v []byte) {
+	std.dispatchPut(k, v)
+}
+
+// dispatchPut routes a CRDT put to the handler of its namespace. Peer
+// records are first applied to the node table of n.
+func (n *Node) dispatchPut(k ds.Key, v []byte) {
+	ns, rel := splitNamespace(k)
+	h, ok := namespaceHandler(ns)
+	if !ok {
+		common.Logger.Debugf("Ignoring record [%s] outside of any known namespace", k)
+		return
+	}
+	rec, err := h.open(ns, v)
+	if err == nil && h.Validate != nil {
+		err = h.Validate(rel, rec)
+	}
+	if errors.Is(err, ErrUnsupportedSchema) {
+		common.Logger.Debugf("Ignoring record [%s]: %v", k, err)
+		return
+	}
+	if err != nil {
+		common.Logger.Warnf("Ignoring record [%s]: %v", k, err)
+		return
+	}
+	if ns == PeersNamespace {
+		n.applyPeerRecord(rel, rec)
+	}
+	if h.Put != nil {
+		h.Put(rel, rec)
+	}
+}
+
+// dispatchDelete routes a CRDT delete to the handler of its namespace.
+func dispatchDelete(k ds.Key) {
+	std.dispatchDelete(k)
+}
+
+// dispatchDelete routes a CRDT delete to the handler of its namespace.
+// Peer records are first removed from the node table of n.
+func (n *Node) dispatchDelete(k ds.Key) {
+	ns, rel := splitNamespace(k)
+	h, ok := namespaceHandler(ns)
+	if !ok {
+		return
+	}
+	if ns == PeersNamespace {
+		n.removePeerRecord(rel)
+	}
+	if h.Delete != nil {
+		h.Delete(rel)
+	}
+}
+
+// PutRecord validates value and writes it as name within ns, wrapped in an
+// envelope carrying the namespace's schema version.
+func PutRecord(ctx context.Context, ns, name string, value []byte) error {
+	store, _ := GetCRDTStore()
+	return putRecord(ctx, store, ns, name, value)
+}
+
+func putRecord(ctx context.Context, store *crdt.Datastore, ns, name string, value []byte) error {
+	ns = ds.NewKey(ns).String()
+	h, ok := namespaceHandler(ns)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownNamespace, ns)
+	}
+	rec := Record{Schema: h.schema(), Data: value}
+	if h.Validate != nil {
+		if err := h.Validate(ds.NewKey(name), rec); err != nil {
+			return err
+		}
+	}
+	wrapped, err := wrapRecord(rec)
+	if err != nil {
+		return err
+	}
+	return store.Put(ctx, RecordKey(ns, name), wrapped)
+}
+
+// GetRecord returns the value of name within ns.
+func GetRecord(ctx context.Context, ns, name string) (Record, error) {
+	store, _ := GetCRDTStore()
+	value, err := store.Get(ctx, RecordKey(ns, name))
+	if err != nil {
+		return Record{}, err
+	}
+	return unwrapRecord(value)
+}
+
+// DeleteRecord removes name from ns.
+func DeleteRecord(ctx context.Context, ns, name string) error {
+	store, _ := GetCRDTStore()
+	return store.Delete(ctx, RecordKey(ns, name))
+}
+
+// ListRecords returns the records of ns, keyed by their name within it.
+// Records this node cannot read are left out.
+func ListRecords(ctx context.Context, ns string) (map[string]Record, error) {
+	store, _ := GetCRDTStore()
+	return listRecords(ctx, store, ns)
+}
+
+func listRecords(ctx context.Context, store *crdt.Datastore, ns string) (map[string]Record, error) {
+	ns = ds.NewKey(ns).String()
+	h, ok := namespaceHandler(ns)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownNamespace, ns)
+	}
+	results, err := store.Query(ctx, query.Query{Prefix: ns})
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	records := make(map[string]Record)
+	for r := range results.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		got, rel := splitNamespace(ds.NewKey(r.Key))
+		if got != ns {
+			continue
+		}
+		if rec, err := h.open(ns, r.Value); err == nil {
+			records[strings.TrimPrefix(rel.String(), "/")] = rec
+		}
+	}
+	return records, nil
+}
+
+// peerRecords returns the peer records in the store keyed by peer ID,
+// including those under legacy keys. A namespaced record wins over a
+// legacy one for the same peer.
+func peerRecords(ctx context.Context, store *crdt.Datastore) (map[string][]byte, error) {
+	results, err := store.Query(ctx, query.Query{})
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+	records := make(map[string][]byte)
+	for r := range results.Next() {
+		if r.Error != nil {
+			return nil, r.Error
+		}
+		k := ds.NewKey(r.Key)
+		ns, rel := splitNamespace(k)
+		if ns != PeersNamespace {
+			continue
+		}
+		id := strings.Trim(rel.String(), "/")
+		if _, ok := records[id]; ok && len(k.Namespaces()) == 1 {
+			continue
+		}
+		records[id] = r.Value
+	}
+	return records, nil
+}
+
+// putPeerRecord publishes the record of peer id in the store of n. The
+// legacy copy is only written while crdt.legacy_peer_keys is set and the
+// node table still lists nodes that predate namespaces, i.e. while
+// GetVersionDistribution counts schema 1 records. Once the last of them is
+// gone the copy stops being written, and the setting can be removed.
+func (n *Node) putPeerRecord(ctx context.Context, id string, value []byte) error {
+	legacy := legacyPeerKeys() && n.table.hasLegacyPeers()
+	if err := putPeerRecord(ctx, n.store, id, value, legacy); err != nil {
+		return err
+	}
+	n.legacyAnnounced.Store(legacy)
+	return nil
+}
+
+// putPeerRecord publishes the record of peer id, and its legacy copy when
+// legacy is set. The legacy copy stays bare Peer JSON, the only format older
+// nodes read.
+func putPeerRecord(ctx context.Context, store *crdt.Datastore, id string, value []byte, legacy bool) error {
+	if err := putRecord(ctx, store, PeersNamespace, id, value); err != nil {
+		return err
+	}
+	if legacy {
+		return store.Put(ctx, ds.NewKey(id), value)
+	}
+	return nil
+}
+
+// getPeerRecord reads the record of peer id, falling back to its legacy key.
+func getPeerRecord(ctx context.Context, store *crdt.Datastore, id string) ([]byte, error) {
+	value, err := store.Get(ctx, peerRecordKey(id))
+	if errors.Is(err, ds.ErrNotFound) {
+		return store.Get(ctx, ds.NewKey(id))
+	}
+	return value, err
+}
+
+// deletePeerRecord tombstones the record of peer id under both keys.
+func deletePeerRecord(ctx context.Context, store *crdt.Datastore, id string) error {
+	if err := store.Delete(ctx, peerRecordKey(id)); err != nil {
+		return err
+	}
+	return store.Delete(ctx, ds.NewKey(id))
+}
+
+// validatePeerRecord accepts Peer JSON whose ID, when set, matches its key.
+func validatePeerRecord(key ds.Key, rec Record) error {
+	id := strings.Trim(key.String(), "/")
+	if id == "" || strings.Contains(id, "/") {
+		return fmt.Errorf("%w: bad peer key %q", ErrInvalidRecord, key)
+	}
+	if len(rec.Data) > maxRecordSize {
+		return fmt.Errorf("%w: %d bytes exceeds the limit", ErrInvalidRecord, len(rec.Data))
+	}
+	var peer Peer
+	if err := json.Unmarshal(rec.Data, &peer); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRecord, err)
+	}
+	if peer.ID != "" && peer.ID != id {
+		return fmt.Errorf("%w: record of %s stored under %s", ErrInvalidRecord, peer.ID, id)
+	}
+	return nil
+}
+
+// validateJSONRecord is the default validator: a named JSON object of
+// bounded size.
+func validateJSONRecord(key ds.Key, rec Record) error {
+	if strings.Trim(key.String(), "/") == "" {
+		return fmt.Errorf("%w: empty key", ErrInvalidRecord)
+	}
+	if len(rec.Data) > maxRecordSize {
+		return fmt.Errorf("%w: %d bytes exceeds the limit", ErrInvalidRecord, len(rec.Data))
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(rec.Data, &obj); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRecord, err)
+	}
+	return nil
+}
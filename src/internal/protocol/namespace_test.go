@@ -0,0 +1,167 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/spf13/viper"
+)
+
+func TestSplitNamespace(t *testing.T) {
+	cases := []struct {
+		key, ns, rel string
+	}{
+		{"/peers/QmPeer", PeersNamespace, "/QmPeer"},
+		{"/QmPeer", PeersNamespace, "/QmPeer"},
+		{"/models/llama/v1", ModelsNamespace, "/llama/v1"},
+		{"/config/routing", ConfigNamespace, "/routing"},
+		{"/unknown/thing", "", "/unknown/thing"},
+	}
+	for _, c := range cases {
+		ns, rel := splitNamespace(ds.NewKey(c.key))
+		if ns != c.ns || rel.String() != c.rel {
+			t.Fatalf("splitNamespace(%s) = %q, %q; want %q, %q", c.key, ns, rel, c.ns, c.rel)
+		}
+	}
+}
+
+func TestDispatchPerNamespace(t *testing.T) {
+	const ns = "/testns"
+	var puts, deletes []string
+	RegisterNamespace(ns, NamespaceHandler{
+		Validate: validateJSONRecord,
+		Put:      func(k ds.Key, _ Record) { puts = append(puts, k.String()) },
+		Delete:   func(k ds.Key) { deletes = append(deletes, k.String()) },
+	})
+	defer func() {
+		namespaces.mu.Lock()
+		delete(namespaces.handlers, ns)
+		namespaces.mu.Unlock()
+	}()
+
+	dispatchPut(ds.NewKey("/testns/a"), []byte(`{"x":1}`))
+	dispatchPut(ds.NewKey("/testns/b"), []byte("not json"))
+	dispatchPut(ds.NewKey("/elsewhere/c"), []byte(`{}`))
+	dispatchDelete(ds.NewKey("/testns/a"))
+	if len(puts) != 1 || puts[0] != "/a" {
+		t.Fatalf("expected only the valid record to be applied, got %v", puts)
+	}
+	if len(deletes) != 1 || deletes[0] != "/a" {
+		t.Fatalf("expected one delete for /a, got %v", deletes)
+	}
+}
+
+func TestPutRecordValidation(t *testing.T) {
+	ctx := context.Background()
+	store := newTestCRDT(t, nil)
+
+	if err := putRecord(ctx, store, "/nope", "a", []byte(`{}`)); !errors.Is(err, ErrUnknownNamespace) {
+		t.Fatalf("expected ErrUnknownNamespace, got %v", err)
+	}
+	if err := putRecord(ctx, store, ConfigNamespace, "routing", []byte("[1,2]")); !errors.Is(err, ErrInvalidRecord) {
+		t.Fatalf("expected ErrInvalidRecord for a non-object, got %v", err)
+	}
+	if err := putRecord(ctx, store, PeersNamespace, "QmA", []byte(`{"id":"QmB"}`)); !errors.Is(err, ErrInvalidRecord) {
+		t.Fatalf("expected ErrInvalidRecord for a mismatched peer ID, got %v", err)
+	}
+	if err := putRecord(ctx, store, ConfigNamespace, "routing", []byte(`{"mode":"fast"}`)); err != nil {
+		t.Fatalf("putRecord failed: %v", err)
+	}
+	if err := putRecord(ctx, store, ModelsNamespace, "llama", []byte(`{"size":7}`)); err != nil {
+		t.Fatalf("putRecord failed: %v", err)
+	}
+
+	records, err := listRecords(ctx, store, ConfigNamespace)
+	if err != nil {
+		t.Fatalf("listRecords failed: %v", err)
+	}
+	if len(records) != 1 || string(records["routing"].Data) != `{"mode":"fast"}` {
+		t.Fatalf("unexpected config records: %v", records)
+	}
+}
+
+func TestPeerRecordsLegacyKeys(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	ctx := context.Background()
+	store := newTestCRDT(t, nil)
+
+	if err := putPeerRecord(ctx, store, "QmNew", []byte(`{"id":"QmNew","owner":"new"}`), true); err != nil {
+		t.Fatalf("putPeerRecord failed: %v", err)
+	}
+	for _, k := range []string{"/peers/QmNew", "/QmNew"} {
+		if ok, _ := store.Has(ctx, ds.NewKey(k)); !ok {
+			t.Fatalf("expected %s to be written", k)
+		}
+	}
+	// An older node only ever writes the bare key.
+	if err := store.Put(ctx, ds.NewKey("/QmOld"), []byte(`{"id":"QmOld"}`)); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	// A stale legacy copy must not shadow the namespaced record.
+	if err := store.Put(ctx, ds.NewKey("/QmNew"), []byte(`{"id":"QmNew","owner":"stale"}`)); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := putRecord(ctx, store, ModelsNamespace, "llama", []byte(`{}`)); err != nil {
+		t.Fatalf("putRecord failed: %v", err)
+	}
+
+	records, err := peerRecords(ctx, store)
+	if err != nil {
+		t.Fatalf("peerRecords failed: %v", err)
+	}
+	if len(records) != 2 || records["QmOld"] == nil {
+		t.Fatalf("unexpected peer records: %v", records)
+	}
+	if p, err := decodePeerRecord(records["QmNew"]); err != nil || p.Owner != "new" || p.SchemaVersion != PeerSchemaVersion {
+		t.Fatalf("expected the namespaced record, got %+v (%v)", p, err)
+	}
+	if v, err := getPeerRecord(ctx, store, "QmOld"); err != nil || string(v) != `{"id":"QmOld"}` {
+		t.Fatalf("expected legacy fallback, got %q (%v)", v, err)
+	}
+
+	if err := deletePeerRecord(ctx, store, "QmNew"); err != nil {
+		t.Fatalf("deletePeerRecord failed: %v", err)
+	}
+	if _, err := getPeerRecord(ctx, store, "QmNew"); !errors.Is(err, ds.ErrNotFound) {
+		t.Fatalf("expected both keys removed, got %v", err)
+	}
+}
+
+func TestLegacyPeerKeysOnlyWhileOlderNodesRemain(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	viper.Set("crdt.legacy_peer_keys", true)
+	ctx := context.Background()
+	n := newNode(NodeConfig{})
+	n.store = newTestCRDT(t, nil)
+	hasLegacy := func(id string) bool {
+		ok, _ := n.store.Has(ctx, ds.NewKey(id))
+		return ok
+	}
+
+	if err := n.putPeerRecord(ctx, "QmA", []byte(`{"id":"QmA"}`)); err != nil {
+		t.Fatalf("putPeerRecord failed: %v", err)
+	}
+	if hasLegacy("/QmA") || n.legacyAnnounced.Load() {
+		t.Fatalf("expected no legacy copy without older nodes")
+	}
+
+	n.table.put(ds.NewKey("QmOld"), Peer{ID: "QmOld", SchemaVersion: legacySchemaVersion})
+	if err := n.putPeerRecord(ctx, "QmB", []byte(`{"id":"QmB"}`)); err != nil {
+		t.Fatalf("putPeerRecord failed: %v", err)
+	}
+	if !hasLegacy("/QmB") || !n.legacyAnnounced.Load() {
+		t.Fatalf("expected a legacy copy while an older node is listed")
+	}
+
+	viper.Set("crdt.legacy_peer_keys", false)
+	if err := n.putPeerRecord(ctx, "QmC", []byte(`{"id":"QmC"}`)); err != nil {
+		t.Fatalf("putPeerRecord failed: %v", err)
+	}
+	if hasLegacy("/QmC") {
+		t.Fatalf("expected crdt.legacy_peer_keys=false to disable the legacy copy")
+	}
+}
@@ -0,0 +1,402 @@
+package protocol
+
+import (
+	"context"
+	"errors"
+	"ocf/internal/common"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	crdt "ocf/internal/protocol/go-ds-crdt"
+
+	ipfslite "github.com/hsanjuan/ipfs-lite"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	badger "github.com/ipfs/go-ds-badger"
+	dualdht "github.com/libp2p/go-libp2p-kad-dht/dual"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
+)
+
+const defaultRebroadcastInterval = 5 * time.Second
+
+// NodeConfig configures a Node. Unlike the package-level functions, a Node
+// only reads its own settings from here; process-wide concerns such as the
+// access policy, gossipsub tuning and leases still come from viper.
+type NodeConfig struct {
+	// Mode is the run mode, e.g. "node", "local" or "standalone". The node
+	// uses it to pick its identity file. Which peers it bootstraps from is
+	// set by Bootstraps; the default node derives those from the same mode,
+	// so a standalone default node has none.
+	Mode string
+	// Seed derives the identity key, see loadOrCreateIdentity.
+	Seed int64
+	// Host is used instead of creating one, e.g. a mocknet host in tests.
+	// The node does not close it.
+	Host host.Host
+	// DataDir holds the CRDT database. The CRDT is kept in memory when it
+	// is empty.
+	DataDir string
+	// Persistent restores the node table from a database in DataDir left
+	// by a previous run.
+	Persistent bool
+	// Bootstraps are the peers the CRDT is synced with on start.
+	Bootstraps []peer.AddrInfo
+	// Broadcaster carries CRDT heads to the other replicas. When nil, it is
+	// selected by crdt.broadcaster.
+	Broadcaster crdt.Broadcaster
+	// RebroadcastInterval defaults to 5s.
+	RebroadcastInterval time.Duration
+	// Heartbeats publishes signed liveness heartbeats.
+	Heartbeats bool
+	// LegacyPing publishes the pings understood by nodes predating
+	// heartbeats.
+	LegacyPing bool
+}
+
+// Node is one member of the network: a libp2p host with its DHT, the CRDT
+// store replicating the node table, the local view of that table, and the
+// registrar announcing our own entry. Several nodes can live in the same
+// process.
+type Node struct {
+	cfg       NodeConfig
+	host      host.Host
+	dht       *dualdht.DHT
+	ownsHost  bool
+	ipfs      *ipfslite.Peer
+	psub      *pubsub.PubSub
+	datastore ds.Batching
+	store     *crdt.Datastore
+	table     *nodeTable
+	registrar *Registrar
+
+	// draining is set once the node starts shutting down.
+	draining atomic.Bool
+
+	liveness       *livenessBook
+	heartbeatSeq   atomic.Uint64
+	heartbeatTopic atomic.Pointer[pubsub.Topic]
+	// inFlight reports the number of requests being served, carried in
+	// heartbeats. It is set by the HTTP server.
+	inFlight atomic.Pointer[func() int64]
+
+	// advertisedKey is the set of services last provided in the DHT, at
+	// advertisedTime.
+	advertiseLock  sync.Mutex
+	advertisedKey  string
+	advertisedTime time.Time
+
+	// legacyAnnounced is whether our last peer record was also written
+	// under its legacy key.
+	legacyAnnounced atomic.Bool
+
+	// checkpointOnce and compactOnce start the CRDT maintenance loops.
+	checkpointOnce sync.Once
+	compactOnce    sync.Once
+
+	// mdns is the local network discovery service, while it runs.
+	mdnsLock sync.Mutex
+	mdns     mdns.Service
+
+	// ctx is cancelled by Close; background tracks the goroutines that
+	// must finish before the node is released.
+	ctx                 context.Context
+	background          sync.WaitGroup
+	stop                context.CancelFunc
+	cancelSubscriptions context.CancelFunc
+}
+
+// std is the node behind the package-level functions. Its host and CRDT
+// store are created on first use by GetP2PNode and GetCRDTStore, from the
+// viper settings.
+var std = newNode(NodeConfig{})
+
+// DefaultNode returns the node configured through viper, starting it if
+// needed.
+func DefaultNode() *Node {
+	GetCRDTStore()
+	return std
+}
+
+func newNode(cfg NodeConfig) *Node {
+	if cfg.RebroadcastInterval <= 0 {
+		cfg.RebroadcastInterval = defaultRebroadcastInterval
+	}
+	n := &Node{cfg: cfg, table: newNodeTable(), liveness: newLivenessBook()}
+	n.registrar = newRegistrar(n)
+	return n
+}
+
+// NewNode creates a node and starts its CRDT store. Close releases it.
+func NewNode(ctx context.Context, cfg NodeConfig) (*Node, error) {
+	n := newNode(cfg)
+	if err := n.setupHost(ctx, nil); err != nil {
+		return nil, err
+	}
+	if err := n.start(ctx); err != nil {
+		n.Close()
+		return nil, err
+	}
+	return n, nil
+}
+
+// setupHost creates the host and DHT, or wraps the configured host in a
+// DHT, and keeps the node table in sync with connections.
+func (n *Node) setupHost(ctx context.Context, dhtStore ds.Batching) error {
+	if n.cfg.Host != nil {
+		d, err := newDHT(ctx, n.cfg.Host, dhtStore)
+		if err != nil {
+			return err
+		}
+		n.host, n.dht = n.cfg.Host, d
+	} else {
+		h, d, err := newHost(ctx, n.cfg.Mode, n.cfg.Seed, dhtStore)
+		if err != nil {
+			return err
+		}
+		n.host, n.dht, n.ownsHost = h, d, true
+	}
+	n.watchConnections()
+	return nil
+}
+
+// watchConnections marks peers as connected or disconnected in the node
+// table as soon as the host sees it.
+func (n *Node) watchConnections() {
+	n.host.Network().Notify(&network.NotifyBundle{
+		ConnectedF: func(nw network.Network, c network.Conn) {
+			common.Logger.Info("Connected to peer: ", c.RemotePeer(), " Total connections: ", len(nw.Conns()))
+			// Tell the new peer we are alive; our full record reaches it
+			// through CRDT sync, so nothing is written here.
+			go n.PublishHeartbeat()
+
+			// Mark peer as connected in node table immediately
+			go func(pid peer.ID) {
+				// Avoid updating self
+				if pid == n.host.ID() {
+					return
+				}
+				created := n.table.refresh(pid.String(), func(p *Peer) {
+					p.Connected = true
+					p.LastSeen = time.Now().Unix()
+				})
+				if created {
+					common.Logger.Infof("Adding peer: [%s] triggered by new connection", pid.String())
+				} else {
+					common.Logger.Infof("Updating peer: [%s] triggered by new connection", pid.String())
+				}
+			}(c.RemotePeer())
+		},
+		DisconnectedF: func(nw network.Network, c network.Conn) {
+			common.Logger.Info("Disconnected from peer: ", c.RemotePeer(), " Total connections: ", len(nw.Conns()))
+			// Mark peer as disconnected in node table immediately
+			go func(pid peer.ID) {
+				if pid == n.host.ID() {
+					return
+				}
+				common.Logger.Infof("Removing peer: [%s] triggered by disconnection", pid.String())
+				// keep LastSeen as last known good; do not bump here
+				n.table.refresh(pid.String(), func(p *Peer) {
+					p.Connected = false
+				})
+			}(c.RemotePeer())
+		},
+	})
+}
+
+// start opens the CRDT store and joins the pubsub topics.
+func (n *Node) start(parent context.Context) error {
+	if n.host == nil {
+		return errors.New("node has no host")
+	}
+	ctx, stop := context.WithCancel(parent)
+	n.ctx, n.stop = ctx, stop
+	h := n.host
+
+	persisted := false
+	if n.cfg.DataDir != "" {
+		persisted = n.cfg.Persistent && hasPersistedState(n.cfg.DataDir)
+		common.Logger.Info("Creating CRDT store, using dbpath: " + n.cfg.DataDir)
+		store, err := badger.NewDatastore(n.cfg.DataDir, &badger.DefaultOptions)
+		if err != nil {
+			return err
+		}
+		n.datastore = store
+	} else {
+		n.datastore = dssync.MutexWrap(ds.NewMapDatastore())
+	}
+
+	var err error
+	n.ipfs, err = ipfslite.New(ctx, n.datastore, nil, h, n.dht, nil)
+	if err != nil {
+		return err
+	}
+	n.psub, err = newPubSub(ctx, h)
+	if err != nil {
+		return err
+	}
+	if err := n.joinPingTopic(ctx); err != nil {
+		return err
+	}
+	if n.cfg.Heartbeats {
+		if err := n.startHeartbeats(ctx); err != nil {
+			return err
+		}
+	}
+
+	opts := crdt.DefaultOptions()
+	opts.Logger = common.Logger
+	opts.RebroadcastInterval = n.cfg.RebroadcastInterval
+	opts.PutHook = n.dispatchPut
+	opts.DeleteHook = n.dispatchDelete
+
+	bootstraps := n.cfg.Bootstraps
+	protectBootstraps(h, bootstraps)
+	psubCtx, pcancel := context.WithCancel(ctx)
+	n.cancelSubscriptions = pcancel
+	broadcaster := n.cfg.Broadcaster
+	if broadcaster == nil {
+		broadcaster, err = newBroadcaster(psubCtx, n.psub, h)
+		if err != nil {
+			return err
+		}
+	}
+	if !persisted {
+		opts.SnapshotSource = snapshotSource(h, bootstraps)
+	}
+
+	n.store, err = crdt.New(n.datastore, ds.NewKey(pubsubKey), n.ipfs, broadcaster, opts)
+	if err != nil {
+		return err
+	}
+	if persisted {
+		go restorePersistedState(ctx, n.store, h, n.table)
+	}
+	registerSnapshotHandler(h, n.store)
+	n.ipfs.Bootstrap(bootstraps)
+	common.Logger.Info("Mode: ", n.cfg.Mode)
+	common.Logger.Info("Peer ID: ", h.ID().String())
+	common.Logger.Info("Listen Addr: ", h.Addrs())
+
+	n.startTombstoneCompactor()
+	n.startCheckpointing()
+	return nil
+}
+
+// joinPingTopic subscribes to the ping topic, which marks every peer we
+// hear from as alive, and publishes pings when LegacyPing is set.
+func (n *Node) joinPingTopic(ctx context.Context) error {
+	topic, err := n.psub.Join(pubsubNet)
+	if err != nil {
+		return err
+	}
+	netSubs, err := topic.Subscribe()
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			msg, err := netSubs.Next(ctx)
+			if err != nil {
+				common.Logger.Debug("Ping subscription closed: ", err)
+				return
+			}
+			n.host.ConnManager().TagPeer(msg.ReceivedFrom, "keep", 100)
+			// Update LastSeen when we receive a message from a peer
+			created := n.table.refresh(msg.ReceivedFrom.String(), func(p *Peer) {
+				p.LastSeen = time.Now().Unix()
+				p.Connected = true
+			})
+			if created {
+				common.Logger.Infof("Adding peer: [%s] triggered by msg received", msg.ReceivedFrom.String())
+			} else {
+				common.Logger.Infof("Updating peer: [%s] triggered by msg received", msg.ReceivedFrom.String())
+			}
+		}
+	}()
+
+	if n.cfg.LegacyPing {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				default:
+					if err := topic.Publish(ctx, []byte(pingMessage)); err != nil {
+						common.Logger.Warn("Error while publishing ping: ", err)
+					}
+					time.Sleep(20 * time.Second)
+				}
+			}
+		}()
+	}
+	return nil
+}
+
+// Bootstrap connects the DAG service to peers so missing blocks can be
+// fetched from them.
+func (n *Node) Bootstrap(peers []peer.AddrInfo) {
+	if n.ipfs == nil {
+		common.Logger.Warn("Bootstrap requested but CRDT/IPFS not initialized yet; skipping")
+		return
+	}
+	n.ipfs.Bootstrap(peers)
+}
+
+// ID returns the peer ID of the node.
+func (n *Node) ID() peer.ID {
+	return n.host.ID()
+}
+
+// Host returns the libp2p host of the node.
+func (n *Node) Host() host.Host {
+	return n.host
+}
+
+// DHT returns the DHT of the node.
+func (n *Node) DHT() *dualdht.DHT {
+	return n.dht
+}
+
+// Store returns the CRDT store of the node, or nil before it is started.
+func (n *Node) Store() *crdt.Datastore {
+	return n.store
+}
+
+// Registrar returns the registrar announcing this node.
+func (n *Node) Registrar() *Registrar {
+	return n.registrar
+}
+
+// Close stops the node and releases its store. A host passed in the
+// config is left open.
+func (n *Node) Close() error {
+	var errs []error
+	n.StopMDNS()
+	if n.cancelSubscriptions != nil {
+		n.cancelSubscriptions()
+	}
+	// The background loops use the store; let them finish first.
+	if n.stop != nil {
+		n.stop()
+	}
+	n.background.Wait()
+	if n.store != nil {
+		errs = append(errs, n.store.Close())
+	}
+	if n.datastore != nil {
+		errs = append(errs, n.datastore.Close())
+	}
+	if n.dht != nil {
+		errs = append(errs, n.dht.Close())
+	}
+	if n.ownsHost {
+		errs = append(errs, n.host.Close())
+	}
+	return errors.Join(errs...)
+}
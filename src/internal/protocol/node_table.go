@@ -5,21 +5,19 @@ import (
 	"encoding/json"
 	"errors"
 	"ocf/internal/common"
-	"ocf/internal/platform"
-	"ocf/internal/wallet"
-	"sync"
+	"strings"
 	"time"
 
 	ds "github.com/ipfs/go-datastore"
 	"github.com/spf13/viper"
 )
 
-var dntOnce sync.Once
-var myself Peer
-
 const (
 	CONNECTED    string = "connected"
 	DISCONNECTED string = "disconnected"
+	// DRAINING marks a peer or service that is shutting down: it finishes
+	// in-flight requests but must not be sent new ones.
+	DRAINING string = "draining"
 )
 
 type Service struct {
@@ -48,9 +46,23 @@ type Peer struct {
 	LastSeen          int64               `json:"last_seen"`
 	Version           string              `json:"version"`
 	PublicAddress     string              `json:"public_address"`
+	Addrs             []string            `json:"addrs,omitempty"` // announced multiaddrs
+	Reachability      string              `json:"reachability,omitempty"`
 	Hardware          common.HardwareSpec `json:"hardware"`
 	Connected         bool                `json:"connected"`
 	Load              []int               `json:"load"`
+	// LeaseExpires is when the owner's claim on this entry runs out (unix
+	// seconds). Owners renew it; everyone else treats the entry as absent
+	// once it has expired.
+	LeaseExpires int64 `json:"lease_expires,omitempty"`
+	// OwnerAttestation proves that Owner controls this peer ID. OwnerVerified
+	// is computed locally when a record is received and never leaves this
+	// node.
+	OwnerAttestation *OwnerAttestation `json:"owner_attestation,omitempty"`
+	OwnerVerified    bool              `json:"-"`
+	// SchemaVersion is the version of the record this entry was read from,
+	// taken from its envelope rather than from the record itself.
+	SchemaVersion int `json:"schema_version,omitempty"`
 }
 
 type PeerWithStatus struct {
@@ -61,85 +73,160 @@ type PeerWithStatus struct {
 // Node table tracks the nodes and their status in the network.
 type NodeTable map[string]Peer
 
-var dnt *NodeTable
-var tableUpdateSem = make(chan struct{}, 1) // capacity 1 → max 1 goroutine at a time
+// nodeTable is the local view of the network held by a Node.
+type nodeTable struct {
+	sem   chan struct{} // capacity 1 → max 1 goroutine at a time
+	peers NodeTable
+}
+
+func newNodeTable() *nodeTable {
+	return &nodeTable{sem: make(chan struct{}, 1), peers: NodeTable{}}
+}
+
+func (t *nodeTable) lock()   { t.sem <- struct{}{} }
+func (t *nodeTable) unlock() { <-t.sem }
 
 func getNodeTable() *NodeTable {
-	dntOnce.Do(func() {
-		dnt = &NodeTable{}
-	})
-	return dnt
+	return &std.table.peers
 }
 
+// UpdateNodeTable publishes peer as the entry of the default node.
 func UpdateNodeTable(peer Peer) {
+	DefaultNode().UpdateNodeTable(peer)
+}
+
+// UpdateNodeTable publishes peer as this node's entry, merged with the
+// services it already announces.
+func (n *Node) UpdateNodeTable(peer Peer) {
 	ctx := context.Background()
-	host, _ := GetP2PNode(nil)
 	// broadcast the peer to the network
-	store, _ := GetCRDTStore()
-	key := ds.NewKey(host.ID().String())
-	peer.ID = host.ID().String()
+	peer.ID = n.host.ID().String()
+	// latency is local to each observer and never replicated
+	peer.Latency = 0
 	// merge services instead of overwriting
 	// first find the peer in the table if it exists
-	existingPeer, err := GetPeerFromTable(peer.ID)
+	existingPeer, err := n.table.get(peer.ID)
 	if err == nil {
 		peer.Service = append(peer.Service, existingPeer.Service...)
 		// Preserve existing provider if not set in the update
 		if peer.Owner == "" && existingPeer.Owner != "" {
 			peer.Owner = existingPeer.Owner
 		}
+		if peer.OwnerAttestation == nil && existingPeer.Owner == peer.Owner {
+			peer.OwnerAttestation = existingPeer.OwnerAttestation
+		}
 	}
-	if viper.GetString("public-addr") != "" {
-		peer.PublicAddress = viper.GetString("public-addr")
-	}
+	setSelfAddresses(n.host, &peer)
+	setSelfLease(&peer)
 	value, err := json.Marshal(peer)
 	common.ReportError(err, "Error while marshalling peer")
-	if err := store.Put(ctx, key, value); err != nil {
+	if err := n.putPeerRecord(ctx, peer.ID, value); err != nil {
 		common.Logger.Error("Error while updating node table: ", err)
 	}
 }
 
 func MarkSelfAsBootstrap() {
+	DefaultNode().MarkSelfAsBootstrap()
+}
+
+// MarkSelfAsBootstrap announces this node as a bootstrap when it has a
+// public address.
+func (n *Node) MarkSelfAsBootstrap() {
 	if viper.GetString("public-addr") != "" {
 		common.Logger.Info("Registering myself as a bootstrap node")
 		ctx := context.Background()
-		store, _ := GetCRDTStore()
-		host, _ := GetP2PNode(nil)
-		key := ds.NewKey(host.ID().String())
+		key := ds.NewKey(n.host.ID().String())
 		peer := Peer{
-			ID:            host.ID().String(),
-			PublicAddress: viper.GetString("public-addr"),
-			Connected:     true,
+			ID:        n.host.ID().String(),
+			Connected: true,
 		}
+		setSelfAddresses(n.host, &peer)
+		setSelfLease(&peer)
 		value, err := json.Marshal(peer)
-		UpdateNodeTableHook(key, value)
+		n.table.update(key, value)
 		common.ReportError(err, "Error while marshalling peer")
-		if err := store.Put(ctx, key, value); err != nil {
+		if err := n.putPeerRecord(ctx, peer.ID, value); err != nil {
 			common.Logger.Error("Error while registering bootstrap: ", err)
 		}
 	}
 }
 
 func DeleteNodeTable() {
+	DefaultNode().DeleteNodeTable()
+}
+
+// DeleteNodeTable removes this node's entry from the network.
+func (n *Node) DeleteNodeTable() {
 	ctx := context.Background()
-	host, _ := GetP2PNode(nil)
-	// broadcast the peer to the network
-	store, _ := GetCRDTStore()
-	key := ds.NewKey(host.ID().String())
 	common.Logger.Info("Removing myself from the network")
-	if err := store.Delete(ctx, key); err != nil {
+	if err := deletePeerRecord(ctx, n.store, n.host.ID().String()); err != nil {
 		common.Logger.Error("Error while removing myself from the network: ", err)
 	}
 }
 
+// applyPeerRecord merges a replicated peer record into the node table.
+// New peers start out disconnected, which lets the verification procedure
+// intercept ghost peers.
+func (n *Node) applyPeerRecord(k ds.Key, rec Record) {
+	peer, err := peerFromRecord(rec)
+	common.ReportError(err, "Error while unmarshalling peer")
+	id := strings.Trim(k.String(), "/")
+	// Do not update itself
+	if id == n.host.ID().String() {
+		return
+	}
+	p, err := n.table.get(id)
+	verifyPeerOwner(n.host, id, &peer)
+	candidate := peer
+	candidate.ID = id
+	if !PeerRecordAllowed(candidate) {
+		common.Logger.Infof("Ignoring peer: [%s] denied by access policy", candidate.ID)
+		n.table.remove(k)
+		return
+	}
+	if leaseExpired(candidate, time.Now()) {
+		common.Logger.Debugf("Ignoring peer: [%s] whose lease has expired", candidate.ID)
+		n.table.remove(k)
+		return
+	}
+	if err == nil && rec.Schema < p.SchemaVersion && peer.LeaseExpires <= p.LeaseExpires {
+		// the legacy copy of a record we already have in a newer schema
+		return
+	}
+	if err != nil {
+		peer.Connected = false
+		common.Logger.Infof("Adding peer: [%s] triggered by p2p hook", id)
+	} else {
+		peer.Connected = p.Connected
+		common.Logger.Infof("Updating peer: [%s] triggered by p2p hook", id)
+	}
+	n.table.put(k, peer)
+}
+
+// removePeerRecord drops a peer whose record was removed from the CRDT.
+func (n *Node) removePeerRecord(k ds.Key) {
+	common.Logger.Infof("Removed: [%s] triggered by p2p hook", strings.Trim(k.String(), "/"))
+	n.table.remove(k)
+}
+
 func UpdateNodeTableHook(key ds.Key, value []byte) {
-	table := *getNodeTable()
+	std.table.update(key, value)
+}
+
+func (t *nodeTable) update(key ds.Key, value []byte) {
 	var peer Peer
 	err := json.Unmarshal(value, &peer)
 	common.ReportError(err, "Error while unmarshalling peer")
+	t.put(key, peer)
+}
+
+// put stores peer under key. Unlike update it keeps fields that never travel
+// over the wire, such as OwnerVerified.
+func (t *nodeTable) put(key ds.Key, peer Peer) {
 	// Preserve locally computed connectivity status if we already know this peer
-	tableUpdateSem <- struct{}{}
-	defer func() { <-tableUpdateSem }() // Release on exit
-	if existing, ok := table[key.String()]; ok {
+	t.lock()
+	defer t.unlock() // Release on exit
+	if existing, ok := t.peers[key.String()]; ok {
 		// If LastSeen is missing in the update, keep the existing one
 		if peer.LastSeen == 0 {
 			peer.LastSeen = existing.LastSeen
@@ -147,33 +234,74 @@ func UpdateNodeTableHook(key ds.Key, value []byte) {
 	}
 	// Always update LastSeen on any CRDT update we receive for that peer
 	peer.LastSeen = time.Now().Unix()
-	table[key.String()] = peer
+	// Latency is measured from here; whatever the record carries is ignored.
+	peer.Latency = localLatency(key.String())
+	t.peers[key.String()] = peer
+}
+
+// refresh applies liveness news about peerID to its entry, creating one if
+// needed. An existing entry keeps its lease even once it has expired, so a
+// ping or reconnect alone never revives an expired peer; a new entry gets a
+// local lease, so that it ages out unless the peer announces itself. It
+// reports whether the entry was created.
+func (t *nodeTable) refresh(peerID string, apply func(p *Peer)) bool {
+	t.lock()
+	defer t.unlock() // Release on exit
+	key := "/" + peerID
+	p, ok := t.peers[key]
+	if !ok {
+		p = Peer{ID: peerID, LeaseExpires: time.Now().Add(leaseTTL()).Unix()}
+	}
+	apply(&p)
+	t.peers[key] = p
+	return !ok
 }
 
 func DeleteNodeTableHook(key ds.Key) {
-	table := *getNodeTable()
-	tableUpdateSem <- struct{}{}
-	defer func() { <-tableUpdateSem }() // Release on exit
-	delete(table, key.String())
+	std.table.remove(key)
+}
+
+func (t *nodeTable) remove(key ds.Key) {
+	t.lock()
+	defer t.unlock() // Release on exit
+	delete(t.peers, key.String())
 }
 
 func GetPeerFromTable(peerId string) (Peer, error) {
-	table := *getNodeTable()
-	tableUpdateSem <- struct{}{}
-	defer func() { <-tableUpdateSem }() // Release on exit
-	peer, ok := table["/"+peerId]
-	if !ok {
+	return std.table.get(peerId)
+}
+
+// GetPeer returns the entry of peerId in this node's table.
+func (n *Node) GetPeer(peerId string) (Peer, error) {
+	return n.table.get(peerId)
+}
+
+func (t *nodeTable) get(peerId string) (Peer, error) {
+	t.lock()
+	defer t.unlock() // Release on exit
+	peer, ok := t.peers["/"+peerId]
+	if !ok || leaseExpired(peer, time.Now()) {
 		return Peer{}, errors.New("peer not found")
 	}
 	return peer, nil
 }
 
 func GetConnectedPeers() *NodeTable {
+	return std.table.connected()
+}
+
+// GetConnectedPeers returns the peers this node considers connected.
+func (n *Node) GetConnectedPeers() *NodeTable {
+	return n.table.connected()
+}
+
+func (t *nodeTable) connected() *NodeTable {
 	var connected = NodeTable{}
-	tableUpdateSem <- struct{}{}
-	defer func() { <-tableUpdateSem }() // Release on exit
-	for id, p := range *getNodeTable() {
-		if p.Connected {
+	t.lock()
+	defer t.unlock() // Release on exit
+	now := time.Now()
+	for id, p := range t.peers {
+		if p.Connected && !leaseExpired(p, now) {
 			connected[id] = p
 		}
 	}
@@ -181,23 +309,47 @@ func GetConnectedPeers() *NodeTable {
 }
 
 func GetAllPeers() *NodeTable {
+	return std.table.all()
+}
+
+// GetAllPeers returns every live entry of this node's table.
+func (n *Node) GetAllPeers() *NodeTable {
+	return n.table.all()
+}
+
+func (t *nodeTable) all() *NodeTable {
 	var peers = NodeTable{}
-	tableUpdateSem <- struct{}{}
-	defer func() { <-tableUpdateSem }() // Release on exit
-	for id, p := range *getNodeTable() {
-		peers[id] = p
+	t.lock()
+	defer t.unlock() // Release on exit
+	now := time.Now()
+	for id, p := range t.peers {
+		if !leaseExpired(p, now) {
+			peers[id] = p
+		}
 	}
 	return &peers
 }
 
+// setLatency stores the measured latency in the entry of peerID.
+func (t *nodeTable) setLatency(peerID string, rttMs float64) {
+	t.lock()
+	defer t.unlock()
+	key := "/" + peerID
+	if p, ok := t.peers[key]; ok {
+		p.Latency = int(rttMs + 0.5)
+		t.peers[key] = p
+	}
+}
+
 func GetService(name string) (Service, error) {
-	host, _ := GetP2PNode(nil)
-	store, _ := GetCRDTStore()
-	key := ds.NewKey(host.ID().String())
-	value, err := store.Get(context.Background(), key)
+	return DefaultNode().GetService(name)
+}
+
+// GetService returns the service called name that this node provides.
+func (n *Node) GetService(name string) (Service, error) {
+	value, err := getPeerRecord(context.Background(), n.store, n.host.ID().String())
 	common.ReportError(err, "Error while getting peer")
-	var peer Peer
-	err = json.Unmarshal(value, &peer)
+	peer, err := decodePeerRecord(value)
 	common.ReportError(err, "Error while unmarshalling peer")
 	for _, service := range peer.Service {
 		if service.Name == name {
@@ -208,14 +360,24 @@ func GetService(name string) (Service, error) {
 }
 
 func GetAllProviders(serviceName string) ([]Peer, error) {
+	return std.table.providers(serviceName)
+}
+
+// GetAllProviders returns the connected peers of this node's table that
+// serve serviceName.
+func (n *Node) GetAllProviders(serviceName string) ([]Peer, error) {
+	return n.table.providers(serviceName)
+}
+
+func (t *nodeTable) providers(serviceName string) ([]Peer, error) {
 	var providers []Peer
-	table := *getNodeTable()
-	tableUpdateSem <- struct{}{}
-	defer func() { <-tableUpdateSem }() // Release on exit
-	for _, peer := range table {
-		if peer.Connected {
+	t.lock()
+	defer t.unlock() // Release on exit
+	now := time.Now()
+	for _, peer := range t.peers {
+		if peer.Connected && peer.Status != DRAINING && !leaseExpired(peer, now) && PeerRecordAllowed(peer) {
 			for _, service := range peer.Service {
-				if service.Name == serviceName {
+				if service.Name == serviceName && service.Status != DRAINING {
 					providers = append(providers, peer)
 				}
 			}
@@ -227,37 +389,20 @@ func GetAllProviders(serviceName string) ([]Peer, error) {
 	return providers, nil
 }
 
-func InitializeMyself(ownerOverride string) {
-	host, _ := GetP2PNode(nil)
-	ctx := context.Background()
-	store, _ := GetCRDTStore()
-	key := ds.NewKey(host.ID().String())
-	myself = Peer{
-		ID:            host.ID().String(),
-		PublicAddress: viper.GetString("public-addr"),
-		LastSeen:      time.Now().Unix(),
-		Connected:     true,
-	}
-
-	// Add wallet address as provider if available
-	if ownerOverride != "" {
-		myself.Owner = ownerOverride
-		common.Logger.Infof("Using verified wallet account for provider: %s", myself.Owner)
-	} else if account := viper.GetString("wallet.account"); account != "" {
-		myself.Owner = account
-		common.Logger.Infof("Using configured wallet account for provider: %s", myself.Owner)
-	} else if wm, err := wallet.InitializeWallet(); err == nil && wm.WalletExists() {
-		myself.Owner = wm.GetPublicKey()
-		if myself.Owner != "" {
-			common.Logger.Infof("Added wallet address as provider: %s", myself.Owner)
+// hasLegacyPeers reports whether a live entry was read from a legacy record,
+// written by a node that predates namespaces.
+func (t *nodeTable) hasLegacyPeers() bool {
+	t.lock()
+	defer t.unlock() // Release on exit
+	now := time.Now()
+	for _, peer := range t.peers {
+		if peer.SchemaVersion == legacySchemaVersion && !leaseExpired(peer, now) {
+			return true
 		}
 	}
+	return false
+}
 
-	myself.Hardware.GPUs = platform.GetGPUInfo()
-	value, err := json.Marshal(myself)
-	common.ReportError(err, "Error while marshalling peer")
-	err = store.Put(ctx, key, value)
-	if err != nil {
-		common.Logger.Error("Error while initializing myself in the node table: ", err)
-	}
+func InitializeMyself(ownerOverride string) {
+	DefaultNode().registrar.Initialize(ownerOverride)
 }
@@ -32,3 +32,15 @@ func TestDeleteNodeTableHook(t *testing.T) {
 		t.Fatalf("expected peer2 deleted")
 	}
 }
+
+func TestNodeTablePutKeepsOwnerVerified(t *testing.T) {
+	table := newNodeTable()
+	table.put(ds.NewKey("peer3"), Peer{ID: "peer3", Owner: "wallet", OwnerVerified: true})
+	got, err := table.get("peer3")
+	if err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	if got.VerifiedOwner() != "wallet" {
+		t.Fatalf("expected the verified owner to be kept, got %+v", got)
+	}
+}
@@ -0,0 +1,82 @@
+package protocol
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/spf13/viper"
+)
+
+func TestNodesInOneProcess(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	t.Setenv("HOME", t.TempDir())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Connect once the nodes are up, so bitswap sees the connections.
+	mn, err := mocknet.FullMeshLinked(2)
+	if err != nil {
+		t.Fatalf("mocknet failed: %v", err)
+	}
+	defer mn.Close()
+	hosts := mn.Hosts()
+	nodes := make([]*Node, len(hosts))
+	for i, h := range hosts {
+		n, err := NewNode(ctx, NodeConfig{
+			Mode:                "test",
+			Host:                h,
+			RebroadcastInterval: 200 * time.Millisecond,
+		})
+		if err != nil {
+			t.Fatalf("NewNode failed: %v", err)
+		}
+		defer n.Close()
+		nodes[i] = n
+	}
+	if err := mn.ConnectAllButSelf(); err != nil {
+		t.Fatalf("connect failed: %v", err)
+	}
+	provider, consumer := nodes[0], nodes[1]
+	// Every node runs its own CRDT maintenance, not just the first one.
+	for i, n := range nodes {
+		joined := false
+		for _, topic := range n.psub.GetTopics() {
+			joined = joined || topic == checkpointTopic
+		}
+		if !joined {
+			t.Fatalf("node %d did not start checkpointing", i)
+		}
+	}
+
+	provider.Registrar().Initialize("")
+	provider.Registrar().Provide(Service{Name: "llm", Status: CONNECTED, IdentityGroup: []string{"model=m"}})
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		providers, err := consumer.GetAllProviders("llm")
+		if err == nil {
+			if len(providers) != 1 || providers[0].ID != provider.ID().String() {
+				t.Fatalf("unexpected providers %+v", providers)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("consumer never saw the provider: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// Each node keeps its own view; none of this reached the default node.
+	if _, err := provider.GetPeer(provider.ID().String()); err != nil {
+		t.Fatalf("expected the provider to know its own entry: %v", err)
+	}
+	if _, err := consumer.GetPeer(consumer.ID().String()); err == nil {
+		t.Fatalf("expected the consumer not to have announced itself")
+	}
+	if _, err := GetPeerFromTable(provider.ID().String()); err == nil {
+		t.Fatalf("expected the default node table to be untouched")
+	}
+}
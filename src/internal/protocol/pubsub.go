@@ -0,0 +1,229 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"ocf/internal/common"
+	"time"
+
+	crdt "ocf/internal/protocol/go-ds-crdt"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultMaxMessageSize     = 1 << 20
+	defaultCRDTMaxMessageSize = 64 << 10
+	pingMessage               = "ping"
+
+	// Score thresholds used when scoring is on and pubsub.*_threshold is
+	// unset. A single invalid CRDT message (-100) takes a peer past the
+	// first three.
+	defaultGossipThreshold   = -10
+	defaultPublishThreshold  = -50
+	defaultGraylistThreshold = -80
+	// With the default reputation weight of 10, app scores range over
+	// [0, 10] and a peer we know nothing about scores 5. Peer exchange is
+	// only taken from peers with a better than neutral track record.
+	defaultAcceptPXThreshold           = 7
+	defaultOpportunisticGraftThreshold = 5
+)
+
+// newPubSub creates the gossipsub router shared by the CRDT, ping and
+// heartbeat topics, and registers the CRDT and ping topic validators.
+func newPubSub(ctx context.Context, h host.Host) (*pubsub.PubSub, error) {
+	opts, err := pubSubOptions()
+	if err != nil {
+		return nil, err
+	}
+	psub, err := pubsub.NewGossipSub(ctx, h, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := registerTopicValidators(psub, h); err != nil {
+		return nil, err
+	}
+	return psub, nil
+}
+
+// pubSubOptions builds the gossipsub options from the pubsub.* settings.
+func pubSubOptions() ([]pubsub.Option, error) {
+	params, err := gossipSubParams()
+	if err != nil {
+		return nil, err
+	}
+	opts := []pubsub.Option{
+		pubsub.WithGossipSubParams(params),
+		pubsub.WithMaxMessageSize(maxMessageSize()),
+	}
+	if viper.GetBool("pubsub.scoring") {
+		thresholds, err := peerScoreThresholds()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, pubsub.WithPeerScore(peerScoreParams(), thresholds))
+	}
+	return opts, nil
+}
+
+// gossipSubParams returns the gossipsub mesh parameters. Unset values keep
+// our defaults (a wide mesh: D=128, Dlo=16, Dhi=256).
+func gossipSubParams() (pubsub.GossipSubParams, error) {
+	params := pubsub.DefaultGossipSubParams()
+	params.D = 128
+	params.Dlo = 16
+	params.Dhi = 256
+	if v := viper.GetInt("pubsub.d"); v > 0 {
+		params.D = v
+	}
+	if v := viper.GetInt("pubsub.dlo"); v > 0 {
+		params.Dlo = v
+	}
+	if v := viper.GetInt("pubsub.dhi"); v > 0 {
+		params.Dhi = v
+	}
+	if v := viper.GetInt("pubsub.dlazy"); v > 0 {
+		params.Dlazy = v
+	}
+	params.HeartbeatInterval = readDurationSetting("pubsub.heartbeat_interval", params.HeartbeatInterval)
+	// WithGossipSubParams does not check these.
+	if params.Dlo > params.D || params.D > params.Dhi {
+		return params, fmt.Errorf("pubsub: want dlo <= d <= dhi, got %d, %d, %d", params.Dlo, params.D, params.Dhi)
+	}
+	if params.Dscore > params.Dhi {
+		params.Dscore = params.Dhi
+	}
+	if params.Dout >= params.Dlo || params.Dout > params.D/2 {
+		params.Dout = min(params.Dlo-1, params.D/2)
+	}
+	return params, nil
+}
+
+func maxMessageSize() int {
+	if v := viper.GetInt("pubsub.max_message_size"); v > 0 {
+		return v
+	}
+	return defaultMaxMessageSize
+}
+
+func crdtMaxMessageSize() int {
+	if v := viper.GetInt("pubsub.crdt_max_message_size"); v > 0 {
+		return v
+	}
+	return defaultCRDTMaxMessageSize
+}
+
+// peerScoreParams scores peers on their gossip behaviour and on invalid
+// messages delivered on the CRDT and ping topics, plus their reputation.
+func peerScoreParams() *pubsub.PeerScoreParams {
+	topic := func() *pubsub.TopicScoreParams {
+		return &pubsub.TopicScoreParams{
+			SkipAtomicValidation:           true,
+			TopicWeight:                    1,
+			TimeInMeshQuantum:              time.Second,
+			InvalidMessageDeliveriesWeight: -100,
+			InvalidMessageDeliveriesDecay:  pubsub.ScoreParameterDecay(time.Hour),
+		}
+	}
+	weight := viper.GetFloat64("pubsub.reputation_weight")
+	return &pubsub.PeerScoreParams{
+		SkipAtomicValidation: true,
+		Topics: map[string]*pubsub.TopicScoreParams{
+			pubsubTopic: topic(),
+			pubsubNet:   topic(),
+		},
+		AppSpecificScore: func(p peer.ID) float64 {
+			return weight * ReputationScore(p.String())
+		},
+		AppSpecificWeight: 1,
+		// Many providers sit behind the same NAT or cloud range.
+		IPColocationFactorWeight:  0,
+		BehaviourPenaltyWeight:    -10,
+		BehaviourPenaltyThreshold: 6,
+		BehaviourPenaltyDecay:     pubsub.ScoreParameterDecay(10 * time.Minute),
+		DecayInterval:             time.Second,
+		DecayToZero:               0.01,
+		RetainScore:               10 * time.Minute,
+		SeenMsgTTL:                pubsub.TimeCacheDuration,
+	}
+}
+
+// peerScoreThresholds reads the score thresholds below which a peer stops
+// receiving gossip, our publishes, and any attention at all. Unset values
+// keep our defaults.
+func peerScoreThresholds() (*pubsub.PeerScoreThresholds, error) {
+	t := &pubsub.PeerScoreThresholds{
+		GossipThreshold:             readFloatSetting("pubsub.gossip_threshold", defaultGossipThreshold),
+		PublishThreshold:            readFloatSetting("pubsub.publish_threshold", defaultPublishThreshold),
+		GraylistThreshold:           readFloatSetting("pubsub.graylist_threshold", defaultGraylistThreshold),
+		AcceptPXThreshold:           readFloatSetting("pubsub.accept_px_threshold", defaultAcceptPXThreshold),
+		OpportunisticGraftThreshold: readFloatSetting("pubsub.opportunistic_graft_threshold", defaultOpportunisticGraftThreshold),
+	}
+	if t.GossipThreshold > 0 || t.PublishThreshold > t.GossipThreshold || t.GraylistThreshold > t.PublishThreshold {
+		return nil, fmt.Errorf("pubsub: want graylist <= publish <= gossip <= 0, got %v, %v, %v",
+			t.GraylistThreshold, t.PublishThreshold, t.GossipThreshold)
+	}
+	if t.AcceptPXThreshold < 0 || t.OpportunisticGraftThreshold < 0 {
+		return nil, fmt.Errorf("pubsub: accept_px and opportunistic_graft thresholds must not be negative")
+	}
+	return t, nil
+}
+
+// readFloatSetting returns the value of key, or fallback when it is unset.
+// Unlike the other settings, zero is a meaningful threshold.
+func readFloatSetting(key string, fallback float64) float64 {
+	if !viper.IsSet(key) {
+		return fallback
+	}
+	return viper.GetFloat64(key)
+}
+
+// registerTopicValidators drops messages on the CRDT and ping topics that
+// come from denied peers or that could not be valid, before they reach the
+// Datastore or the node table. Rejected messages count against the sender's
+// score and are not forwarded. It must run before the topics are joined.
+func registerTopicValidators(psub *pubsub.PubSub, h host.Host) error {
+	if err := psub.RegisterTopicValidator(pubsubTopic, crdtValidator(h)); err != nil {
+		return err
+	}
+	return psub.RegisterTopicValidator(pubsubNet, pingValidator(h))
+}
+
+func crdtValidator(h host.Host) pubsub.ValidatorEx {
+	return func(ctx context.Context, from peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+		if msg.GetFrom() == h.ID() {
+			return pubsub.ValidationAccept
+		}
+		if !PeerAllowed(msg.GetFrom().String()) {
+			return pubsub.ValidationIgnore
+		}
+		if len(msg.Data) > crdtMaxMessageSize() {
+			common.Logger.With("peer", msg.GetFrom()).Debugf("Rejecting CRDT broadcast of %d bytes", len(msg.Data))
+			return pubsub.ValidationReject
+		}
+		if _, err := crdt.DecodeBroadcast(msg.Data); err != nil {
+			common.Logger.With("peer", msg.GetFrom()).Debugf("Rejecting malformed CRDT broadcast: %v", err)
+			return pubsub.ValidationReject
+		}
+		return pubsub.ValidationAccept
+	}
+}
+
+func pingValidator(h host.Host) pubsub.ValidatorEx {
+	return func(ctx context.Context, from peer.ID, msg *pubsub.Message) pubsub.ValidationResult {
+		if msg.GetFrom() == h.ID() {
+			return pubsub.ValidationAccept
+		}
+		if !PeerAllowed(msg.GetFrom().String()) {
+			return pubsub.ValidationIgnore
+		}
+		if !bytes.Equal(msg.Data, []byte(pingMessage)) {
+			return pubsub.ValidationReject
+		}
+		return pubsub.ValidationAccept
+	}
+}
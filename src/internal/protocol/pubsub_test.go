@@ -0,0 +1,217 @@
+package protocol
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	pb "github.com/ipfs/go-ds-crdt/pb"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pubsubpb "github.com/libp2p/go-libp2p-pubsub/pb"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/multiformats/go-multihash"
+	"github.com/spf13/viper"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestGossipSubParams(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	params, err := gossipSubParams()
+	if err != nil {
+		t.Fatalf("gossipSubParams failed: %v", err)
+	}
+	if params.D != 128 || params.Dlo != 16 || params.Dhi != 256 {
+		t.Fatalf("unexpected defaults D=%d Dlo=%d Dhi=%d", params.D, params.Dlo, params.Dhi)
+	}
+
+	viper.Set("pubsub.d", 6)
+	viper.Set("pubsub.dlo", 4)
+	viper.Set("pubsub.dhi", 12)
+	viper.Set("pubsub.heartbeat_interval", "700ms")
+	params, err = gossipSubParams()
+	if err != nil {
+		t.Fatalf("gossipSubParams failed: %v", err)
+	}
+	if params.D != 6 || params.Dlo != 4 || params.Dhi != 12 || params.HeartbeatInterval != 700*time.Millisecond {
+		t.Fatalf("overrides not applied: %+v", params)
+	}
+	if params.Dout >= params.Dlo || params.Dout > params.D/2 {
+		t.Fatalf("Dout %d is out of range for Dlo=%d D=%d", params.Dout, params.Dlo, params.D)
+	}
+
+	viper.Set("pubsub.dlo", 8)
+	if _, err := gossipSubParams(); err == nil {
+		t.Fatalf("expected dlo > d to be rejected")
+	}
+}
+
+func TestPeerScoreThresholds(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	defaults, err := peerScoreThresholds()
+	if err != nil {
+		t.Fatalf("expected the default thresholds to be valid: %v", err)
+	}
+	if defaults.GossipThreshold >= 0 || defaults.PublishThreshold >= defaults.GossipThreshold || defaults.GraylistThreshold >= defaults.PublishThreshold {
+		t.Fatalf("expected negative, decreasing default thresholds, got %+v", defaults)
+	}
+	// App scores are reputation_weight (10 by default) times a reputation
+	// in [0, 1]; PX must be reachable but not granted to unknown peers.
+	if neutral := 10 * computeReputationScore(&PeerReputation{}); defaults.AcceptPXThreshold <= neutral || defaults.AcceptPXThreshold >= 10 {
+		t.Fatalf("expected the accept PX threshold between %v and 10, got %v", neutral, defaults.AcceptPXThreshold)
+	}
+	viper.Set("pubsub.gossip_threshold", 0.0)
+	viper.Set("pubsub.publish_threshold", 0.0)
+	viper.Set("pubsub.graylist_threshold", 0.0)
+	if zero, err := peerScoreThresholds(); err != nil || zero.GraylistThreshold != 0 {
+		t.Fatalf("expected explicit zero thresholds to be kept, got %+v (%v)", zero, err)
+	}
+
+	viper.Set("pubsub.gossip_threshold", -100.0)
+	viper.Set("pubsub.publish_threshold", -500.0)
+	viper.Set("pubsub.graylist_threshold", -1000.0)
+	if _, err := peerScoreThresholds(); err != nil {
+		t.Fatalf("expected thresholds to be valid: %v", err)
+	}
+	viper.Set("pubsub.graylist_threshold", -10.0)
+	if _, err := peerScoreThresholds(); err == nil {
+		t.Fatalf("expected a graylist threshold above the publish threshold to be rejected")
+	}
+
+	// Scoring options must be accepted by gossipsub itself.
+	viper.Set("pubsub.graylist_threshold", -1000.0)
+	viper.Set("pubsub.scoring", true)
+	mn := mocknet.New()
+	defer mn.Close()
+	h, err := mn.GenPeer()
+	if err != nil {
+		t.Fatalf("GenPeer failed: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := newPubSub(ctx, h); err != nil {
+		t.Fatalf("newPubSub with scoring failed: %v", err)
+	}
+}
+
+func encodedHeads(t *testing.T, n int) []byte {
+	t.Helper()
+	bcast := &pb.CRDTBroadcast{}
+	for i := 0; i < n; i++ {
+		mh, err := multihash.Sum([]byte{byte(i), byte(i >> 8)}, multihash.SHA2_256, -1)
+		if err != nil {
+			t.Fatalf("multihash failed: %v", err)
+		}
+		bcast.Heads = append(bcast.Heads, &pb.Head{Cid: cid.NewCidV1(cid.DagProtobuf, mh).Bytes()})
+	}
+	data, err := proto.Marshal(bcast)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	return data
+}
+
+func TestTopicValidators(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	viper.Set("pubsub.crdt_max_message_size", 256)
+
+	mn, err := mocknet.FullMeshConnected(2)
+	if err != nil {
+		t.Fatalf("mocknet failed: %v", err)
+	}
+	defer mn.Close()
+	self, other := mn.Hosts()[0], mn.Hosts()[1]
+	msg := func(data []byte) *pubsub.Message {
+		return &pubsub.Message{Message: &pubsubpb.Message{From: []byte(other.ID()), Data: data}}
+	}
+
+	validate := crdtValidator(self)
+	cases := []struct {
+		name string
+		data []byte
+		want pubsub.ValidationResult
+	}{
+		{"heads", encodedHeads(t, 2), pubsub.ValidationAccept},
+		{"oversize", encodedHeads(t, 20), pubsub.ValidationReject},
+		{"malformed", []byte{0xff, 0xff, 0xff}, pubsub.ValidationReject},
+	}
+	for _, c := range cases {
+		if got := validate(context.Background(), other.ID(), msg(c.data)); got != c.want {
+			t.Fatalf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	ping := pingValidator(self)
+	if got := ping(context.Background(), other.ID(), msg([]byte(pingMessage))); got != pubsub.ValidationAccept {
+		t.Fatalf("expected a ping to be accepted, got %v", got)
+	}
+	if got := ping(context.Background(), other.ID(), msg([]byte("pong"))); got != pubsub.ValidationReject {
+		t.Fatalf("expected anything but a ping to be rejected, got %v", got)
+	}
+}
+
+func TestTopicValidatorsDropMessages(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	viper.Set("pubsub.d", 2)
+	viper.Set("pubsub.dlo", 1)
+	viper.Set("pubsub.dhi", 4)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mn, err := mocknet.FullMeshConnected(2)
+	if err != nil {
+		t.Fatalf("mocknet failed: %v", err)
+	}
+	defer mn.Close()
+	a, b := mn.Hosts()[0], mn.Hosts()[1]
+	psubA, err := newPubSub(ctx, a)
+	if err != nil {
+		t.Fatalf("newPubSub failed: %v", err)
+	}
+	psubB, err := newPubSub(ctx, b)
+	if err != nil {
+		t.Fatalf("newPubSub failed: %v", err)
+	}
+	topicA, err := psubA.Join(pubsubNet)
+	if err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+	topicB, err := psubB.Join(pubsubNet)
+	if err != nil {
+		t.Fatalf("Join failed: %v", err)
+	}
+	sub, err := topicB.Subscribe()
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	if _, err := topicA.Subscribe(); err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+
+	// The mesh takes a few heartbeats to form, so keep publishing. Our own
+	// validator accepts the garbage; b must drop it.
+	go func() {
+		for ctx.Err() == nil {
+			_ = topicA.Publish(ctx, []byte("garbage"))
+			_ = topicA.Publish(ctx, []byte(pingMessage))
+			time.Sleep(100 * time.Millisecond)
+		}
+	}()
+	nextCtx, nextCancel := context.WithTimeout(ctx, 10*time.Second)
+	defer nextCancel()
+	for i := 0; i < 3; i++ {
+		got, err := sub.Next(nextCtx)
+		if err != nil {
+			t.Fatalf("Next failed: %v", err)
+		}
+		if string(got.Data) != pingMessage {
+			t.Fatalf("expected the invalid message to be dropped, got %q", got.Data)
+		}
+	}
+}
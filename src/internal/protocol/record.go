@@ -0,0 +1,138 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// legacySchemaVersion is bare Peer JSON, as written before records had
+	// an envelope. Legacy peer keys always hold this format.
+	legacySchemaVersion = 1
+	// PeerSchemaVersion is the version of the peer records this build
+	// writes. Bump it when a field changes meaning or type; adding fields
+	// does not need a bump, since readers ignore fields they do not know.
+	PeerSchemaVersion = 2
+)
+
+var ErrUnsupportedSchema = errors.New("unsupported record schema")
+
+// Record is a replicated value with its envelope removed.
+type Record struct {
+	Schema int
+	Data   []byte
+}
+
+// recordEnvelope is how namespaced records are stored. Like the records it
+// carries, it is decoded leniently: unknown fields are ignored so that
+// newer nodes can extend it.
+type recordEnvelope struct {
+	Schema int             `json:"schema"`
+	Data   json.RawMessage `json:"data"`
+}
+
+func wrapRecord(rec Record) ([]byte, error) {
+	if !json.Valid(rec.Data) {
+		return nil, fmt.Errorf("%w: not JSON", ErrInvalidRecord)
+	}
+	return json.Marshal(recordEnvelope{Schema: rec.Schema, Data: rec.Data})
+}
+
+// unwrapRecord opens an envelope. Values without one are legacy records.
+func unwrapRecord(value []byte) (Record, error) {
+	var env recordEnvelope
+	if err := json.Unmarshal(value, &env); err != nil {
+		return Record{}, fmt.Errorf("%w: %v", ErrInvalidRecord, err)
+	}
+	if env.Schema == 0 || env.Data == nil {
+		return Record{Schema: legacySchemaVersion, Data: value}, nil
+	}
+	return Record{Schema: env.Schema, Data: env.Data}, nil
+}
+
+func (h NamespaceHandler) schema() int {
+	if h.Schema == 0 {
+		return legacySchemaVersion
+	}
+	return h.Schema
+}
+
+func (h NamespaceHandler) minSchema(ns string) int {
+	oldest := max(h.MinSchema, legacySchemaVersion)
+	if ns == PeersNamespace {
+		oldest = max(oldest, viper.GetInt("crdt.min_peer_schema"))
+	}
+	return oldest
+}
+
+// open unwraps a stored value of ns and applies the minimum-version policy.
+// Records newer than ours are accepted and read as far as we understand
+// them.
+func (h NamespaceHandler) open(ns string, value []byte) (Record, error) {
+	rec, err := unwrapRecord(value)
+	if err != nil {
+		return Record{}, err
+	}
+	if oldest := h.minSchema(ns); rec.Schema < oldest {
+		return Record{}, fmt.Errorf("%w: version %d is older than %d", ErrUnsupportedSchema, rec.Schema, oldest)
+	}
+	return rec, nil
+}
+
+// decodePeerRecord reads a stored peer record, enveloped or legacy.
+func decodePeerRecord(value []byte) (Peer, error) {
+	h, _ := namespaceHandler(PeersNamespace)
+	rec, err := h.open(PeersNamespace, value)
+	if err != nil {
+		return Peer{}, err
+	}
+	return peerFromRecord(rec)
+}
+
+func peerFromRecord(rec Record) (Peer, error) {
+	var peer Peer
+	if err := json.Unmarshal(rec.Data, &peer); err != nil {
+		return Peer{}, fmt.Errorf("%w: %v", ErrInvalidRecord, err)
+	}
+	peer.SchemaVersion = rec.Schema
+	return peer, nil
+}
+
+// VersionDistribution counts the peers in the node table by the schema of
+// their record and by their release.
+type VersionDistribution struct {
+	Schema    int            `json:"schema"`
+	MinSchema int            `json:"min_schema"`
+	Schemas   map[string]int `json:"schemas"`
+	Releases  map[string]int `json:"releases"`
+}
+
+// GetVersionDistribution reports the versions seen across the network.
+// Peers known only from connections, whose record has not arrived yet,
+// are counted as "unknown".
+func GetVersionDistribution() VersionDistribution {
+	h, _ := namespaceHandler(PeersNamespace)
+	dist := VersionDistribution{
+		Schema:    h.schema(),
+		MinSchema: h.minSchema(PeersNamespace),
+		Schemas:   make(map[string]int),
+		Releases:  make(map[string]int),
+	}
+	for _, p := range *GetAllPeers() {
+		schema := "unknown"
+		if p.SchemaVersion > 0 {
+			schema = strconv.Itoa(p.SchemaVersion)
+		}
+		dist.Schemas[schema]++
+		release := p.Version
+		if release == "" {
+			release = "unknown"
+		}
+		dist.Releases[release]++
+	}
+	return dist
+}
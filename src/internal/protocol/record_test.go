@@ -0,0 +1,86 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/spf13/viper"
+)
+
+func TestRecordEnvelope(t *testing.T) {
+	wrapped, err := wrapRecord(Record{Schema: PeerSchemaVersion, Data: []byte(`{"id":"QmA","owner":"o"}`)})
+	if err != nil {
+		t.Fatalf("wrapRecord failed: %v", err)
+	}
+	p, err := decodePeerRecord(wrapped)
+	if err != nil || p.ID != "QmA" || p.Owner != "o" || p.SchemaVersion != PeerSchemaVersion {
+		t.Fatalf("unexpected peer %+v (%v)", p, err)
+	}
+
+	p, err = decodePeerRecord([]byte(`{"id":"QmB"}`))
+	if err != nil || p.ID != "QmB" || p.SchemaVersion != legacySchemaVersion {
+		t.Fatalf("expected a legacy record, got %+v (%v)", p, err)
+	}
+
+	if _, err := wrapRecord(Record{Schema: 1, Data: []byte("nope")}); !errors.Is(err, ErrInvalidRecord) {
+		t.Fatalf("expected ErrInvalidRecord, got %v", err)
+	}
+}
+
+func TestRecordFromNewerRelease(t *testing.T) {
+	// A future release may add fields both to the envelope and the record.
+	value := []byte(`{"schema":7,"signed_by":"x","data":{"id":"QmC","owner":"o","gpu_quota":{"a":1}}}`)
+	p, err := decodePeerRecord(value)
+	if err != nil || p.ID != "QmC" || p.Owner != "o" || p.SchemaVersion != 7 {
+		t.Fatalf("expected a tolerant decode, got %+v (%v)", p, err)
+	}
+}
+
+func TestMinPeerSchema(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	viper.Set("crdt.min_peer_schema", PeerSchemaVersion)
+
+	if _, err := decodePeerRecord([]byte(`{"id":"QmOld"}`)); !errors.Is(err, ErrUnsupportedSchema) {
+		t.Fatalf("expected legacy records to be refused, got %v", err)
+	}
+	wrapped, _ := wrapRecord(Record{Schema: PeerSchemaVersion, Data: []byte(`{"id":"QmNew"}`)})
+	if _, err := decodePeerRecord(wrapped); err != nil {
+		t.Fatalf("expected current records to be accepted, got %v", err)
+	}
+}
+
+func TestVersionDistribution(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	peers := map[string]Peer{
+		"dist-a": {ID: "dist-a", Version: "v1.2.0", SchemaVersion: 2},
+		"dist-b": {ID: "dist-b", Version: "v1.2.0", SchemaVersion: 2},
+		"dist-c": {ID: "dist-c", Version: "v1.1.0", SchemaVersion: 1},
+		"dist-d": {ID: "dist-d"},
+	}
+	// other tests may leave entries in the shared table
+	before := GetVersionDistribution()
+	for id, p := range peers {
+		b, _ := json.Marshal(p)
+		UpdateNodeTableHook(ds.NewKey(id), b)
+		defer DeleteNodeTableHook(ds.NewKey(id))
+	}
+
+	dist := GetVersionDistribution()
+	if dist.Schema != PeerSchemaVersion || dist.MinSchema != legacySchemaVersion {
+		t.Fatalf("unexpected policy %+v", dist)
+	}
+	for schema, want := range map[string]int{"2": 2, "1": 1, "unknown": 1} {
+		if got := dist.Schemas[schema] - before.Schemas[schema]; got != want {
+			t.Fatalf("expected %d peer(s) with schema %s, got %d", want, schema, got)
+		}
+	}
+	for release, want := range map[string]int{"v1.2.0": 2, "v1.1.0": 1, "unknown": 1} {
+		if got := dist.Releases[release] - before.Releases[release]; got != want {
+			t.Fatalf("expected %d peer(s) on %s, got %d", want, release, got)
+		}
+	}
+}
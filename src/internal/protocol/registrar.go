@@ -6,38 +6,121 @@ import (
 	"errors"
 	"ocf/internal/common"
 	"ocf/internal/platform"
+	"ocf/internal/wallet"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	ds "github.com/ipfs/go-datastore"
 	"github.com/spf13/viper"
 )
 
-// localServices keeps a thread-safe copy of services this node provides
-// so we can re-announce them on reconnects
-var (
-	localServices     []Service
-	localServicesLock = &sync.RWMutex{}
-)
+// Registrar owns the entry a Node announces about itself, and keeps a
+// thread-safe copy of the services the node provides so we can re-announce
+// them on reconnects.
+type Registrar struct {
+	node *Node
+
+	// selfLock guards self, which heartbeats and the API read while it is
+	// being announced.
+	selfLock sync.RWMutex
+	self     Peer
+	// wallet signed the owner attestation of self, and renews it.
+	wallet *wallet.WalletManager
+
+	servicesLock sync.RWMutex
+	services     []Service
+
+	// lastAnnounced is the fingerprint of the last record Reannounce wrote.
+	lastAnnounced atomic.Value
+}
+
+func newRegistrar(n *Node) *Registrar {
+	return &Registrar{node: n}
+}
+
+// Self returns the entry this node announces.
+func (r *Registrar) Self() Peer {
+	r.selfLock.RLock()
+	defer r.selfLock.RUnlock()
+	return r.self
+}
+
+// Initialize builds the entry of this node, binds it to its owner wallet
+// when one is known, and publishes it.
+func (r *Registrar) Initialize(ownerOverride string) {
+	n := r.node
+	ctx := context.Background()
+	self := Peer{
+		ID:            n.host.ID().String(),
+		LastSeen:      time.Now().Unix(),
+		Connected:     true,
+		Version:       common.JSONVersion.Version,
+		SchemaVersion: PeerSchemaVersion,
+	}
+	setSelfAddresses(n.host, &self)
+	setSelfLease(&self)
 
-// addLocalService appends (deduped) to localServices
-func addLocalService(svc Service) {
-	localServicesLock.Lock()
-	defer localServicesLock.Unlock()
+	// Add wallet address as provider if available
+	wm, walletErr := wallet.InitializeWallet()
+	if ownerOverride != "" {
+		self.Owner = ownerOverride
+		common.Logger.Infof("Using verified wallet account for provider: %s", self.Owner)
+	} else if account := viper.GetString("wallet.account"); account != "" {
+		self.Owner = account
+		common.Logger.Infof("Using configured wallet account for provider: %s", self.Owner)
+	} else if walletErr == nil && wm.WalletExists() {
+		self.Owner = wm.GetPublicKey()
+		if self.Owner != "" {
+			common.Logger.Infof("Added wallet address as provider: %s", self.Owner)
+		}
+	}
+	if self.Owner != "" {
+		if walletErr != nil {
+			common.Logger.Warnf("Cannot attest owner %s: %v", self.Owner, walletErr)
+		} else if att, err := NewOwnerAttestation(n.host, wm, self.Owner); err != nil {
+			common.Logger.Warnf("Cannot attest owner %s: %v", self.Owner, err)
+		} else {
+			self.OwnerAttestation = att
+			self.OwnerVerified = true
+			common.Logger.Infof("Signed owner attestation binding %s to %s", self.ID, self.Owner)
+		}
+	}
+
+	self.Hardware.GPUs = platform.GetGPUInfo()
+	r.selfLock.Lock()
+	r.self = self
+	r.wallet = nil
+	if self.OwnerAttestation != nil {
+		r.wallet = wm
+	}
+	r.selfLock.Unlock()
+	value, err := json.Marshal(self)
+	common.ReportError(err, "Error while marshalling peer")
+	err = n.putPeerRecord(ctx, self.ID, value)
+	if err != nil {
+		common.Logger.Error("Error while initializing myself in the node table: ", err)
+	}
+}
+
+// addService appends (deduped) to the local services
+func (r *Registrar) addService(svc Service) {
+	r.servicesLock.Lock()
+	defer r.servicesLock.Unlock()
 	// simple dedupe on Name|Host|Port
 	key := svc.Name + "|" + svc.Host + "|" + svc.Port
 	exists := false
-	for i := range localServices {
-		k := localServices[i].Name + "|" + localServices[i].Host + "|" + localServices[i].Port
+	for i := range r.services {
+		k := r.services[i].Name + "|" + r.services[i].Host + "|" + r.services[i].Port
 		if k == key {
 			// merge identity groups (dedupe)
 			existing := make(map[string]struct{})
-			for _, id := range localServices[i].IdentityGroup {
+			for _, id := range r.services[i].IdentityGroup {
 				existing[id] = struct{}{}
 			}
 			for _, id := range svc.IdentityGroup {
 				if _, ok := existing[id]; !ok {
-					localServices[i].IdentityGroup = append(localServices[i].IdentityGroup, id)
+					r.services[i].IdentityGroup = append(r.services[i].IdentityGroup, id)
 				}
 			}
 			exists = true
@@ -45,16 +128,50 @@ func addLocalService(svc Service) {
 		}
 	}
 	if !exists {
-		localServices = append(localServices, svc)
+		r.services = append(r.services, svc)
 	}
 }
 
-// snapshotLocalServices returns a copy of current local services
-func snapshotLocalServices() []Service {
-	localServicesLock.RLock()
-	defer localServicesLock.RUnlock()
-	out := make([]Service, len(localServices))
-	copy(out, localServices)
+// IsDraining reports whether the default node is shutting down.
+func IsDraining() bool {
+	return std.IsDraining()
+}
+
+// MarkDraining drains the default node.
+func MarkDraining() {
+	DefaultNode().MarkDraining()
+}
+
+// IsDraining reports whether the node is shutting down.
+func (n *Node) IsDraining() bool {
+	return n.draining.Load()
+}
+
+// MarkDraining flags this node and all of its services as draining and
+// publishes the change, so that routers stop sending new requests here.
+func (n *Node) MarkDraining() {
+	if n.draining.Swap(true) {
+		return
+	}
+	r := n.registrar
+	r.servicesLock.Lock()
+	for i := range r.services {
+		r.services[i].Status = DRAINING
+	}
+	r.servicesLock.Unlock()
+	r.selfLock.Lock()
+	r.self.Status = DRAINING
+	r.selfLock.Unlock()
+	common.Logger.Info("Draining: no longer accepting new requests")
+	r.Reannounce()
+}
+
+// Services returns a copy of current local services
+func (r *Registrar) Services() []Service {
+	r.servicesLock.RLock()
+	defer r.servicesLock.RUnlock()
+	out := make([]Service, len(r.services))
+	copy(out, r.services)
 	return out
 }
 
@@ -69,7 +186,7 @@ func RegisterLocalServices() {
 			return
 		}
 		common.Logger.Info("LLM service is healthy")
-		registerLLMService(servicePort)
+		DefaultNode().registrar.RegisterLLMService(servicePort)
 	}
 }
 
@@ -93,7 +210,9 @@ func healthCheckRemote(port string, maxTries int) error {
 	return nil
 }
 
-func registerLLMService(port string) {
+// RegisterLLMService provides the OpenAI compatible service listening on
+// port, under the models it lists.
+func (r *Registrar) RegisterLLMService(port string) {
 	modelsBytes, err := common.RemoteGET("http://localhost:" + port + "/v1/models")
 	if err != nil {
 		common.Logger.Error("could not fetch models from LLM service: ", err)
@@ -117,51 +236,104 @@ func registerLLMService(port string) {
 		Port:          port,
 		IdentityGroup: identityGroup,
 	}
-	provideService(service)
+	r.Provide(service)
 }
 
-func provideService(service Service) {
-	host, _ := GetP2PNode(nil)
+// Provide adds service to the services this node announces and publishes
+// the updated entry.
+func (r *Registrar) Provide(service Service) {
+	n := r.node
 	ctx := context.Background()
-	store, _ := GetCRDTStore()
-	key := ds.NewKey(host.ID().String())
+	key := ds.NewKey(n.host.ID().String())
+	if n.IsDraining() {
+		service.Status = DRAINING
+	}
 	// track locally and publish full set (deduped)
-	addLocalService(service)
-	myself.Service = snapshotLocalServices()
-	if viper.GetString("public-addr") != "" {
-		myself.PublicAddress = viper.GetString("public-addr")
-	}
-	common.Logger.Info("Registering LLM service: ", myself)
-	value, err := json.Marshal(myself)
-	UpdateNodeTableHook(key, value)
+	r.addService(service)
+	r.selfLock.Lock()
+	r.self.Service = r.Services()
+	setSelfAddresses(n.host, &r.self)
+	setSelfLease(&r.self)
+	common.Logger.Info("Registering LLM service: ", r.self)
+	self := r.self
+	value, err := json.Marshal(self)
+	r.selfLock.Unlock()
+	n.table.put(key, self)
 	common.ReportError(err, "Error while marshalling peer")
-	err = store.Put(ctx, key, value)
+	err = n.putPeerRecord(ctx, n.host.ID().String(), value)
 	if err != nil {
 		common.Logger.Debug("Error while providing service: ", err)
 	}
+	n.advertise(true)
+}
+
+// announceFingerprint identifies the content of our record, leaving out the
+// fields that change on every announcement.
+func announceFingerprint(p Peer) string {
+	p.LastSeen = 0
+	p.LeaseExpires = 0
+	b, _ := json.Marshal(p)
+	return string(b)
+}
+
+// renewAttestation signs a new owner attestation once half of the maximum
+// age of the current one has passed. The caller holds selfLock.
+func (r *Registrar) renewAttestation() {
+	att := r.self.OwnerAttestation
+	if att == nil || r.wallet == nil || time.Since(time.Unix(att.IssuedAt, 0)) < attestationMaxAge()/2 {
+		return
+	}
+	renewed, err := NewOwnerAttestation(r.node.host, r.wallet, r.self.Owner)
+	if err != nil {
+		common.Logger.Warnf("Cannot renew owner attestation: %v", err)
+		return
+	}
+	r.self.OwnerAttestation = renewed
 }
 
 // ReannounceLocalServices re-publishes this node's service entry, used after reconnects
 func ReannounceLocalServices() {
-	host, _ := GetP2PNode(nil)
+	DefaultNode().registrar.Reannounce()
+}
+
+// Reannounce re-publishes this node's entry when it changed or its lease
+// is due for renewal.
+func (r *Registrar) Reannounce() {
+	n := r.node
 	ctx := context.Background()
-	store, _ := GetCRDTStore()
-	key := ds.NewKey(host.ID().String())
+	key := ds.NewKey(n.host.ID().String())
 	// refresh hardware and services
-	myself.Hardware.GPUs = platform.GetGPUInfo()
-	myself.Service = snapshotLocalServices()
-	if viper.GetString("public-addr") != "" {
-		myself.PublicAddress = viper.GetString("public-addr")
+	gpus := platform.GetGPUInfo()
+	r.selfLock.Lock()
+	r.self.Hardware.GPUs = gpus
+	r.self.Service = r.Services()
+	setSelfAddresses(n.host, &r.self)
+	r.renewAttestation()
+	// Liveness travels in heartbeats; only write a delta when the record
+	// changed or the lease needs renewing.
+	fingerprint := announceFingerprint(r.self)
+	leaseDue := time.Until(time.Unix(r.self.LeaseExpires, 0)) <= leaseTTL()/3
+	// Older nodes that joined since only read the legacy copy.
+	legacyDue := legacyPeerKeys() && !n.legacyAnnounced.Load() && n.table.hasLegacyPeers()
+	if fingerprint == r.lastAnnounced.Load() && !leaseDue && !legacyDue {
+		r.selfLock.Unlock()
+		common.Logger.Debug("Local services unchanged; skipping re-announce")
+		return
 	}
-	value, err := json.Marshal(myself)
+	setSelfLease(&r.self)
+	self := r.self
+	value, err := json.Marshal(self)
+	r.selfLock.Unlock()
 	if err != nil {
 		common.Logger.Error("Error marshalling self during reannounce: ", err)
 		return
 	}
-	UpdateNodeTableHook(key, value)
-	if err := store.Put(ctx, key, value); err != nil {
+	n.table.put(key, self)
+	if err := n.putPeerRecord(ctx, n.host.ID().String(), value); err != nil {
 		common.Logger.Warn("Failed to reannounce local services: ", err)
 	} else {
+		r.lastAnnounced.Store(fingerprint)
 		common.Logger.Info("Re-announced local services to network")
 	}
+	n.advertise(false)
 }
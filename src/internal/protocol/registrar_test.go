@@ -1,14 +1,20 @@
 package protocol
 
-import "testing"
+import (
+	"context"
+	"testing"
+
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/spf13/viper"
+)
 
 func TestLocalServiceSnapshot(t *testing.T) {
 	// start with empty registry
-	localServices = nil
-	addLocalService(Service{Name: "llm", Host: "localhost", Port: "8000", IdentityGroup: []string{"model=a"}})
-	addLocalService(Service{Name: "llm", Host: "localhost", Port: "8000", IdentityGroup: []string{"model=b"}})
+	r := newRegistrar(nil)
+	r.addService(Service{Name: "llm", Host: "localhost", Port: "8000", IdentityGroup: []string{"model=a"}})
+	r.addService(Service{Name: "llm", Host: "localhost", Port: "8000", IdentityGroup: []string{"model=b"}})
 
-	snap := snapshotLocalServices()
+	snap := r.Services()
 	if len(snap) != 1 {
 		t.Fatalf("expected 1 service after dedupe, got %d", len(snap))
 	}
@@ -16,3 +22,50 @@ func TestLocalServiceSnapshot(t *testing.T) {
 		t.Fatalf("expected merged identity groups, got %v", snap[0].IdentityGroup)
 	}
 }
+
+func TestDrainWhileHeartbeating(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	t.Setenv("HOME", t.TempDir())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mn := mocknet.New()
+	defer mn.Close()
+	h, err := mn.GenPeer()
+	if err != nil {
+		t.Fatalf("GenPeer failed: %v", err)
+	}
+	n, err := NewNode(ctx, NodeConfig{Mode: "test", Host: h, Heartbeats: true})
+	if err != nil {
+		t.Fatalf("NewNode failed: %v", err)
+	}
+	defer n.Close()
+	n.Registrar().Initialize("")
+
+	// Run with -race: heartbeats read our entry while draining writes it.
+	started, stop, done := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			if i == 1 {
+				close(started)
+			}
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if _, err := n.newHeartbeat(); err != nil {
+				t.Errorf("newHeartbeat failed: %v", err)
+				return
+			}
+		}
+	}()
+	<-started
+	n.MarkDraining()
+	close(stop)
+	<-done
+	if n.Registrar().Self().Status != DRAINING {
+		t.Fatalf("expected our entry to be marked as draining")
+	}
+}
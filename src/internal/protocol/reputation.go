@@ -0,0 +1,257 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"math/rand"
+	"ocf/internal/common"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	libpeer "github.com/libp2p/go-libp2p/core/peer"
+)
+
+const (
+	reputationFile = "reputation.json"
+	// latencyEWMAAlpha weights the newest latency sample.
+	latencyEWMAAlpha = 0.2
+	// minSelectionWeight keeps low-scored peers selectable so that they can
+	// recover once they behave well again.
+	minSelectionWeight = 0.05
+)
+
+// PeerReputation is what this node has observed about a remote peer. It is
+// kept locally and never replicated.
+type PeerReputation struct {
+	PeerID            string  `json:"peer_id"`
+	Successes         int64   `json:"successes"`
+	Failures          int64   `json:"failures"`
+	LatencyMs         float64 `json:"latency_ms"` // EWMA of forwarded request latency
+	UpChecks          int64   `json:"up_checks"`
+	DownChecks        int64   `json:"down_checks"`
+	ValidSignatures   int64   `json:"valid_signatures"`
+	InvalidSignatures int64   `json:"invalid_signatures"`
+	AttestedAt        int64   `json:"attested_at,omitempty"` // IssuedAt of the last counted owner attestation
+	Score             float64 `json:"score"`
+	UpdatedAt         int64   `json:"updated_at"`
+}
+
+type reputationBook struct {
+	mu    sync.RWMutex
+	peers map[string]*PeerReputation
+	path  string
+}
+
+var reputationOnce sync.Once
+var reputations *reputationBook
+
+func getReputationBook() *reputationBook {
+	reputationOnce.Do(func() {
+		reputations = &reputationBook{
+			peers: make(map[string]*PeerReputation),
+			path:  path.Join(common.GetHomePath(), reputationFile),
+		}
+		if err := reputations.load(); err != nil {
+			common.Logger.Warnf("Could not load peer reputation from %s: %v", reputations.path, err)
+		}
+	})
+	return reputations
+}
+
+func (b *reputationBook) load() error {
+	data, err := os.ReadFile(b.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var entries []*PeerReputation
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, e := range entries {
+		if e.PeerID != "" {
+			b.peers[e.PeerID] = e
+		}
+	}
+	return nil
+}
+
+func (b *reputationBook) save() error {
+	b.mu.RLock()
+	entries := make([]*PeerReputation, 0, len(b.peers))
+	for _, e := range b.peers {
+		entries = append(entries, e)
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	b.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	tmp := b.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, b.path)
+}
+
+func (b *reputationBook) update(peerID string, fn func(r *PeerReputation)) {
+	if peerID == "" {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	r, ok := b.peers[peerID]
+	if !ok {
+		r = &PeerReputation{PeerID: peerID}
+		b.peers[peerID] = r
+	}
+	fn(r)
+	r.Score = computeReputationScore(r)
+	r.UpdatedAt = time.Now().Unix()
+}
+
+func (b *reputationBook) score(peerID string) float64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if r, ok := b.peers[peerID]; ok {
+		return r.Score
+	}
+	return computeReputationScore(&PeerReputation{})
+}
+
+// computeReputationScore combines the observations into a value in [0, 1].
+// Every ratio is Laplace-smoothed so that a peer we know nothing about lands
+// in the middle instead of at either extreme.
+func computeReputationScore(r *PeerReputation) float64 {
+	successRate := float64(r.Successes+1) / float64(r.Successes+r.Failures+2)
+	uptime := float64(r.UpChecks+1) / float64(r.UpChecks+r.DownChecks+2)
+	signatures := float64(r.ValidSignatures+1) / float64(r.ValidSignatures+r.InvalidSignatures+2)
+	latency := 0.5
+	if r.LatencyMs > 0 {
+		// 1s round trips score 0.5, faster peers approach 1.
+		latency = 1 / (1 + r.LatencyMs/1000)
+	}
+	return 0.5*successRate + 0.2*latency + 0.2*uptime + 0.1*signatures
+}
+
+// RecordRequestResult records the outcome of a request forwarded to peerID.
+func RecordRequestResult(peerID string, success bool, latency time.Duration) {
+	getReputationBook().update(peerID, func(r *PeerReputation) {
+		if success {
+			r.Successes++
+		} else {
+			r.Failures++
+		}
+		if latency > 0 {
+			ms := float64(latency) / float64(time.Millisecond)
+			if r.LatencyMs == 0 {
+				r.LatencyMs = ms
+			} else {
+				r.LatencyMs = latencyEWMAAlpha*ms + (1-latencyEWMAAlpha)*r.LatencyMs
+			}
+		}
+	})
+}
+
+// RecordAvailability records whether peerID was reachable during a periodic
+// liveness check.
+func RecordAvailability(peerID string, up bool) {
+	getReputationBook().update(peerID, func(r *PeerReputation) {
+		if up {
+			r.UpChecks++
+		} else {
+			r.DownChecks++
+		}
+	})
+}
+
+// RecordSignatureResult records whether a signed record from peerID verified.
+func RecordSignatureResult(peerID string, valid bool) {
+	getReputationBook().update(peerID, func(r *PeerReputation) {
+		if valid {
+			r.ValidSignatures++
+		} else {
+			r.InvalidSignatures++
+		}
+	})
+}
+
+// RecordAttestation counts a verified owner attestation of peerID. The same
+// attestation arrives with every copy of the peer's record, so each one is
+// only counted once, by its issue time.
+func RecordAttestation(peerID string, issuedAt int64) {
+	getReputationBook().update(peerID, func(r *PeerReputation) {
+		if issuedAt > r.AttestedAt {
+			r.AttestedAt = issuedAt
+			r.ValidSignatures++
+		}
+	})
+}
+
+// ReputationScore returns the current score of peerID.
+func ReputationScore(peerID string) float64 {
+	return getReputationBook().score(peerID)
+}
+
+// GetReputations returns a snapshot of every known peer reputation.
+func GetReputations() []PeerReputation {
+	b := getReputationBook()
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	out := make([]PeerReputation, 0, len(b.peers))
+	for _, r := range b.peers {
+		out = append(out, *r)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Score > out[j].Score })
+	return out
+}
+
+// SaveReputations persists the reputation book to disk.
+func SaveReputations() {
+	if err := getReputationBook().save(); err != nil {
+		common.Logger.Warn("Could not persist peer reputation: ", err)
+	}
+}
+
+// SelectByReputation picks one of the candidates at random, weighted by
+// reputation and measured round trip time so that well-behaved, nearby peers
+// get most of the traffic without starving the others.
+func SelectByReputation(candidates []string) string {
+	if len(candidates) == 0 {
+		return ""
+	}
+	weights := make([]float64, len(candidates))
+	total := 0.0
+	for i, c := range candidates {
+		w := math.Max(ReputationScore(c), minSelectionWeight)
+		weights[i] = w * w * latencyWeight(c)
+		total += weights[i]
+	}
+	pick := rand.Float64() * total
+	for i, w := range weights {
+		if pick < w {
+			return candidates[i]
+		}
+		pick -= w
+	}
+	return candidates[len(candidates)-1]
+}
+
+// sortByReputation orders peers from best to worst, combining reputation
+// with measured round trip time.
+func sortByReputation(infos []libpeer.AddrInfo) {
+	rank := func(id libpeer.ID) float64 {
+		return ReputationScore(id.String()) * latencyWeight(id.String())
+	}
+	sort.SliceStable(infos, func(i, j int) bool {
+		return rank(infos[i].ID) > rank(infos[j].ID)
+	})
+}
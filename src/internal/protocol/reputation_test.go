@@ -0,0 +1,68 @@
+package protocol
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestComputeReputationScore(t *testing.T) {
+	unknown := computeReputationScore(&PeerReputation{})
+	good := computeReputationScore(&PeerReputation{Successes: 50, LatencyMs: 100, UpChecks: 50, ValidSignatures: 5})
+	bad := computeReputationScore(&PeerReputation{Failures: 50, LatencyMs: 5000, DownChecks: 50, InvalidSignatures: 5})
+	if !(good > unknown && unknown > bad) {
+		t.Fatalf("expected good > unknown > bad, got %f, %f, %f", good, unknown, bad)
+	}
+	if good > 1 || bad < 0 {
+		t.Fatalf("score out of range: good=%f bad=%f", good, bad)
+	}
+}
+
+func TestReputationBookPersistence(t *testing.T) {
+	book := &reputationBook{
+		peers: make(map[string]*PeerReputation),
+		path:  filepath.Join(t.TempDir(), reputationFile),
+	}
+	book.update("peerA", func(r *PeerReputation) { r.Successes = 3 })
+	book.update("peerA", func(r *PeerReputation) { r.LatencyMs = 250 })
+	if err := book.save(); err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+
+	reloaded := &reputationBook{peers: make(map[string]*PeerReputation), path: book.path}
+	if err := reloaded.load(); err != nil {
+		t.Fatalf("unexpected: %v", err)
+	}
+	got, ok := reloaded.peers["peerA"]
+	if !ok || got.Successes != 3 || got.LatencyMs != 250 {
+		t.Fatalf("unexpected reloaded reputation: %+v", got)
+	}
+	if reloaded.score("peerA") != book.score("peerA") {
+		t.Fatalf("expected score to survive reload")
+	}
+}
+
+func TestSelectByReputationPrefersGoodPeers(t *testing.T) {
+	old := os.Getenv("HOME")
+	t.Cleanup(func() { _ = os.Setenv("HOME", old) })
+	_ = os.Setenv("HOME", t.TempDir())
+	for i := 0; i < 100; i++ {
+		RecordRequestResult("rep-good", true, 50*time.Millisecond)
+		RecordRequestResult("rep-bad", false, 0)
+		RecordAvailability("rep-bad", false)
+	}
+	counts := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		counts[SelectByReputation([]string{"rep-good", "rep-bad"})]++
+	}
+	if counts["rep-good"] <= counts["rep-bad"] {
+		t.Fatalf("expected good peer to be preferred, got %v", counts)
+	}
+	if counts["rep-bad"] == 0 {
+		t.Fatalf("expected bad peer to remain selectable, got %v", counts)
+	}
+	if SelectByReputation(nil) != "" {
+		t.Fatalf("expected empty selection for no candidates")
+	}
+}
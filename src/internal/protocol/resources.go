@@ -0,0 +1,198 @@
+package protocol
+
+import (
+	"fmt"
+	"ocf/internal/common"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
+	"github.com/spf13/viper"
+)
+
+const (
+	// bootstrapProtectTag marks bootstrap peers so that the connection
+	// manager never trims them.
+	bootstrapProtectTag = "bootstrap"
+	// configProtectTag marks peers listed in connmgr.protected_peers.
+	configProtectTag = "configured"
+
+	defaultConnMgrLowWater    = 100
+	defaultConnMgrHighWater   = 400
+	defaultConnMgrGracePeriod = time.Minute
+)
+
+// newResourceManager builds the libp2p resource manager. Limits start from the
+// libp2p defaults scaled to the machine and are then overridden by any of the
+// resources.* settings that are set. A zero value keeps the default.
+func newResourceManager() (network.ResourceManager, error) {
+	if viper.IsSet("resources.enabled") && !viper.GetBool("resources.enabled") {
+		common.Logger.Warn("Resource manager disabled; connections and streams are unlimited")
+		return &network.NullResourceManager{}, nil
+	}
+	scaling := rcmgr.DefaultLimits
+	libp2p.SetDefaultServiceLimits(&scaling)
+	limits := resourceLimitOverrides().Build(scaling.AutoScale())
+	return rcmgr.NewResourceManager(rcmgr.NewFixedLimiter(limits))
+}
+
+func resourceLimitOverrides() rcmgr.PartialLimitConfig {
+	return rcmgr.PartialLimitConfig{
+		System: rcmgr.ResourceLimits{
+			Conns:   rcmgr.LimitVal(viper.GetInt("resources.max_conns")),
+			Streams: rcmgr.LimitVal(viper.GetInt("resources.max_streams")),
+			FD:      rcmgr.LimitVal(viper.GetInt("resources.max_fds")),
+			Memory:  rcmgr.LimitVal64(viper.GetInt64("resources.max_memory_mb") << 20),
+		},
+		PeerDefault: rcmgr.ResourceLimits{
+			Conns:   rcmgr.LimitVal(viper.GetInt("resources.peer_max_conns")),
+			Streams: rcmgr.LimitVal(viper.GetInt("resources.peer_max_streams")),
+			Memory:  rcmgr.LimitVal64(viper.GetInt64("resources.peer_max_memory_mb") << 20),
+		},
+		ProtocolDefault: rcmgr.ResourceLimits{
+			Streams: rcmgr.LimitVal(viper.GetInt("resources.protocol_max_streams")),
+			Memory:  rcmgr.LimitVal64(viper.GetInt64("resources.protocol_max_memory_mb") << 20),
+		},
+	}
+}
+
+// newConnManager builds the low/high water connection manager. Once the
+// number of connections exceeds the high water mark, the least valuable
+// unprotected peers are closed until the low water mark is reached.
+func newConnManager() (*connmgr.BasicConnMgr, error) {
+	low := viper.GetInt("connmgr.low_water")
+	if low <= 0 {
+		low = defaultConnMgrLowWater
+	}
+	high := viper.GetInt("connmgr.high_water")
+	if high <= 0 {
+		high = defaultConnMgrHighWater
+	}
+	if high < low {
+		return nil, fmt.Errorf("connmgr.high_water (%d) must not be lower than connmgr.low_water (%d)", high, low)
+	}
+	grace := readDurationSetting("connmgr.grace_period", defaultConnMgrGracePeriod)
+	cm, err := connmgr.NewConnManager(low, high, connmgr.WithGracePeriod(grace))
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range viper.GetStringSlice("connmgr.protected_peers") {
+		pid, err := peer.Decode(s)
+		if err != nil {
+			common.Logger.Warnf("Ignoring invalid protected peer %q: %v", s, err)
+			continue
+		}
+		cm.Protect(pid, configProtectTag)
+	}
+	return cm, nil
+}
+
+// protectBootstraps keeps the connection manager of h from trimming
+// bootstrap peers.
+func protectBootstraps(h host.Host, infos []peer.AddrInfo) {
+	for _, info := range infos {
+		h.ConnManager().Protect(info.ID, bootstrapProtectTag)
+	}
+}
+
+// ScopeStats is the usage of one resource scope.
+type ScopeStats struct {
+	ConnsInbound    int   `json:"conns_inbound"`
+	ConnsOutbound   int   `json:"conns_outbound"`
+	StreamsInbound  int   `json:"streams_inbound"`
+	StreamsOutbound int   `json:"streams_outbound"`
+	FDs             int   `json:"fds"`
+	Memory          int64 `json:"memory"`
+}
+
+// ConnManagerStats reports the connection manager configuration and state.
+type ConnManagerStats struct {
+	LowWater    int    `json:"low_water"`
+	HighWater   int    `json:"high_water"`
+	GracePeriod string `json:"grace_period"`
+	ConnCount   int    `json:"conn_count"`
+	LastTrim    int64  `json:"last_trim"`
+}
+
+// ResourceStats is a snapshot of the resource manager and connection manager.
+type ResourceStats struct {
+	Enabled     bool                  `json:"enabled"`
+	System      ScopeStats            `json:"system"`
+	Transient   ScopeStats            `json:"transient"`
+	Services    map[string]ScopeStats `json:"services"`
+	Protocols   map[string]ScopeStats `json:"protocols"`
+	Peers       map[string]ScopeStats `json:"peers"`
+	ConnManager *ConnManagerStats     `json:"conn_manager,omitempty"`
+}
+
+func toScopeStats(s network.ScopeStat) ScopeStats {
+	return ScopeStats{
+		ConnsInbound:    s.NumConnsInbound,
+		ConnsOutbound:   s.NumConnsOutbound,
+		StreamsInbound:  s.NumStreamsInbound,
+		StreamsOutbound: s.NumStreamsOutbound,
+		FDs:             s.NumFD,
+		Memory:          s.Memory,
+	}
+}
+
+// GetResourceManagerStats returns current resource usage statistics
+func GetResourceManagerStats() ResourceStats {
+	host, _ := GetP2PNode(nil)
+	stats := ResourceStats{
+		Services:  map[string]ScopeStats{},
+		Protocols: map[string]ScopeStats{},
+		Peers:     map[string]ScopeStats{},
+	}
+	if rm, ok := host.Network().ResourceManager().(rcmgr.ResourceManagerState); ok {
+		stat := rm.Stat()
+		stats.Enabled = true
+		stats.System = toScopeStats(stat.System)
+		stats.Transient = toScopeStats(stat.Transient)
+		for name, s := range stat.Services {
+			stats.Services[name] = toScopeStats(s)
+		}
+		for proto, s := range stat.Protocols {
+			stats.Protocols[string(proto)] = toScopeStats(s)
+		}
+		for pid, s := range stat.Peers {
+			stats.Peers[pid.String()] = toScopeStats(s)
+		}
+	}
+	if cm, ok := host.ConnManager().(*connmgr.BasicConnMgr); ok {
+		info := cm.GetInfo()
+		stats.ConnManager = &ConnManagerStats{
+			LowWater:    info.LowWater,
+			HighWater:   info.HighWater,
+			GracePeriod: info.GracePeriod.String(),
+			ConnCount:   info.ConnCount,
+		}
+		if !info.LastTrim.IsZero() {
+			stats.ConnManager.LastTrim = info.LastTrim.Unix()
+		}
+	}
+	return stats
+}
+
+// LogResourceManagerStats logs a one-line summary of the system scope.
+func LogResourceManagerStats() {
+	stats := GetResourceManagerStats()
+	if !stats.Enabled {
+		common.Logger.Info("No Resource Manager configured")
+		return
+	}
+	s := stats.System
+	common.Logger.Infof("Resource Manager Stats - System: Conns=%d (in:%d out:%d), Streams=%d (in:%d out:%d), Memory=%d",
+		s.ConnsInbound+s.ConnsOutbound,
+		s.ConnsInbound,
+		s.ConnsOutbound,
+		s.StreamsInbound+s.StreamsOutbound,
+		s.StreamsInbound,
+		s.StreamsOutbound,
+		s.Memory,
+	)
+}
@@ -0,0 +1,65 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
+	"github.com/spf13/viper"
+)
+
+func TestResourceLimitOverrides(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	viper.Set("resources.max_conns", 64)
+	viper.Set("resources.peer_max_streams", 8)
+	viper.Set("resources.protocol_max_memory_mb", 2)
+
+	limits := resourceLimitOverrides().Build(rcmgr.DefaultLimits.AutoScale()).ToPartialLimitConfig()
+	if limits.System.Conns != 64 {
+		t.Fatalf("expected system conns 64, got %v", limits.System.Conns)
+	}
+	if limits.PeerDefault.Streams != 8 {
+		t.Fatalf("expected peer streams 8, got %v", limits.PeerDefault.Streams)
+	}
+	if limits.ProtocolDefault.Memory != 2<<20 {
+		t.Fatalf("expected protocol memory 2MiB, got %v", limits.ProtocolDefault.Memory)
+	}
+	if limits.System.Streams == 0 {
+		t.Fatalf("expected unset limits to keep their defaults")
+	}
+}
+
+func TestNewConnManager(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	cm, err := newConnManager()
+	if err != nil {
+		t.Fatalf("newConnManager failed: %v", err)
+	}
+	info := cm.GetInfo()
+	if info.LowWater != defaultConnMgrLowWater || info.HighWater != defaultConnMgrHighWater || info.GracePeriod != defaultConnMgrGracePeriod {
+		t.Fatalf("unexpected defaults: %+v", info)
+	}
+	cm.Close()
+
+	viper.Set("connmgr.low_water", 10)
+	viper.Set("connmgr.high_water", 20)
+	viper.Set("connmgr.grace_period", "5s")
+	viper.Set("connmgr.protected_peers", []string{"12D3KooWJWoaqZhDaoEFshF7Rh1bpY9ohihFhzcW6d69Lr2NASuq"})
+	cm, err = newConnManager()
+	if err != nil {
+		t.Fatalf("newConnManager failed: %v", err)
+	}
+	defer cm.Close()
+	info = cm.GetInfo()
+	if info.LowWater != 10 || info.HighWater != 20 || info.GracePeriod != 5*time.Second {
+		t.Fatalf("unexpected configuration: %+v", info)
+	}
+
+	viper.Set("connmgr.low_water", 30)
+	if _, err := newConnManager(); err == nil {
+		t.Fatalf("expected high water below low water to be rejected")
+	}
+}
@@ -1,11 +1,9 @@
 package protocol
 
 import (
-	"sync"
 	"time"
 
 	"ocf/internal/common"
-	crdt "ocf/internal/protocol/go-ds-crdt"
 
 	"github.com/spf13/viper"
 )
@@ -16,10 +14,12 @@ const (
 	defaultTombstoneCompactionBatch    = 512
 )
 
-var tombstoneCompactorOnce sync.Once
-
-func startTombstoneCompactor(store *crdt.Datastore) {
-	tombstoneCompactorOnce.Do(func() {
+// startTombstoneCompactor drops tombstones older than
+// crdt.tombstone_retention every crdt.tombstone_compaction_interval, until
+// the node is closed.
+func (n *Node) startTombstoneCompactor() {
+	n.compactOnce.Do(func() {
+		store := n.store
 		retention := readDurationSetting("crdt.tombstone_retention", defaultTombstoneRetention)
 		interval := readDurationSetting("crdt.tombstone_compaction_interval", defaultTombstoneCompactionInterval)
 		batch := viper.GetInt("crdt.tombstone_compaction_batch")
@@ -35,8 +35,10 @@ func startTombstoneCompactor(store *crdt.Datastore) {
 			interval = defaultTombstoneCompactionInterval
 		}
 
-		ctx := store.Context()
+		ctx := n.ctx
+		n.background.Add(1)
 		go func() {
+			defer n.background.Done()
 			run := func() {
 				removed, err := store.CompactTombstones(ctx, retention, batch)
 				if err != nil {
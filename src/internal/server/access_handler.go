@@ -0,0 +1,49 @@
+package server
+
+import (
+	"net/http"
+	"ocf/internal/protocol"
+
+	"github.com/gin-gonic/gin"
+)
+
+type accessEntry struct {
+	Value string `json:"value"`
+}
+
+// registerAccessRoutes serves the access lists. The router is also reachable
+// by peers over libp2p, so the lists are admin only.
+func registerAccessRoutes(v1 *gin.RouterGroup) {
+	accessGroup := v1.Group("/access", adminAuth())
+	{
+		accessGroup.GET("", getAccessList)
+		accessGroup.POST("/:action/:kind", addAccessEntry)
+		accessGroup.DELETE("/:action/:kind", removeAccessEntry)
+	}
+}
+
+func getAccessList(c *gin.Context) {
+	c.JSON(http.StatusOK, protocol.GetAccessList())
+}
+
+func addAccessEntry(c *gin.Context) {
+	updateAccessEntry(c, false)
+}
+
+func removeAccessEntry(c *gin.Context) {
+	updateAccessEntry(c, true)
+}
+
+func updateAccessEntry(c *gin.Context, remove bool) {
+	var entry accessEntry
+	if err := c.BindJSON(&entry); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	list, err := protocol.UpdateAccessList(c.Param("action"), c.Param("kind"), entry.Value, remove)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ocf/internal/protocol"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func accessRequest(router *gin.Engine, method, path, token string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, strings.NewReader(`{"value":"10.0.0.0/8"}`))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAccessEndpointsRequireAdminToken(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	t.Setenv("HOME", t.TempDir())
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	registerAccessRoutes(router.Group("/v1"))
+
+	assert.Equal(t, http.StatusForbidden, accessRequest(router, "POST", "/v1/access/deny/cidr", "").Code)
+	assert.Equal(t, http.StatusForbidden, accessRequest(router, "DELETE", "/v1/access/deny/cidr", "").Code)
+
+	viper.Set("admin.token", "s3cret")
+	assert.Equal(t, http.StatusUnauthorized, accessRequest(router, "POST", "/v1/access/deny/cidr", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, accessRequest(router, "DELETE", "/v1/access/deny/cidr", "wrong").Code)
+	assert.Equal(t, http.StatusUnauthorized, accessRequest(router, "GET", "/v1/access", "").Code)
+	require.Empty(t, protocol.GetAccessList().DenyCIDRs)
+	assert.Equal(t, http.StatusOK, accessRequest(router, "GET", "/v1/access", "s3cret").Code)
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+)
+
+// adminAuth guards operator-only endpoints with the bearer token set in
+// admin.token. They are disabled while no token is configured, since the
+// API is also reachable by peers over libp2p.
+func adminAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := viper.GetString("admin.token")
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin endpoints are disabled; set admin.token to enable them"})
+			return
+		}
+		got, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			c.Header("WWW-Authenticate", `Bearer realm="ocf-admin"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid admin token"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"ocf/internal/common"
 	"ocf/internal/protocol"
 	"time"
 
@@ -26,10 +27,6 @@ func listBootstraps(c *gin.Context) {
 }
 
 func getResourceStats(c *gin.Context) {
-	// Call the resource manager stats function from protocol package
-	protocol.GetResourceManagerStats()
-
-	// Also return current connection count
 	connectedPeers := protocol.ConnectedPeers()
 	allPeers := protocol.AllPeers()
 
@@ -38,10 +35,47 @@ func getResourceStats(c *gin.Context) {
 		"total_peers_known":      len(allPeers),
 		"connected_peer_details": connectedPeers,
 		"all_peer_details":       allPeers,
-		"message":                "Resource manager stats logged to console",
+		"resources":              protocol.GetResourceManagerStats(),
 	})
 }
 
+func listReputations(c *gin.Context) {
+	c.JSON(200, gin.H{"reputations": protocol.GetReputations()})
+}
+
+func getConnectivity(c *gin.Context) {
+	c.JSON(200, protocol.GetConnectivityStatus())
+}
+
+func listHeartbeats(c *gin.Context) {
+	c.JSON(200, gin.H{"heartbeats": protocol.GetLiveness()})
+}
+
+func getVersionDistribution(c *gin.Context) {
+	c.JSON(200, protocol.GetVersionDistribution())
+}
+
+func listLatencies(c *gin.Context) {
+	c.JSON(200, gin.H{"latencies": protocol.GetLatencies()})
+}
+
+func exportSnapshot(c *gin.Context) {
+	store, _ := protocol.GetCRDTStore()
+	if len(store.InternalStats(c.Request.Context()).Heads) == 0 {
+		c.JSON(503, gin.H{"error": "the CRDT store has no heads yet"})
+		return
+	}
+	c.Header("Content-Type", "application/gzip")
+	c.Header("Content-Disposition", `attachment; filename="ocf-crdt-snapshot.json.gz"`)
+	info, err := store.ExportSnapshot(c.Request.Context(), c.Writer)
+	if err != nil {
+		// Headers are already sent; all we can do is log.
+		common.Logger.Warn("Error while exporting CRDT snapshot: ", err)
+		return
+	}
+	common.Logger.Infof("Exported CRDT snapshot (%d records, height %d)", info.Records, info.MaxHeight)
+}
+
 func updateLocal(c *gin.Context) {
 	var peer protocol.Peer
     if err := c.BindJSON(&peer); err != nil {
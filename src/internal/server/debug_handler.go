@@ -0,0 +1,138 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"ocf/internal/protocol"
+	"strconv"
+
+	crdt "ocf/internal/protocol/go-ds-crdt"
+
+	"github.com/gin-gonic/gin"
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+)
+
+const (
+	defaultDAGDumpDepth = 100
+	defaultKeysLimit    = 100
+)
+
+// debugStore returns the store the debug endpoints inspect.
+var debugStore = func() *crdt.Datastore {
+	store, _ := protocol.GetCRDTStore()
+	return store
+}
+
+type headInfo struct {
+	Cid    string `json:"cid"`
+	Height uint64 `json:"height"`
+}
+
+type keyInfo struct {
+	Key   string `json:"key"`
+	Size  int    `json:"size"`
+	Value string `json:"value,omitempty"`
+}
+
+func queryInt(c *gin.Context, name string, fallback int) (int, bool) {
+	raw := c.Query(name)
+	if raw == "" {
+		return fallback, true
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": name + " must be a non-negative integer"})
+		return 0, false
+	}
+	return n, true
+}
+
+func debugCRDTHeads(c *gin.Context) {
+	store := debugStore()
+	ctx := c.Request.Context()
+	heads := []headInfo{}
+	for _, h := range store.Heads(ctx) {
+		heads = append(heads, headInfo{Cid: h.Cid.String(), Height: h.Height})
+	}
+	stats := store.InternalStats(ctx)
+	c.JSON(http.StatusOK, gin.H{
+		"heads":       heads,
+		"max_height":  stats.MaxHeight,
+		"queued_jobs": stats.QueuedJobs,
+		"dirty":       store.IsDirty(ctx),
+	})
+}
+
+func debugCRDTDag(c *gin.Context) {
+	depth, ok := queryInt(c, "depth", defaultDAGDumpDepth)
+	if !ok {
+		return
+	}
+	// Render fully before answering so that a failed walk is a 500 rather
+	// than a truncated graph.
+	var buf bytes.Buffer
+	if err := debugStore().DotDAGDepth(c.Request.Context(), &buf, uint64(depth)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Data(http.StatusOK, "text/vnd.graphviz; charset=utf-8", buf.Bytes())
+}
+
+func debugCRDTJobs(c *gin.Context) {
+	store := debugStore()
+	pending := []string{}
+	for _, b := range store.PendingBlocks() {
+		pending = append(pending, b.String())
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"queued_jobs": store.InternalStats(c.Request.Context()).QueuedJobs,
+		"pending":     pending,
+	})
+}
+
+func debugCRDTRepair(c *gin.Context) {
+	store := debugStore()
+	if err := protocol.RepairCRDT(c.Request.Context(), store); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"dirty": store.IsDirty(c.Request.Context())})
+}
+
+func debugCRDTSync(c *gin.Context) {
+	prefix := ds.NewKey(c.DefaultQuery("prefix", "/"))
+	if err := debugStore().Sync(c.Request.Context(), prefix); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"synced": prefix.String()})
+}
+
+func debugCRDTKeys(c *gin.Context) {
+	limit, ok := queryInt(c, "limit", defaultKeysLimit)
+	if !ok {
+		return
+	}
+	values := c.Query("values") == "true"
+	q := query.Query{Prefix: c.Query("prefix"), Limit: limit}
+	results, err := debugStore().Query(c.Request.Context(), q)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer results.Close()
+	keys := []keyInfo{}
+	for r := range results.Next() {
+		if r.Error != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": r.Error.Error()})
+			return
+		}
+		k := keyInfo{Key: r.Key, Size: len(r.Value)}
+		if values {
+			k.Value = string(r.Value)
+		}
+		keys = append(keys, k)
+	}
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	crdt "ocf/internal/protocol/go-ds-crdt"
+
+	"github.com/gin-gonic/gin"
+	mdutils "github.com/ipfs/boxo/ipld/merkledag/test"
+	ds "github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newDebugRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	store, err := crdt.New(dssync.MutexWrap(ds.NewMapDatastore()), ds.NewKey("test"), mdutils.Mock(), nil, crdt.DefaultOptions())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = store.Close() })
+	ctx := context.Background()
+	require.NoError(t, store.Put(ctx, ds.NewKey("/peers/QmA"), []byte(`{"schema":2,"data":{}}`)))
+	require.NoError(t, store.Put(ctx, ds.NewKey("/config/routing"), []byte(`{"schema":1,"data":{}}`)))
+
+	prev := debugStore
+	debugStore = func() *crdt.Datastore { return store }
+	t.Cleanup(func() { debugStore = prev })
+
+	router := gin.New()
+	group := router.Group("/v1/debug/crdt", adminAuth())
+	group.GET("/heads", debugCRDTHeads)
+	group.GET("/dag", debugCRDTDag)
+	group.GET("/keys", debugCRDTKeys)
+	return router
+}
+
+func debugRequest(router *gin.Engine, path, token string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("GET", path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestDebugEndpointsRequireAdminToken(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	router := newDebugRouter(t)
+
+	assert.Equal(t, http.StatusForbidden, debugRequest(router, "/v1/debug/crdt/heads", "").Code)
+
+	viper.Set("admin.token", "s3cret")
+	assert.Equal(t, http.StatusUnauthorized, debugRequest(router, "/v1/debug/crdt/heads", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, debugRequest(router, "/v1/debug/crdt/heads", "wrong").Code)
+	assert.Equal(t, http.StatusOK, debugRequest(router, "/v1/debug/crdt/heads", "s3cret").Code)
+}
+
+func TestDebugCRDTHeadsAndDag(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	viper.Set("admin.token", "s3cret")
+	router := newDebugRouter(t)
+
+	w := debugRequest(router, "/v1/debug/crdt/heads", "s3cret")
+	require.Equal(t, http.StatusOK, w.Code)
+	var heads struct {
+		Heads []struct {
+			Cid    string `json:"cid"`
+			Height uint64 `json:"height"`
+		} `json:"heads"`
+		MaxHeight uint64 `json:"max_height"`
+		Dirty     bool   `json:"dirty"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &heads))
+	require.Len(t, heads.Heads, 1)
+	assert.Equal(t, uint64(2), heads.MaxHeight)
+	assert.False(t, heads.Dirty)
+
+	w = debugRequest(router, "/v1/debug/crdt/dag?depth=1", "s3cret")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, strings.HasPrefix(w.Body.String(), "digraph CRDTDAG {"))
+	assert.Contains(t, w.Body.String(), heads.Heads[0].Cid)
+
+	assert.Equal(t, http.StatusBadRequest, debugRequest(router, "/v1/debug/crdt/dag?depth=-1", "s3cret").Code)
+}
+
+func TestDebugCRDTKeys(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	viper.Set("admin.token", "s3cret")
+	router := newDebugRouter(t)
+
+	w := debugRequest(router, "/v1/debug/crdt/keys?prefix=/peers&values=true", "s3cret")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"keys":[{"key":"/peers/QmA","size":22,"value":"{\"schema\":2,\"data\":{}}"}]}`, w.Body.String())
+
+	w = debugRequest(router, "/v1/debug/crdt/keys", "s3cret")
+	require.Equal(t, http.StatusOK, w.Code)
+	var keys struct {
+		Keys []map[string]any `json:"keys"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &keys))
+	assert.Len(t, keys.Keys, 2)
+	assert.NotContains(t, keys.Keys[0], "value")
+}
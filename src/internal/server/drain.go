@@ -0,0 +1,122 @@
+package server
+
+import (
+	"net/http"
+	"ocf/internal/common"
+	"ocf/internal/protocol"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+)
+
+const defaultDrainTimeout = 2 * time.Minute
+
+// drainTracker counts forwarded requests in flight so that shutdown can wait
+// for them, and rejects new ones once draining has started. The draining
+// check and the count change under one lock, so no request slips in after
+// the drain has seen the count reach zero.
+type drainTracker struct {
+	mu       sync.Mutex
+	active   int64
+	draining bool
+	// idle is closed once draining has started and no request is left.
+	idle chan struct{}
+}
+
+var drain = &drainTracker{}
+
+// middleware rejects requests with 503 once draining has started and tracks
+// the rest until they complete, including streamed responses.
+func (d *drainTracker) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !d.enter() {
+			c.Header("Connection", "close")
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "node is draining"})
+			return
+		}
+		defer d.leave()
+		c.Next()
+	}
+}
+
+// enter counts a new request, unless draining has started.
+func (d *drainTracker) enter() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.draining {
+		return false
+	}
+	d.active++
+	return true
+}
+
+func (d *drainTracker) leave() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.active--
+	if d.draining && d.active == 0 {
+		close(d.idle)
+	}
+}
+
+// inFlight returns the number of requests being served.
+func (d *drainTracker) inFlight() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.active
+}
+
+// start stops accepting new requests.
+func (d *drainTracker) start() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.draining {
+		return
+	}
+	d.draining = true
+	d.idle = make(chan struct{})
+	if d.active == 0 {
+		close(d.idle)
+	}
+}
+
+// wait blocks until every in-flight request is done or timeout elapses. It
+// reports whether all requests finished. It starts draining if start has
+// not been called yet.
+func (d *drainTracker) wait(timeout time.Duration) bool {
+	d.start()
+	d.mu.Lock()
+	idle := d.idle
+	d.mu.Unlock()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-idle:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func drainTimeout() time.Duration {
+	if d := viper.GetDuration("shutdown.drain_timeout"); d > 0 {
+		return d
+	}
+	return defaultDrainTimeout
+}
+
+// drainNode announces that this node is draining, stops accepting forwarded
+// requests and waits for the in-flight ones.
+func drainNode() {
+	protocol.MarkDraining()
+	drain.start()
+	timeout := drainTimeout()
+	common.Logger.Infof("Waiting up to %s for %d in-flight request(s)", timeout, drain.inFlight())
+	if drain.wait(timeout) {
+		common.Logger.Info("All in-flight requests completed")
+	} else {
+		common.Logger.Warnf("Drain timeout reached with %d request(s) still in flight", drain.inFlight())
+	}
+}
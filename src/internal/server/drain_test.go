@@ -0,0 +1,80 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDrainTrackerWaitsForInflight(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	d := &drainTracker{}
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	router := gin.New()
+	router.GET("/slow", d.middleware(), func(c *gin.Context) {
+		close(started)
+		<-release
+		c.Status(http.StatusOK)
+	})
+
+	done := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/slow", nil)
+		router.ServeHTTP(w, req)
+		done <- w.Code
+	}()
+	<-started
+
+	d.start()
+	assert.False(t, d.wait(20*time.Millisecond), "drain should not finish while a request is in flight")
+
+	// new requests are rejected while draining
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/slow", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	close(release)
+	assert.Equal(t, http.StatusOK, <-done)
+	assert.True(t, d.wait(time.Second), "drain should finish once in-flight requests complete")
+}
+
+func TestDrainTrackerNoRequestsAfterIdle(t *testing.T) {
+	d := &drainTracker{}
+	var running atomic.Int64
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if d.enter() {
+					running.Add(1)
+					running.Add(-1)
+					d.leave()
+				}
+			}
+		}()
+	}
+
+	assert.True(t, d.wait(time.Second), "drain should finish once the running requests complete")
+	assert.Zero(t, running.Load(), "no request may start after the drain finished")
+	assert.False(t, d.enter(), "new requests are rejected while draining")
+	close(stop)
+	wg.Wait()
+}
@@ -0,0 +1,289 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"ocf/internal/protocol"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	gostream "github.com/libp2p/go-libp2p-gostream"
+	p2phttp "github.com/libp2p/go-libp2p-http"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	syncTimeout = 10 * time.Second
+	syncTick    = 50 * time.Millisecond
+	mockModel   = "m"
+	mockReply   = `{"id":"chatcmpl-1","object":"chat.completion","model":"m","choices":[{"index":0,"message":{"role":"assistant","content":"hello"}}]}`
+)
+
+// testNetwork runs several nodes in this process, linked over a libp2p
+// mocknet. Each node serves the forwarding routes to its peers over libp2p,
+// and to the test over a loopback HTTP server.
+type testNetwork struct {
+	t     *testing.T
+	ctx   context.Context
+	mn    mocknet.Mocknet
+	nodes []*testNode
+}
+
+type testNode struct {
+	*protocol.Node
+	// api is the node's HTTP API, as a client of the node would reach it.
+	api *httptest.Server
+}
+
+// newTestNetwork starts n connected nodes.
+func newTestNetwork(t *testing.T, n int) *testNetwork {
+	t.Helper()
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	t.Setenv("HOME", t.TempDir())
+	gin.SetMode(gin.TestMode)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	nw := &testNetwork{t: t, ctx: ctx, mn: mocknet.New()}
+	t.Cleanup(func() { nw.mn.Close() })
+	for i := 0; i < n; i++ {
+		nw.start()
+	}
+	nw.connect()
+	return nw
+}
+
+// addNode starts another node and connects it to the network.
+func (nw *testNetwork) addNode() *testNode {
+	nw.t.Helper()
+	node := nw.start()
+	nw.connect()
+	return node
+}
+
+// start creates a node without connecting it. Bitswap only learns about
+// connections made after the node is up, so connecting is left to connect.
+func (nw *testNetwork) start() *testNode {
+	t := nw.t
+	t.Helper()
+	h, err := nw.mn.GenPeer()
+	require.NoError(t, err)
+	n, err := protocol.NewNode(nw.ctx, protocol.NodeConfig{
+		Mode:                "test",
+		Host:                h,
+		RebroadcastInterval: 200 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { n.Close() })
+
+	router := gin.New()
+	registerForwardRoutes(router.Group("/v1"), n)
+	listener, err := gostream.Listen(h, p2phttp.DefaultP2PProtocol)
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go http.Serve(listener, router)
+	api := httptest.NewServer(router)
+	t.Cleanup(api.Close)
+
+	node := &testNode{Node: n, api: api}
+	nw.nodes = append(nw.nodes, node)
+	return node
+}
+
+func (nw *testNetwork) connect() {
+	nw.t.Helper()
+	require.NoError(nw.t, nw.mn.LinkAll())
+	require.NoError(nw.t, nw.mn.ConnectAllButSelf())
+}
+
+// disconnect cuts the link between a and b, so they cannot reconnect.
+func (nw *testNetwork) disconnect(a, b *testNode) {
+	nw.t.Helper()
+	require.NoError(nw.t, nw.mn.UnlinkPeers(a.ID(), b.ID()))
+	require.NoError(nw.t, nw.mn.DisconnectPeers(a.ID(), b.ID()))
+}
+
+// mockOpenAI is an OpenAI compatible backend serving a single model.
+type mockOpenAI struct {
+	*httptest.Server
+	completions atomic.Int32
+}
+
+func newMockOpenAI(t *testing.T) *mockOpenAI {
+	t.Helper()
+	m := &mockOpenAI{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/v1/models", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"object":"list","data":[{"id":"`+mockModel+`"}]}`)
+	})
+	mux.HandleFunc("/v1/chat/completions", func(w http.ResponseWriter, r *http.Request) {
+		m.completions.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, mockReply)
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *mockOpenAI) port(t *testing.T) string {
+	t.Helper()
+	u, err := url.Parse(m.URL)
+	require.NoError(t, err)
+	_, port, err := net.SplitHostPort(u.Host)
+	require.NoError(t, err)
+	return port
+}
+
+// registerLLM announces backend as the llm service of node.
+func registerLLM(t *testing.T, node *testNode, backend *mockOpenAI) {
+	t.Helper()
+	node.Registrar().Initialize("")
+	node.Registrar().RegisterLLMService(backend.port(t))
+}
+
+// providers returns the IDs of the llm providers node knows about.
+func providers(node *testNode) []string {
+	peers, err := node.GetAllProviders("llm")
+	if err != nil {
+		return nil
+	}
+	var ids []string
+	for _, p := range peers {
+		ids = append(ids, p.ID)
+	}
+	return ids
+}
+
+func chatCompletion(t *testing.T, node *testNode) *http.Response {
+	t.Helper()
+	body := bytes.NewBufferString(`{"model":"` + mockModel + `","messages":[{"role":"user","content":"hi"}]}`)
+	resp, err := http.Post(node.api.URL+"/v1/service/llm/v1/chat/completions", "application/json", body)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestHarnessJoin(t *testing.T) {
+	nw := newTestNetwork(t, 2)
+	provider := nw.nodes[0]
+	registerLLM(t, provider, newMockOpenAI(t))
+
+	late := nw.addNode()
+	require.Eventually(t, func() bool {
+		ids := providers(late)
+		return len(ids) == 1 && ids[0] == provider.ID().String()
+	}, syncTimeout, syncTick, "the late node never synced the node table")
+	connected := make(map[string]bool)
+	for _, p := range *late.GetConnectedPeers() {
+		connected[p.ID] = true
+	}
+	for _, other := range nw.nodes[:2] {
+		require.True(t, connected[other.ID().String()], "the late node is not connected to %s", other.ID())
+	}
+}
+
+func TestHarnessServiceRegistration(t *testing.T) {
+	nw := newTestNetwork(t, 2)
+	provider, consumer := nw.nodes[0], nw.nodes[1]
+	backend := newMockOpenAI(t)
+	registerLLM(t, provider, backend)
+
+	service, err := provider.GetService("llm")
+	require.NoError(t, err)
+	require.Equal(t, backend.port(t), service.Port)
+	require.Eventually(t, func() bool {
+		peers, err := consumer.GetAllProviders("llm")
+		if err != nil || len(peers) != 1 || len(peers[0].Service) != 1 {
+			return false
+		}
+		return peers[0].ID == provider.ID().String() &&
+			len(peers[0].Service[0].IdentityGroup) == 1 &&
+			peers[0].Service[0].IdentityGroup[0] == "model="+mockModel
+	}, syncTimeout, syncTick, "the consumer never saw the provider's service")
+}
+
+func TestHarnessGlobalForwarding(t *testing.T) {
+	nw := newTestNetwork(t, 2)
+	provider, consumer := nw.nodes[0], nw.nodes[1]
+	backend := newMockOpenAI(t)
+	registerLLM(t, provider, backend)
+	require.Eventually(t, func() bool { return len(providers(consumer)) == 1 }, syncTimeout, syncTick)
+
+	resp := chatCompletion(t, consumer)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	require.JSONEq(t, mockReply, string(body))
+	require.Equal(t, provider.ID().String(), resp.Header.Get("X-Computing-Node"))
+	require.Equal(t, int32(1), backend.completions.Load())
+}
+
+func TestHarnessDisconnect(t *testing.T) {
+	nw := newTestNetwork(t, 2)
+	provider, consumer := nw.nodes[0], nw.nodes[1]
+	backend := newMockOpenAI(t)
+	registerLLM(t, provider, backend)
+	require.Eventually(t, func() bool { return len(providers(consumer)) == 1 }, syncTimeout, syncTick)
+	// The fallback lookup goes through the consumer's own DHT.
+	require.Eventually(t, func() bool {
+		found, err := consumer.FindProvidersInDHT(nw.ctx, "llm", []string{"model=" + mockModel})
+		return err == nil && len(found) == 1 && found[0].ID == provider.ID().String()
+	}, syncTimeout, syncTick, "the consumer's DHT never listed the provider")
+
+	nw.disconnect(provider, consumer)
+	require.Eventually(t, func() bool { return len(providers(consumer)) == 0 }, syncTimeout, syncTick,
+		"the consumer still lists the disconnected provider")
+	_, err := consumer.FindProvidersInDHT(nw.ctx, "llm", []string{"model=" + mockModel})
+	require.Error(t, err)
+	resp := chatCompletion(t, consumer)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	require.Contains(t, string(body), "No provider found")
+	require.Equal(t, int32(0), backend.completions.Load())
+}
+
+func TestHarnessStalePeerCleanup(t *testing.T) {
+	nw := newTestNetwork(t, 3)
+	provider, consumer, observer := nw.nodes[0], nw.nodes[1], nw.nodes[2]
+	registerLLM(t, provider, newMockOpenAI(t))
+	for _, node := range []*testNode{consumer, observer} {
+		require.Eventually(t, func() bool { return len(providers(node)) == 1 }, syncTimeout, syncTick)
+	}
+
+	// The provider goes away without withdrawing its entry.
+	require.NoError(t, provider.Close())
+	nw.disconnect(provider, consumer)
+	nw.disconnect(provider, observer)
+	require.Eventually(t, func() bool {
+		p, err := observer.GetPeer(provider.ID().String())
+		return err == nil && !p.Connected
+	}, syncTimeout, syncTick)
+
+	// Well past its lease and the tombstone delay, the consumer drops it and
+	// tombstones it for everyone else.
+	dropped, tombstoned := consumer.ExpireLeases(time.Now().Add(2 * time.Hour))
+	require.Equal(t, 1, dropped)
+	require.Equal(t, 1, tombstoned)
+	_, err := consumer.GetPeer(provider.ID().String())
+	require.Error(t, err)
+	require.Eventually(t, func() bool {
+		_, err := observer.GetPeer(provider.ID().String())
+		return err != nil
+	}, syncTimeout, syncTick, "the observer never applied the tombstone")
+}
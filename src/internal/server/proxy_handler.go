@@ -5,7 +5,6 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"math/rand"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
@@ -19,8 +18,11 @@ import (
 	"github.com/buger/jsonparser"
 	"github.com/gin-gonic/gin"
 	p2phttp "github.com/libp2p/go-libp2p-http"
+	"github.com/spf13/viper"
 )
 
+const defaultLargePayloadBytes = 1 << 20
+
 func ErrorHandler(res http.ResponseWriter, req *http.Request, err error) {
     if _, werr := res.Write([]byte(fmt.Sprintf("ERROR: %s", err.Error()))); werr != nil {
         common.Logger.Error("Error writing error response: ", werr)
@@ -49,8 +51,41 @@ func (s *StreamAwareResponseWriter) Flush() {
     }
 }
 
+// forwarder forwards requests to peers and services through a node.
+type forwarder struct {
+	node *protocol.Node
+}
+
+// registerForwardRoutes installs the routes forwarding requests to peers
+// and services through node.
+func registerForwardRoutes(v1 *gin.RouterGroup, node *protocol.Node) {
+	f := &forwarder{node: node}
+	p2pGroup := v1.Group("/p2p", drain.middleware())
+	{
+		p2pGroup.PATCH("/:peerId/*path", f.p2p)
+		p2pGroup.POST("/:peerId/*path", f.p2p)
+		p2pGroup.GET("/:peerId/*path", f.p2p)
+	}
+	globalServiceGroup := v1.Group("/service", drain.middleware())
+	{
+		globalServiceGroup.GET("/:service/*path", f.globalService)
+		globalServiceGroup.POST("/:service/*path", f.globalService)
+		globalServiceGroup.PATCH("/:service/*path", f.globalService)
+	}
+	serviceGroup := v1.Group("/_service", drain.middleware())
+	{
+		serviceGroup.GET("/:service/*path", f.service)
+		serviceGroup.POST("/:service/*path", f.service)
+		serviceGroup.PATCH("/:service/*path", f.service)
+	}
+}
+
 // P2P handler for forwarding requests to other peers
 func P2PForwardHandler(c *gin.Context) {
+	(&forwarder{node: protocol.DefaultNode()}).p2p(c)
+}
+
+func (f *forwarder) p2p(c *gin.Context) {
 	// Set a longer timeout for AI/ML services
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 15*time.Minute)
 	defer cancel()
@@ -63,7 +98,7 @@ func P2PForwardHandler(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	event := []axiom.Event{{ingest.TimestampField: time.Now(), "event": "P2P Forward", "from": &protocol.MyID, "to": requestPeer, "path": requestPath}}
+	event := []axiom.Event{{ingest.TimestampField: time.Now(), "event": "P2P Forward", "from": f.node.ID().String(), "to": requestPeer, "path": requestPath}}
 	IngestEvents(event)
 
 	tr := &http.Transport{
@@ -71,8 +106,7 @@ func P2PForwardHandler(c *gin.Context) {
 		IdleConnTimeout:       90 * time.Second, // Keep connections alive for 90 seconds
 		DisableKeepAlives:     false,            // Enable keep-alives for better performance
 	}
-	node, _ := protocol.GetP2PNode(nil)
-	tr.RegisterProtocol("libp2p", p2phttp.NewTransport(node))
+	tr.RegisterProtocol("libp2p", p2phttp.NewTransport(f.node.Host()))
 	target := url.URL{
 		Scheme: "libp2p",
 		Host:   requestPeer,
@@ -97,9 +131,13 @@ func P2PForwardHandler(c *gin.Context) {
 
 // ServiceHandler
 func ServiceForwardHandler(c *gin.Context) {
+	(&forwarder{node: protocol.DefaultNode()}).service(c)
+}
+
+func (f *forwarder) service(c *gin.Context) {
 	serviceName := c.Param("service")
 	requestPath := c.Param("path")
-	service, err := protocol.GetService(serviceName)
+	service, err := f.node.GetService(serviceName)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
@@ -128,6 +166,10 @@ func ServiceForwardHandler(c *gin.Context) {
 
 // in case of global service, we need to forward the request to the service, identified by the service name and identity group
 func GlobalServiceForwardHandler(c *gin.Context) {
+	(&forwarder{node: protocol.DefaultNode()}).globalService(c)
+}
+
+func (f *forwarder) globalService(c *gin.Context) {
 	// Set a longer timeout for AI/ML services
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 15*time.Minute)
 	defer cancel()
@@ -143,61 +185,40 @@ func GlobalServiceForwardHandler(c *gin.Context) {
 
 	serviceName := c.Param("service")
 	requestPath := c.Param("path")
-	providers, err := protocol.GetAllProviders(serviceName)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
-	}
 	// Use the already read bodyBytes instead of reading again
 	body := bodyBytes
-	// find proper service that are within the same identity group
-	// first filter by service name, then iterative over the identity groups
-	// always find all the services that are in the same identity group
-	var candidates []string
-	for _, provider := range providers {
-		for _, service := range provider.Service {
-			if service.Name == serviceName {
-				var selected = false
-				// check if the service is in the same identity group
-				if len(service.IdentityGroup) > 0 {
-					for _, ig := range service.IdentityGroup {
-						igGroup := strings.Split(ig, "=")
-						igKey := igGroup[0]
-						igValue := igGroup[1]
-						requestGroup, err := jsonparser.GetString(body, igKey)
-						if err == nil && requestGroup == igValue {
-							selected = true
-							break
-						}
-					}
-				}
-				// append the service to the candidates
-				if selected {
-					candidates = append(candidates, provider.ID)
-				}
-			}
+	providers, _ := f.node.GetAllProviders(serviceName)
+	candidates, owners := matchCandidates(providers, serviceName, body)
+	if len(candidates) < 1 {
+		// The node table may still be syncing; ask the DHT instead.
+		found, err := f.node.FindProvidersInDHT(ctx, serviceName, requestIdentityGroups(body))
+		if err != nil {
+			common.Logger.Debugf("DHT fallback for %s found nothing: %v", serviceName, err)
 		}
+		candidates, owners = matchCandidates(found, serviceName, body)
 	}
 	if len(candidates) < 1 {
 		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "No provider found for the requested service."})
 		return
 	}
 
-	// randomly select one of the candidates
-	// here's where we can implement a load balancing algorithm
-	randomIndex := rand.Intn(len(candidates))
+	// relayed connections are slow and capped, keep large payloads off them
+	if len(body) > largePayloadBytes() {
+		candidates = preferDirectCandidates(f.node, candidates)
+	}
+
+	// pick a candidate at random, weighted by its reputation
+	targetPeer := protocol.SelectByReputation(candidates)
 	tr := &http.Transport{
 		ResponseHeaderTimeout: 10 * time.Minute,
 		IdleConnTimeout:       360 * time.Second,
 		DisableKeepAlives:     false,
 	}
-	node, _ := protocol.GetP2PNode(nil)
-	tr.RegisterProtocol("libp2p", p2phttp.NewTransport(node))
-	targetPeer := candidates[randomIndex]
+	tr.RegisterProtocol("libp2p", p2phttp.NewTransport(f.node.Host()))
 	// replace the request path with the _service path
 	requestPath = "/v1/_service/" + serviceName + requestPath
 
-	event := []axiom.Event{{ingest.TimestampField: time.Now(), "event": "Service Forward", "from": protocol.MyID, "to": targetPeer, "path": requestPath, "service": serviceName}}
+	event := []axiom.Event{{ingest.TimestampField: time.Now(), "event": "Service Forward", "from": f.node.ID().String(), "to": targetPeer, "path": requestPath, "service": serviceName, "owner": owners[targetPeer]}}
 	IngestEvents(event)
 
 	common.Logger.Info("Forwarding request to: ", targetPeer)
@@ -218,8 +239,13 @@ func GlobalServiceForwardHandler(c *gin.Context) {
 	proxy := httputil.NewSingleHostReverseProxy(&target)
 	proxy.Director = director
 	proxy.Transport = tr
-	proxy.ErrorHandler = ErrorHandler
+	started := time.Now()
+	proxy.ErrorHandler = func(res http.ResponseWriter, req *http.Request, err error) {
+		protocol.RecordRequestResult(targetPeer, false, 0)
+		ErrorHandler(res, req, err)
+	}
 	proxy.ModifyResponse = func(r *http.Response) error {
+		protocol.RecordRequestResult(targetPeer, r.StatusCode < http.StatusInternalServerError, time.Since(started))
 		rewriteHeader()(r)
 		r.Header.Set("X-Computing-Node", targetPeer)
 		return nil
@@ -233,3 +259,72 @@ func GlobalServiceForwardHandler(c *gin.Context) {
 
 	proxy.ServeHTTP(streamWriter, c.Request)
 }
+
+// matchCandidates returns the providers whose service serves the identity
+// group the request asks for, along with their verified owners.
+func matchCandidates(providers []protocol.Peer, serviceName string, body []byte) ([]string, map[string]string) {
+	// first filter by service name, then iterative over the identity groups
+	// always find all the services that are in the same identity group
+	var candidates []string
+	owners := make(map[string]string)
+	for _, provider := range providers {
+		for _, service := range provider.Service {
+			if service.Name == serviceName {
+				var selected = false
+				// check if the service is in the same identity group
+				if len(service.IdentityGroup) > 0 {
+					for _, ig := range service.IdentityGroup {
+						igGroup := strings.Split(ig, "=")
+						igKey := igGroup[0]
+						igValue := igGroup[1]
+						requestGroup, err := jsonparser.GetString(body, igKey)
+						if err == nil && requestGroup == igValue {
+							selected = true
+							break
+						}
+					}
+				}
+				// append the service to the candidates
+				if selected {
+					candidates = append(candidates, provider.ID)
+					owners[provider.ID] = provider.VerifiedOwner()
+				}
+			}
+		}
+	}
+	return candidates, owners
+}
+
+// requestIdentityGroups returns the identity groups a request asks for, in
+// the "key=value" form services register them with.
+func requestIdentityGroups(body []byte) []string {
+	var groups []string
+	if model, err := jsonparser.GetString(body, "model"); err == nil && model != "" {
+		groups = append(groups, "model="+model)
+	}
+	return groups
+}
+
+// largePayloadBytes is the request size above which relayed providers are
+// avoided.
+func largePayloadBytes() int {
+	if n := viper.GetInt("routing.large_payload_bytes"); n > 0 {
+		return n
+	}
+	return defaultLargePayloadBytes
+}
+
+// preferDirectCandidates drops candidates that are only reachable through a
+// relay, unless that would leave none.
+func preferDirectCandidates(node *protocol.Node, candidates []string) []string {
+	var direct []string
+	for _, candidate := range candidates {
+		if node.HasDirectConnection(candidate) {
+			direct = append(direct, candidate)
+		}
+	}
+	if len(direct) == 0 {
+		return candidates
+	}
+	return direct
+}
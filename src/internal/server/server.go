@@ -81,9 +81,11 @@ func StartServer() {
 	}
 	owner := ""
 
+	protocol.SetInFlightReporter(drain.inFlight)
 	protocol.InitializeMyself(owner)
 	_, cancelCtx := protocol.GetCRDTStore()
 	defer cancelCtx()
+	protocol.StartMDNS()
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL)
 	defer stop()
 
@@ -117,27 +119,26 @@ func StartServer() {
 			crdtGroup.GET("/peers_status", listPeersWithStatus)
 			crdtGroup.GET("/bootstraps", listBootstraps)
 			crdtGroup.GET("/stats", getResourceStats) // Add resource manager stats endpoint
+			crdtGroup.GET("/reputation", listReputations)
+			crdtGroup.GET("/latency", listLatencies)
+			crdtGroup.GET("/connectivity", getConnectivity)
+			crdtGroup.GET("/heartbeats", listHeartbeats)
+			crdtGroup.GET("/versions", getVersionDistribution)
+			crdtGroup.GET("/snapshot", exportSnapshot)
 			crdtGroup.POST("/_node", updateLocal)
 			crdtGroup.DELETE("/_node", deleteLocal)
 		}
-		p2pGroup := v1.Group("/p2p")
+		debugGroup := v1.Group("/debug/crdt", adminAuth())
 		{
-			p2pGroup.PATCH("/:peerId/*path", P2PForwardHandler)
-			p2pGroup.POST("/:peerId/*path", P2PForwardHandler)
-			p2pGroup.GET("/:peerId/*path", P2PForwardHandler)
-		}
-		globalServiceGroup := v1.Group("/service")
-		{
-			globalServiceGroup.GET("/:service/*path", GlobalServiceForwardHandler)
-			globalServiceGroup.POST("/:service/*path", GlobalServiceForwardHandler)
-			globalServiceGroup.PATCH("/:service/*path", GlobalServiceForwardHandler)
-		}
-		serviceGroup := v1.Group("/_service")
-		{
-			serviceGroup.GET("/:service/*path", ServiceForwardHandler)
-			serviceGroup.POST("/:service/*path", ServiceForwardHandler)
-			serviceGroup.PATCH("/:service/*path", ServiceForwardHandler)
+			debugGroup.GET("/heads", debugCRDTHeads)
+			debugGroup.GET("/dag", debugCRDTDag)
+			debugGroup.GET("/jobs", debugCRDTJobs)
+			debugGroup.GET("/keys", debugCRDTKeys)
+			debugGroup.POST("/repair", debugCRDTRepair)
+			debugGroup.POST("/sync", debugCRDTSync)
 		}
+		registerAccessRoutes(v1)
+		registerForwardRoutes(v1, protocol.DefaultNode())
 	}
 	p2plistener := P2PListener()
 	srv := &http.Server{
@@ -159,9 +160,16 @@ func StartServer() {
 		protocol.RegisterLocalServices()
 	}()
 	<-ctx.Done()
-	// shutting down...
+	stop()
+	// shutting down: drain first so that long streams can finish, then leave
+	common.Logger.Info("Shutdown requested, draining (send the signal again to exit immediately)")
+	drainNode()
+	process.NewProcessManager().StopAllProcesses()
+	protocol.StopMDNS()
 	protocol.DeleteNodeTable()
-	protocol.ClearCRDTStore()
+	if !protocol.PersistentCRDT() {
+		protocol.ClearCRDTStore()
+	}
 	time.Sleep(5 * time.Second)
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	common.Logger.Info("Shutting down server gracefully")
@@ -1,14 +1,17 @@
 package wallet
 
 import (
+	"bytes"
 	"crypto/ed25519"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"ocf/internal/common"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/mr-tron/base58"
@@ -21,6 +24,9 @@ const (
 	defaultAccountsFile = "accounts.json"
 	legacyWalletFile    = "wallet.json"
 	accountsDirName     = "accounts"
+
+	ExportFormatJSON   = "json"
+	ExportFormatBase58 = "base58"
 )
 
 type Account struct {
@@ -35,15 +41,18 @@ type WalletManager struct {
 	storageDir  string
 	storagePath string
 	accounts    []Account
+	// defaultKey is the public key of the account selected with
+	// `ocf wallet use`. When empty, the first account is the default.
+	defaultKey string
 }
 
-func NewWalletManager() (*WalletManager, error) {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return nil, fmt.Errorf("unable to determine home directory: %w", err)
-	}
+type accountsFile struct {
+	Default  string    `json:"default,omitempty"`
+	Accounts []Account `json:"accounts"`
+}
 
-	baseDir := filepath.Join(homeDir, ".ocf")
+func NewWalletManager() (*WalletManager, error) {
+	baseDir := common.GetWalletPath()
 	if err := os.MkdirAll(baseDir, 0o700); err != nil {
 		return nil, fmt.Errorf("failed to ensure wallet directory: %w", err)
 	}
@@ -69,14 +78,13 @@ func (wm *WalletManager) loadAccounts() error {
 		return fmt.Errorf("failed to read accounts file: %w", err)
 	}
 
-	var payload struct {
-		Accounts []Account `json:"accounts"`
-	}
+	var payload accountsFile
 	if err := json.Unmarshal(data, &payload); err != nil {
 		return fmt.Errorf("failed to parse accounts file: %w", err)
 	}
 
 	wm.accounts = payload.Accounts
+	wm.defaultKey = payload.Default
 	return nil
 }
 
@@ -111,9 +119,8 @@ func (wm *WalletManager) migrateLegacyWallet() error {
 }
 
 func (wm *WalletManager) saveAccounts() error {
-	payload := struct {
-		Accounts []Account `json:"accounts"`
-	}{
+	payload := accountsFile{
+		Default:  wm.defaultKey,
 		Accounts: wm.accounts,
 	}
 	data, err := json.MarshalIndent(payload, "", "  ")
@@ -136,6 +143,11 @@ func (wm *WalletManager) DefaultAccount() (Account, error) {
 	if len(wm.accounts) == 0 {
 		return Account{}, errors.New("no managed accounts")
 	}
+	if wm.defaultKey != "" {
+		if acc, ok := wm.FindByPublicKey(wm.defaultKey); ok {
+			return acc, nil
+		}
+	}
 	return wm.accounts[0], nil
 }
 
@@ -145,6 +157,10 @@ func (wm *WalletManager) AddSolanaAccount() (Account, error) {
 		return Account{}, fmt.Errorf("failed to generate Solana keypair: %w", err)
 	}
 
+	return wm.storeSolanaAccount(public, private)
+}
+
+func (wm *WalletManager) storeSolanaAccount(public ed25519.PublicKey, private ed25519.PrivateKey) (Account, error) {
 	pub58 := base58.Encode(public)
 	accountDir := filepath.Join(wm.storageDir, accountsDirName, pub58)
 	if err := os.MkdirAll(accountDir, 0o700); err != nil {
@@ -170,6 +186,61 @@ func (wm *WalletManager) AddSolanaAccount() (Account, error) {
 	return account, nil
 }
 
+// ImportSolanaAccount imports an existing Solana keypair. The input may be
+// either the JSON byte array written by `solana-keygen` or a base58 encoded
+// 64-byte secret key.
+func (wm *WalletManager) ImportSolanaAccount(data []byte) (Account, error) {
+	private, err := parseSolanaSecret(data)
+	if err != nil {
+		return Account{}, err
+	}
+	public := private.Public().(ed25519.PublicKey)
+	if _, ok := wm.FindByPublicKey(base58.Encode(public)); ok {
+		return Account{}, fmt.Errorf("account %s is already managed", base58.Encode(public))
+	}
+	return wm.storeSolanaAccount(public, private)
+}
+
+func parseSolanaSecret(data []byte) (ed25519.PrivateKey, error) {
+	trimmed := strings.TrimSpace(string(data))
+	if trimmed == "" {
+		return nil, errors.New("empty secret key")
+	}
+
+	var raw []byte
+	if strings.HasPrefix(trimmed, "[") {
+		var keyInts []int
+		if err := json.Unmarshal([]byte(trimmed), &keyInts); err != nil {
+			return nil, fmt.Errorf("failed to parse Solana keypair: %w", err)
+		}
+		raw = make([]byte, len(keyInts))
+		for i, v := range keyInts {
+			if v < 0 || v > 255 {
+				return nil, fmt.Errorf("invalid byte value %d in Solana keypair", v)
+			}
+			raw[i] = byte(v)
+		}
+	} else {
+		decoded, err := base58.Decode(trimmed)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode base58 secret: %w", err)
+		}
+		raw = decoded
+	}
+
+	if len(raw) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("secret key must be %d bytes, got %d", ed25519.PrivateKeySize, len(raw))
+	}
+	private := ed25519.PrivateKey(raw)
+	// The trailing 32 bytes of a Solana secret are the public key; make sure
+	// they match the seed so we never store an inconsistent keypair.
+	derived := ed25519.NewKeyFromSeed(private.Seed())
+	if !bytes.Equal(derived, private) {
+		return nil, errors.New("secret key does not match its embedded public key")
+	}
+	return private, nil
+}
+
 func writeSolanaKeypair(path string, private ed25519.PrivateKey) error {
 	keyInts := make([]int, len(private))
 	for i, b := range private {
@@ -194,6 +265,138 @@ func (wm *WalletManager) FindByFile(path string) (Account, bool) {
 	return Account{}, false
 }
 
+func (wm *WalletManager) FindByPublicKey(publicKey string) (Account, bool) {
+	for _, acc := range wm.accounts {
+		if acc.PublicKey == publicKey {
+			return acc, true
+		}
+	}
+	return Account{}, false
+}
+
+// SetDefaultAccount selects the account used by DefaultAccount.
+func (wm *WalletManager) SetDefaultAccount(publicKey string) error {
+	if _, ok := wm.FindByPublicKey(publicKey); !ok {
+		return fmt.Errorf("account %s not found", publicKey)
+	}
+	wm.defaultKey = publicKey
+	return wm.saveAccounts()
+}
+
+// RemoveAccount forgets an account and deletes its keypair file when the
+// file is stored under the wallet directory. Keypairs that were referenced
+// from elsewhere are left untouched.
+func (wm *WalletManager) RemoveAccount(publicKey string) error {
+	idx := -1
+	for i, acc := range wm.accounts {
+		if acc.PublicKey == publicKey {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return fmt.Errorf("account %s not found", publicKey)
+	}
+
+	account := wm.accounts[idx]
+	wm.accounts = append(wm.accounts[:idx], wm.accounts[idx+1:]...)
+	if wm.defaultKey == publicKey {
+		wm.defaultKey = ""
+	}
+	if err := wm.saveAccounts(); err != nil {
+		return err
+	}
+
+	accountDir := filepath.Join(wm.storageDir, accountsDirName, account.PublicKey)
+	if account.FilePath != "" && filepath.Dir(account.FilePath) == accountDir {
+		if err := os.RemoveAll(accountDir); err != nil {
+			return fmt.Errorf("failed to remove keypair: %w", err)
+		}
+	}
+	return nil
+}
+
+// ExportAccount returns the secret key of an account, either as a Solana
+// keypair JSON array (format "json") or as a base58 string (format "base58").
+func (wm *WalletManager) ExportAccount(publicKey string, format string) ([]byte, error) {
+	account, ok := wm.FindByPublicKey(publicKey)
+	if !ok {
+		return nil, fmt.Errorf("account %s not found", publicKey)
+	}
+	private, err := account.privateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case "", ExportFormatJSON:
+		keyInts := make([]int, len(private))
+		for i, b := range private {
+			keyInts[i] = int(b)
+		}
+		return json.Marshal(keyInts)
+	case ExportFormatBase58:
+		return []byte(base58.Encode(private)), nil
+	default:
+		return nil, fmt.Errorf("unsupported export format %q", format)
+	}
+}
+
+// Sign signs message with the account identified by publicKey, or with the
+// default account if publicKey is empty.
+func (wm *WalletManager) Sign(publicKey string, message []byte) ([]byte, error) {
+	var account Account
+	if publicKey == "" {
+		acc, err := wm.DefaultAccount()
+		if err != nil {
+			return nil, err
+		}
+		account = acc
+	} else {
+		acc, ok := wm.FindByPublicKey(publicKey)
+		if !ok {
+			return nil, fmt.Errorf("account %s not found", publicKey)
+		}
+		account = acc
+	}
+	private, err := account.privateKey()
+	if err != nil {
+		return nil, err
+	}
+	return ed25519.Sign(private, message), nil
+}
+
+// Verify checks an ed25519 signature against a public key encoded the way
+// accounts store it (base58 for Solana, base64 for legacy OCF wallets).
+func Verify(publicKey string, message []byte, signature []byte) (bool, error) {
+	pub, err := decodePublicKey(publicKey)
+	if err != nil {
+		return false, err
+	}
+	return ed25519.Verify(pub, message, signature), nil
+}
+
+func decodePublicKey(publicKey string) (ed25519.PublicKey, error) {
+	if raw, err := base58.Decode(publicKey); err == nil && len(raw) == ed25519.PublicKeySize {
+		return ed25519.PublicKey(raw), nil
+	}
+	if raw, err := base64.StdEncoding.DecodeString(publicKey); err == nil && len(raw) == ed25519.PublicKeySize {
+		return ed25519.PublicKey(raw), nil
+	}
+	return nil, fmt.Errorf("invalid public key %q", publicKey)
+}
+
+func (acc Account) privateKey() (ed25519.PrivateKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(acc.Private)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode private key: %w", err)
+	}
+	if len(raw) != ed25519.PrivateKeySize {
+		return nil, errors.New("private key has invalid size")
+	}
+	return ed25519.PrivateKey(raw), nil
+}
+
 func (wm *WalletManager) WalletExists() bool {
 	return len(wm.accounts) > 0
 }
@@ -9,6 +9,8 @@ import (
 	"path/filepath"
 	"testing"
 	"time"
+
+	"github.com/mr-tron/base58"
 )
 
 func TestNewWalletManager(t *testing.T) {
@@ -673,4 +675,192 @@ func TestInitializeWallet(t *testing.T) {
 			t.Error("Wallet manager should be nil when initialization fails")
 		}
 	})
-}
\ No newline at end of file
+}
+
+func newTestWalletManager(t *testing.T) *WalletManager {
+	t.Helper()
+	ocfDir := filepath.Join(t.TempDir(), ".ocf")
+	if err := os.MkdirAll(ocfDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	return &WalletManager{
+		storageDir:  ocfDir,
+		storagePath: filepath.Join(ocfDir, "accounts.json"),
+		accounts:    []Account{},
+	}
+}
+
+func TestWalletManagerImportSolanaAccount(t *testing.T) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := base58.Encode(private.Public().(ed25519.PublicKey))
+
+	keyInts := make([]int, len(private))
+	for i, b := range private {
+		keyInts[i] = int(b)
+	}
+	jsonKeypair, _ := json.Marshal(keyInts)
+
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{name: "json keypair", input: jsonKeypair},
+		{name: "base58 secret", input: []byte(base58.Encode(private))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wm := newTestWalletManager(t)
+			account, err := wm.ImportSolanaAccount(tt.input)
+			if err != nil {
+				t.Fatalf("Unexpected error importing account: %v", err)
+			}
+			if account.PublicKey != expected {
+				t.Errorf("Expected public key %s, got %s", expected, account.PublicKey)
+			}
+			if _, err := wm.ImportSolanaAccount(tt.input); err == nil {
+				t.Error("Expected error importing a duplicate account")
+			}
+		})
+	}
+
+	t.Run("invalid secret", func(t *testing.T) {
+		wm := newTestWalletManager(t)
+		if _, err := wm.ImportSolanaAccount([]byte("not-a-key")); err == nil {
+			t.Error("Expected error importing an invalid secret")
+		}
+		tampered := make([]byte, len(private))
+		copy(tampered, private)
+		tampered[len(tampered)-1] ^= 0xff
+		if _, err := wm.ImportSolanaAccount([]byte(base58.Encode(tampered))); err == nil {
+			t.Error("Expected error importing a keypair with a mismatched public key")
+		}
+	})
+}
+
+func TestWalletManagerExportRoundTrip(t *testing.T) {
+	wm := newTestWalletManager(t)
+	account, err := wm.AddSolanaAccount()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{ExportFormatJSON, ExportFormatBase58} {
+		data, err := wm.ExportAccount(account.PublicKey, format)
+		if err != nil {
+			t.Fatalf("Unexpected error exporting as %s: %v", format, err)
+		}
+		other := newTestWalletManager(t)
+		imported, err := other.ImportSolanaAccount(data)
+		if err != nil {
+			t.Fatalf("Unexpected error re-importing %s export: %v", format, err)
+		}
+		if imported.PublicKey != account.PublicKey {
+			t.Errorf("Expected %s, got %s", account.PublicKey, imported.PublicKey)
+		}
+	}
+
+	if _, err := wm.ExportAccount(account.PublicKey, "pem"); err == nil {
+		t.Error("Expected error for unsupported format")
+	}
+	if _, err := wm.ExportAccount("missing", ExportFormatJSON); err == nil {
+		t.Error("Expected error for unknown account")
+	}
+}
+
+func TestWalletManagerSetDefaultAccount(t *testing.T) {
+	wm := newTestWalletManager(t)
+	first, err := wm.AddSolanaAccount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := wm.AddSolanaAccount()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if wm.GetPublicKey() != first.PublicKey {
+		t.Errorf("Expected first account to be default before selection")
+	}
+	if err := wm.SetDefaultAccount(second.PublicKey); err != nil {
+		t.Fatalf("Unexpected error setting default: %v", err)
+	}
+	if wm.GetPublicKey() != second.PublicKey {
+		t.Errorf("Expected %s to be default, got %s", second.PublicKey, wm.GetPublicKey())
+	}
+	if err := wm.SetDefaultAccount("missing"); err == nil {
+		t.Error("Expected error selecting an unknown account")
+	}
+
+	// The selection must survive a reload from disk.
+	reloaded := &WalletManager{storageDir: wm.storageDir, storagePath: wm.storagePath}
+	if err := reloaded.loadAccounts(); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.GetPublicKey() != second.PublicKey {
+		t.Errorf("Expected persisted default %s, got %s", second.PublicKey, reloaded.GetPublicKey())
+	}
+}
+
+func TestWalletManagerRemoveAccount(t *testing.T) {
+	wm := newTestWalletManager(t)
+	first, err := wm.AddSolanaAccount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := wm.AddSolanaAccount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wm.SetDefaultAccount(second.PublicKey); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := wm.RemoveAccount(second.PublicKey); err != nil {
+		t.Fatalf("Unexpected error removing account: %v", err)
+	}
+	if _, ok := wm.FindByPublicKey(second.PublicKey); ok {
+		t.Error("Removed account should no longer be managed")
+	}
+	if _, err := os.Stat(second.FilePath); !os.IsNotExist(err) {
+		t.Error("Removed account keypair should be deleted")
+	}
+	if wm.GetPublicKey() != first.PublicKey {
+		t.Errorf("Expected default to fall back to %s, got %s", first.PublicKey, wm.GetPublicKey())
+	}
+	if err := wm.RemoveAccount(second.PublicKey); err == nil {
+		t.Error("Expected error removing an unknown account")
+	}
+}
+
+func TestWalletManagerSignVerify(t *testing.T) {
+	wm := newTestWalletManager(t)
+	account, err := wm.AddSolanaAccount()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := []byte("hello ocf")
+	signature, err := wm.Sign("", message)
+	if err != nil {
+		t.Fatalf("Unexpected error signing: %v", err)
+	}
+
+	ok, err := Verify(account.PublicKey, message, signature)
+	if err != nil || !ok {
+		t.Fatalf("Expected valid signature, got ok=%v err=%v", ok, err)
+	}
+	ok, err = Verify(account.PublicKey, []byte("tampered"), signature)
+	if err != nil || ok {
+		t.Errorf("Expected invalid signature for tampered message, got ok=%v err=%v", ok, err)
+	}
+	if _, err := Verify("bogus", message, signature); err == nil {
+		t.Error("Expected error for malformed public key")
+	}
+	if _, err := wm.Sign("missing", message); err == nil {
+		t.Error("Expected error signing with an unknown account")
+	}
+}